@@ -1,6 +1,6 @@
 module mygomal
 
-go 1.13
+go 1.16
 
 require (
 	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
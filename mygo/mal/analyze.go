@@ -0,0 +1,689 @@
+package mal
+
+// The analyzer turns a form into a tree of expressions (the *Expr types below), in which special forms, macro calls
+// and quasiquotes have been expanded and every symbol has been resolved to either a local variable, i.e. a slot in a
+// frame, or a global one. compile.go then turns that tree into Go closures, so that running a form doesn't need to
+// look at its source again.
+//
+// Analyzing hardly fails: a malformed special form becomes an errorExpr, which reports the error when it is evaluated,
+// just like the form itself would have failed only once it was evaluated. Only a recur that is not in tail position
+// of a loop or fn*, or passes the wrong number of values, makes the whole form fail before it runs
+
+import (
+	"fmt"
+)
+
+type expr interface{}
+
+//constExpr is a value that evaluates to itself, or a quoted form
+type constExpr struct {
+	value Type
+}
+
+//localExpr is a local variable, which lives in slot index of the frame depth levels up from the current one
+type localExpr struct {
+	name  *Symbol
+	depth int
+	index int
+}
+
+//globalExpr is a variable of the namespace the expression was analyzed in, see namespace.go
+type globalExpr struct {
+	name *Symbol
+	ns   *namespace
+}
+
+//defExpr is a def!, or a defmacro! if isMacro is set, which defines a variable of ns
+type defExpr struct {
+	name    *Symbol
+	value   expr
+	isMacro bool
+	private bool
+	ns      *namespace
+	pos     *Position
+}
+
+//letExpr evaluates bindings one after another into the slots of a new frame, then body in that frame
+type letExpr struct {
+	bindings []expr
+	body     expr
+	// boxed tells which variables are referred to by functions created before the variable is bound
+	boxed []bool
+}
+
+//loopExpr is a let* whose body can be run again by a recur, which rebinds its variables
+type loopExpr struct {
+	bindings []expr
+	body     expr
+	boxed    []bool
+}
+
+//recurExpr evaluates args and runs the innermost loop or fn* again with them. It is always in tail position
+type recurExpr struct {
+	args []expr
+}
+
+//doExpr evaluates forms, which are never empty, one after another
+type doExpr struct {
+	forms []expr
+}
+
+type ifExpr struct {
+	cond expr
+	then expr
+	els  expr
+}
+
+//fnExpr creates a function, which runs the overload matching the number of arguments it is called with
+type fnExpr struct {
+	name      string // the name the function is defined with, if any, for error messages
+	overloads []*overloadExpr
+}
+
+//overloadExpr is the body of a function for some numbers of arguments. It runs in a new frame whose slots hold
+//the parameters
+type overloadExpr struct {
+	params   int // including the parameter that takes the rest of the arguments, if variadic
+	variadic bool
+	body     expr
+}
+
+//macroexpandExpr is a macroexpand, macroexpand-1 or macroexpand-all of form, which expand expands in the
+//environment of ns, see macros.go
+type macroexpandExpr struct {
+	form   Type
+	ns     *namespace
+	expand func(form Type, env *Env) (Type, error)
+}
+
+//lazySeqExpr creates a lazy sequence, whose body runs in a frame without slots of its own
+type lazySeqExpr struct {
+	body expr
+}
+
+//tryExpr evaluates body, and if that fails the first of catches that catches the error, then finally, see
+//exceptions.go
+type tryExpr struct {
+	body    expr
+	catches []*catchExpr
+	finally expr // nil without finally
+}
+
+//catchExpr is a catch* clause, whose body runs in a new frame whose slot holds the error
+type catchExpr struct {
+	kind *Keyword // nil for a clause without a kind, which catches any error
+	body expr
+}
+
+type callExpr struct {
+	fn   expr
+	args []expr
+	// the call itself and the scope and the namespace it is in, to expand it should fn turn out to be a
+	// macro that wasn't defined yet when the call was analyzed
+	form  *List
+	scope *scope
+	ns    *namespace
+	tail  bool
+}
+
+type vectorExpr struct {
+	items []expr
+}
+
+//mapExpr is a hash map literal
+type mapExpr struct {
+	keys   []expr
+	values []expr
+}
+
+type setExpr struct {
+	items []expr
+}
+
+//errorExpr is a form that can't be evaluated
+type errorExpr struct {
+	err error
+}
+
+//scope holds the names of the local variables in a frame while the forms that run in it are analyzed.
+//The scope of global forms is nil
+type scope struct {
+	names []string
+	// the number of names that are bound. While the bindings of a let* are analyzed, only the ones before
+	// the current binding are, but functions created in the bindings can refer to all of them
+	bound int
+	// set if the forms in the scope run after the forms of the outer scope, i.e. in a fn* or lazy-seq
+	deferred bool
+	// set for the variables of a let* that are referred to before they are bound
+	boxed []bool
+	// set if a recur in tail position of the forms in the scope rebinds its names, i.e. in a fn* or loop
+	loop  bool
+	outer *scope
+}
+
+func newScope(outer *scope, deferred bool, names ...string) *scope {
+	return &scope{names: names, bound: len(names), deferred: deferred, outer: outer}
+}
+
+//lookup finds the innermost local variable called name
+func (sc *scope) lookup(name string) (depth int, index int, ok bool) {
+	deferred := false
+	for ; sc != nil; sc = sc.outer {
+		visible := sc.names[:sc.bound]
+		if deferred {
+			visible = sc.names
+		}
+		for i := len(visible) - 1; i >= 0; i-- {
+			if visible[i] == name {
+				if i >= sc.bound {
+					sc.boxed[i] = true
+				}
+				return depth, i, true
+			}
+		}
+		deferred = deferred || sc.deferred
+		depth++
+	}
+	return 0, 0, false
+}
+
+//recurTarget returns the scope of the innermost loop or fn*, or nil if there is none
+func (sc *scope) recurTarget() *scope {
+	for ; sc != nil; sc = sc.outer {
+		if sc.loop {
+			return sc
+		}
+		if sc.deferred {
+			return nil
+		}
+	}
+	return nil
+}
+
+//snapshot returns a copy of sc, which doesn't change when more names of a let* are bound in sc
+func (sc *scope) snapshot() *scope {
+	if sc == nil {
+		return nil
+	}
+	copied := *sc
+	return &copied
+}
+
+//checked runs analyze in the namespace ns on a form that is evaluated on its own, e.g. a global form or a macro
+//call expanded when it is first made. It fails if the form contains a misplaced recur
+func (in *Interpreter) checked(ns *namespace, analyze func() expr) (expr, error) {
+	outer := in.analyzeErr
+	in.analyzeErr = nil
+	var e expr
+	if ns == in.ns {
+		e = analyze()
+	} else {
+		in.inNamespace(ns, func() { e = analyze() })
+	}
+	err := in.analyzeErr
+	in.analyzeErr = outer
+	return e, err
+}
+
+//analyze turns form into an expression. tail tells whether form is in tail position of the innermost loop or fn*
+func (in *Interpreter) analyze(form Type, sc *scope, tail bool) expr {
+	switch form.(type) {
+	case *List, *HashMap, *Set, *LazySeq:
+		if in.nesting >= MaxNesting {
+			return in.fail(WithPosition(nestingError(), PositionOf(form)))
+		}
+		in.nesting++
+		defer func() { in.nesting-- }()
+	}
+	switch v := form.(type) {
+	case *Symbol:
+		if depth, index, ok := sc.lookup(v.Value); ok {
+			return &localExpr{name: v, depth: depth, index: index}
+		}
+		return &globalExpr{name: v, ns: in.ns}
+	case *List:
+		if v.IsVector {
+			items := in.analyzeAll(v.Slice(), sc)
+			if values, ok := constValues(items); ok {
+				return &constExpr{value: NewList(true, values...)}
+			}
+			return &vectorExpr{items: items}
+		}
+		if v.Len() == 0 {
+			return &constExpr{value: v}
+		}
+		return in.analyzeList(v, sc, tail)
+	case *HashMap:
+		var keys, values []expr
+		forms := v.forms()
+		for i := 0; i+1 < len(forms); i += 2 {
+			keys = append(keys, in.analyze(forms[i], sc, false))
+			values = append(values, in.analyze(forms[i+1], sc, false))
+		}
+		constKeys, keysOk := constValues(keys)
+		if constants, ok := constValues(values); ok && keysOk {
+			hmap := NewHashMap()
+			for i, k := range constKeys {
+				if hmap.Set(k, constants[i]); hmap.Len() == i {
+					return &errorExpr{err: duplicateKeyError(k)}
+				}
+			}
+			return &constExpr{value: &hmap}
+		}
+		return &mapExpr{keys: keys, values: values}
+	case *Set:
+		items := in.analyzeAll(v.Slice(), sc)
+		if values, ok := constValues(items); ok {
+			set := NewSet()
+			for _, val := range values {
+				set.Add(val)
+			}
+			return &constExpr{value: &set}
+		}
+		return &setExpr{items: items}
+	case *LazySeq:
+		//e.g. code built by a macro with filter, which is evaluated like a list
+		forms, err := v.Slice()
+		if err != nil {
+			return &errorExpr{err: err}
+		}
+		return in.analyze(NewList(false, forms...), sc, tail)
+	default:
+		return &constExpr{value: form}
+	}
+}
+
+func (in *Interpreter) analyzeAll(forms []Type, sc *scope) []expr {
+	exprs := make([]expr, len(forms))
+	for i, form := range forms {
+		exprs[i] = in.analyze(form, sc, false)
+	}
+	return exprs
+}
+
+//constValues returns the values of exprs if all of them are constants
+func constValues(exprs []expr) ([]Type, bool) {
+	values := make([]Type, len(exprs))
+	for i, e := range exprs {
+		c, ok := e.(*constExpr)
+		if !ok {
+			return nil, false
+		}
+		values[i] = c.value
+	}
+	return values, true
+}
+
+//analyzeList analyzes a non-empty list, which is a special form, a macro call or a function call
+func (in *Interpreter) analyzeList(form *List, sc *scope, tail bool) expr {
+	errorf := func(format string, a ...interface{}) expr {
+		return &errorExpr{err: WithPosition(fmt.Errorf(format, a...), form.Pos)}
+	}
+	args := form.Slice()[1:]
+
+	symb, ok := form.First().(*Symbol)
+	if !ok {
+		return &callExpr{fn: in.analyze(form.First(), sc, false), args: in.analyzeAll(args, sc), form: form, ns: in.ns}
+	}
+	switch symb.Value {
+	case "def!", "defmacro!":
+		if len(args) != 2 {
+			return errorf("'%s' expects exactly 2 paramters", symb.Value)
+		}
+		name, private, ok := defName(args[0])
+		if !ok {
+			return errorf("first paramter must be of type Symbol, got %s", typeName(args[0]))
+		}
+		if _, _, qualified := splitSymbol(name.Value); qualified {
+			return errorf("'%s' can't define the qualified symbol %s", symb.Value, name.Value)
+		}
+		value := in.analyze(args[1], sc, false)
+		if fn, ok := value.(*fnExpr); ok {
+			fn.name = name.Value
+		}
+		return &defExpr{name: name, value: value, isMacro: symb.Value == "defmacro!", private: private, ns: in.ns, pos: form.Pos}
+	case "let*":
+		if len(args) < 2 {
+			return errorf("'let*' expects at least 2 paramters")
+		}
+		bindings, ok := args[0].(*List)
+		if !ok {
+			return errorf("'let!': invalid arguments")
+		}
+		letScope, values, err := in.analyzeBindings(bindings, sc, form.Pos)
+		if err != nil {
+			return errorf("%s", err)
+		}
+		return &letExpr{bindings: values, body: in.analyze(args[1], letScope, tail), boxed: letScope.boxed}
+	case "loop":
+		if len(args) == 0 {
+			return errorf("'loop' expects at least 1 parameter")
+		}
+		bindings, ok := args[0].(*List)
+		if !ok || bindings.Len()%2 != 0 {
+			return errorf("'loop' expects a vector of names and values to bind them to")
+		}
+		if destructured, ok := in.destructureLoop(form); ok {
+			return in.analyze(destructured, sc, tail)
+		}
+		loopScope, values, err := in.analyzeBindings(bindings, sc, form.Pos)
+		if err != nil {
+			return errorf("%s", err)
+		}
+		loopScope.loop = true
+		return &loopExpr{bindings: values, body: in.analyzeBody(args[1:], loopScope, true), boxed: loopScope.boxed}
+	case "recur":
+		target := sc.recurTarget()
+		switch {
+		case !tail:
+			return in.fail(WithPosition(fmt.Errorf("Can only recur from tail position"), form.Pos))
+		case target == nil:
+			return in.fail(WithPosition(fmt.Errorf("recur must be inside a loop or fn*"), form.Pos))
+		case len(args) != len(target.names):
+			return in.fail(WithPosition(fmt.Errorf("Mismatched argument count to recur, expected: %d args, got: %d", len(target.names), len(args)), form.Pos))
+		}
+		return &recurExpr{args: in.analyzeAll(args, sc)}
+	case "do":
+		return in.analyzeBody(args, sc, tail)
+	case "if":
+		if len(args) < 2 || len(args) > 3 {
+			return errorf("'if' expects 2 or 3 paramters")
+		}
+		ifx := &ifExpr{cond: in.analyze(args[0], sc, false), then: in.analyze(args[1], sc, tail), els: &constExpr{value: &Nil{}}}
+		if len(args) == 3 {
+			ifx.els = in.analyze(args[2], sc, tail)
+		}
+		return ifx
+	case "fn*":
+		fn, err := in.analyzeFn(form, args, sc)
+		if err != nil {
+			return errorf("%s", err)
+		}
+		return fn
+	case "quote":
+		if len(args) != 1 {
+			return errorf("'quote' expects exactly 1 paramter")
+		}
+		return &constExpr{value: args[0]}
+	case "quasiquote":
+		if len(args) != 1 {
+			return errorf("'quasiquote' expects exactly 1 paramter")
+		}
+		return in.analyze(in.quasiquote(args[0]), sc, tail)
+	case "macroexpand", "macroexpand-1", "macroexpand-all":
+		if len(args) != 1 {
+			return errorf("'%s' expects exactly 1 paramter", symb.Value)
+		}
+		return &macroexpandExpr{form: args[0], ns: in.ns, expand: macroExpanders[symb.Value]}
+	case "lazy-seq":
+		return &lazySeqExpr{body: in.analyzeBody(args, newScope(sc, true), false)}
+	case "try*":
+		if len(args) == 0 {
+			return errorf("'try*' expects at least 1 paramter")
+		}
+		try, err := in.analyzeTry(args, sc)
+		if err != nil {
+			return errorf("%s", err)
+		}
+		return try
+	}
+
+	if _, _, isLocal := sc.lookup(symb.Value); !isLocal {
+		if fn, ok := in.ns.env.Get(symb).(*Function); ok && fn.IsMacro {
+			return in.analyzeMacroCall(fn, form, sc, tail)
+		}
+	}
+	return &callExpr{fn: in.analyze(symb, sc, false), args: in.analyzeAll(args, sc), form: form, scope: sc.snapshot(), ns: in.ns, tail: tail}
+}
+
+//analyzeTry analyzes a try*, (try* body... (catch* kind? e handler...)... (finally forms...)?)
+func (in *Interpreter) analyzeTry(args []Type, sc *scope) (*tryExpr, error) {
+	body := len(args)
+	for i, arg := range args {
+		if clauseOf(arg) != "" {
+			body = i
+			break
+		}
+	}
+	// recur can't leave a try*
+	try := &tryExpr{body: in.analyzeBody(args[:body], sc, false)}
+	for i, arg := range args[body:] {
+		kind := clauseOf(arg)
+		if kind == "" {
+			return nil, fmt.Errorf("try*: expected a catch* or finally clause, got %s", PrString(arg, true))
+		}
+		clause := arg.(*List).Slice()
+		switch kind {
+		case "finally":
+			if body+i != len(args)-1 {
+				return nil, fmt.Errorf("try*: finally must be the last clause")
+			}
+			try.finally = in.analyzeBody(clause[1:], sc, false)
+		case "catch*":
+			catch := &catchExpr{}
+			if len(clause) > 1 {
+				if kind, ok := clause[1].(*Keyword); ok {
+					catch.kind = kind
+					clause = clause[1:]
+				}
+			}
+			if len(clause) < 2 {
+				return nil, fmt.Errorf("catch*: expected the symbol to bind the error to")
+			}
+			bind, ok := clause[1].(*Symbol)
+			if !ok {
+				return nil, fmt.Errorf("catch*: the error must be bound to a symbol, got %s", PrString(clause[1], true))
+			}
+			catch.body = in.analyzeBody(clause[2:], newScope(sc, false, bind.Value), false)
+			try.catches = append(try.catches, catch)
+		}
+	}
+	return try, nil
+}
+
+//clauseOf returns catch* or finally if form is a clause of a try*, or "" otherwise
+func clauseOf(form Type) string {
+	list, ok := form.(*List)
+	if !ok || list.IsVector || list.Len() == 0 {
+		return ""
+	}
+	if symbol, ok := list.First().(*Symbol); ok && (symbol.Value == "catch*" || symbol.Value == "finally") {
+		return symbol.Value
+	}
+	return ""
+}
+
+//analyzeFn analyzes a fn*, which is either (fn* params body) or (fn* (params body...) (params body...) ...)
+func (in *Interpreter) analyzeFn(form *List, args []Type, sc *scope) (*fnExpr, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("Invalid bindings to fn*")
+	}
+	fn := &fnExpr{}
+	if !isOverloaded(args) {
+		params, ok := args[0].(*List)
+		if !ok {
+			return nil, fmt.Errorf("Invalid bindings to fn*")
+		}
+		var body Type = &Nil{}
+		if len(args) > 1 {
+			body = args[1]
+		}
+		overload, err := in.analyzeOverload(form, params, body, sc)
+		if err != nil {
+			return nil, err
+		}
+		fn.overloads = append(fn.overloads, overload)
+		return fn, nil
+	}
+
+	var variadic *overloadExpr
+	fixed := map[int]bool{}
+	for _, arg := range args {
+		clause := arg.(*List)
+		body := NewList(false, append([]Type{&Symbol{Value: "do"}}, clause.Slice()[1:]...)...)
+		body.Pos = clause.Pos
+		overload, err := in.analyzeOverload(form, clause.First().(*List), body, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case overload.variadic && variadic != nil:
+			return nil, fmt.Errorf("fn*: can't have more than 1 variadic overload")
+		case overload.variadic:
+			variadic = overload
+		case fixed[overload.params]:
+			return nil, fmt.Errorf("fn*: can't have 2 overloads with the same arity")
+		default:
+			fixed[overload.params] = true
+		}
+		fn.overloads = append(fn.overloads, overload)
+	}
+	for params := range fixed {
+		if variadic != nil && params > variadic.params-1 {
+			return nil, fmt.Errorf("fn*: can't have a fixed arity overload with more parameters than the variadic one")
+		}
+	}
+	return fn, nil
+}
+
+//isOverloaded tells whether the arguments of a fn* are ([params] body...) clauses. The parameters of an overload
+//must be a vector, or (fn* ([a b]) ((f))) would be read as two overloads
+func isOverloaded(args []Type) bool {
+	for _, arg := range args {
+		clause, ok := arg.(*List)
+		if !ok || clause.IsVector || clause.Len() == 0 {
+			return false
+		}
+		if params, ok := clause.First().(*List); !ok || !params.IsVector {
+			return false
+		}
+	}
+	return true
+}
+
+//analyzeOverload analyzes the parameters and the body of a function
+func (in *Interpreter) analyzeOverload(form *List, params *List, body Type, sc *scope) (*overloadExpr, error) {
+	fnScope := newScope(sc, true)
+	fnScope.loop = true
+	overload := &overloadExpr{}
+	// parameters that are patterns are bound to generated symbols, and destructured by a let* around the body
+	var patterns []Type
+	for i, p := range params.Slice() {
+		if isPattern(p) {
+			name := in.generateSymbol()
+			patterns = append(patterns, p, name)
+			p = name
+		}
+		name, ok := p.(*Symbol)
+		if !ok {
+			return nil, fmt.Errorf("fn*: parameters must be symbols or patterns, got %s", typeName(p))
+		}
+		if name.Value == "&" {
+			if i != params.Len()-2 {
+				return nil, fmt.Errorf("fn*: & must be followed by exactly one parameter")
+			}
+			overload.variadic = true
+			continue
+		}
+		fnScope.names = append(fnScope.names, name.Value)
+	}
+	overload.params = len(fnScope.names)
+	fnScope.bound = overload.params
+	if patterns != nil {
+		let := NewList(false, &Symbol{Value: "let*"}, NewList(true, patterns...), body)
+		let.Pos = form.Pos
+		body = let
+	}
+	overload.body = in.analyze(body, fnScope, true)
+	return overload, nil
+}
+
+//analyzeBindings analyzes the bindings of a let* or loop at pos, destructuring patterns. It returns the scope
+//of the body, in which all names are bound
+func (in *Interpreter) analyzeBindings(bindings *List, sc *scope, pos *Position) (*scope, []expr, error) {
+	d := &destructurer{in: in, pos: pos}
+	flat, err := d.bindings(bindings)
+	if err != nil {
+		return nil, nil, err
+	}
+	bindScope := newScope(sc, false)
+	for i := 0; i < len(flat); i += 2 {
+		bindScope.names = append(bindScope.names, flat[i].(*Symbol).Value)
+	}
+	bindScope.boxed = make([]bool, len(bindScope.names))
+	values := make([]expr, len(bindScope.names))
+	for i := range values {
+		values[i] = in.analyze(flat[2*i+1], bindScope, false)
+		bindScope.bound++
+	}
+	return bindScope, values, nil
+}
+
+//destructureLoop rewrites a loop that binds patterns, which recur rebinds as a whole, into one that binds
+//generated symbols, like
+//
+//	(let* [s xs [a b] s] (loop [s s] (let* [[a b] s] ...)))
+//
+//It returns false if the loop binds only symbols
+func (in *Interpreter) destructureLoop(form *List) (Type, bool) {
+	bindings := form.Nth(1).(*List)
+	var outer, loop, inner []Type
+	for i := 0; i+1 < bindings.Len(); i += 2 {
+		target, value := bindings.Nth(i), bindings.Nth(i+1)
+		if !isPattern(target) {
+			outer = append(outer, target, value)
+			loop = append(loop, target, target)
+			continue
+		}
+		name := in.generateSymbol()
+		outer = append(outer, name, value, target, name)
+		loop = append(loop, name, name)
+		inner = append(inner, target, name)
+	}
+	if inner == nil {
+		return nil, false
+	}
+	at := func(items ...Type) *List {
+		list := NewList(false, items...)
+		list.Pos = form.Pos
+		return list
+	}
+	body := at(&Symbol{Value: "let*"}, NewList(true, inner...), at(append([]Type{&Symbol{Value: "do"}}, form.Slice()[2:]...)...))
+	return at(&Symbol{Value: "let*"}, NewList(true, outer...), at(&Symbol{Value: "loop"}, NewList(true, loop...), body)), true
+}
+
+//analyzeBody analyzes forms that are evaluated one after another, like those of do
+func (in *Interpreter) analyzeBody(forms []Type, sc *scope, tail bool) expr {
+	switch len(forms) {
+	case 0:
+		return &constExpr{value: &Nil{}}
+	case 1:
+		return in.analyze(forms[0], sc, tail)
+	default:
+		exprs := in.analyzeAll(forms[:len(forms)-1], sc)
+		return &doExpr{forms: append(exprs, in.analyze(forms[len(forms)-1], sc, tail))}
+	}
+}
+
+//fail records err as the error of the form being analyzed, see checked
+func (in *Interpreter) fail(err error) expr {
+	if in.analyzeErr == nil {
+		in.analyzeErr = err
+	}
+	return &errorExpr{err: err}
+}
+
+//analyzeMacroCall expands a call of macro and analyzes the result
+func (in *Interpreter) analyzeMacroCall(macro *Function, form *List, sc *scope, tail bool) expr {
+	expansion, err := macro.Fn(form.Slice()[1:]...)
+	if err != nil {
+		return &errorExpr{err: WithPosition(err, form.Pos)}
+	}
+	// errors in the expansion are reported at the macro call
+	if list, ok := expansion.(*List); ok && list.Pos == nil && !list.IsVector {
+		withPos := *list
+		withPos.Pos = form.Pos
+		expansion = &withPos
+	}
+	return in.analyze(expansion, sc, tail)
+}
@@ -0,0 +1,558 @@
+package mal
+
+// The bytecode compiler, the alternative to compile.go for Interpreters created with Options.VM. It compiles the
+// expressions produced by the analyzer into instructions for the stack machine in vm.go. It is experimental, and
+// not faster than the closures of compile.go: on the perf tests of BenchmarkPerf it only matches them, or is slower.
+//
+// Every function is compiled to a proto, which holds its code, its constants and the protos of the functions created
+// in it. Parameters and the variables of let* and catch* live in slots of the function's frame on the VM stack.
+// Variables of enclosing functions are copied into the upvalues of a function when it is created, which is fine since
+// variables can't be changed. The exception are variables of a let* that functions created in the bindings before
+// them refer to: those are boxed in a cell, which the slot and the upvalues share
+
+import (
+	"fmt"
+	"sort"
+)
+
+type opcode byte
+
+//The instructions. Their operands are unsigned 16 bit integers, see operandCount
+const (
+	opConst       opcode = iota // const: push consts[const]
+	opNil                       // push nil
+	opLocal                     // slot: push the variable in slot
+	opLocalBox                  // slot, name: push the value of the cell in slot, which must be bound
+	opUpvalue                   // index: push upvalues[index]
+	opUpvalueBox                // index, name: push the value of the cell in upvalues[index]
+	opGlobal                    // global: push the global variable of the *globalExpr consts[global]
+	opGlobalFn                  // global, site: opGlobal followed by opMacroCheck
+	opSetLocal                  // slot: pop into slot
+	opSetLocalBox               // slot: pop into the cell in slot
+	opBox                       // slot: put a new empty cell into slot
+	opDef                       // def: bind the variable of the *defExpr consts[def] to the value on top of the stack
+	opDefMacro                  // def: like opDef, turning the function on top of the stack into a macro
+	opPop                       // pop
+	opJump                      // target: continue at target
+	opJumpIfFalse               // target: pop, and continue at target if the value is nil or false
+	opClosure                   // proto: push a function of protos[proto]
+	opLazySeq                   // proto: push a lazy sequence that calls a function of protos[proto]
+	opMacroCheck                // site: expand sites[site] if the value on top of the stack is a macro
+	opCall                      // argc: call the function below the argc arguments on top of the stack
+	opTailCall                  // argc: like opCall, reusing the current frame if the function is a proto's
+	opReturn                    // return the value on top of the stack
+	opVector                    // n: pop n values and push a vector of them
+	opSet                       // n: pop n values and push a set of them
+	opMap                       // n: pop n keys, each followed by its value, and push a map of them
+	opMacroexpand               // form: push the macro expansion of the *macroexpandExpr consts[form]
+	opTry                       // catches: until opEndTry, handle errors as the *catchTable consts[catches] says
+	opEndTry                    // end the innermost opTry
+	opError                     // index: fail with errors[index]
+	opRethrow                   // pop the error pushed for a finally by an opTry and fail with it again
+)
+
+var operandCount = [...]int{
+	opConst: 1, opLocal: 1, opLocalBox: 2, opUpvalue: 1, opUpvalueBox: 2, opGlobal: 1, opGlobalFn: 2, opSetLocal: 1,
+	opSetLocalBox: 1, opBox: 1, opDef: 1, opDefMacro: 1, opJump: 1, opJumpIfFalse: 1, opClosure: 1,
+	opLazySeq: 1, opMacroCheck: 1, opCall: 1, opTailCall: 1, opVector: 1, opSet: 1, opMap: 1,
+	opMacroexpand: 1, opTry: 1, opError: 1, opReturn: 0, opRethrow: 0,
+}
+
+//catchTable tells where the code continues on an error that occurs within an opTry: at the pc of the first of
+//catches that catches it, with the values catch* binds pushed, or else at finally, with the error pushed, unless
+//finally is -1
+type catchTable struct {
+	catches []*catchExpr
+	pcs     []int
+	finally int
+}
+
+//proto is a compiled function
+type proto struct {
+	name     string
+	inline   bool // the code of a top level form, of the expansion of a macro or of a lazy-seq, not of a function
+	entries  []entry
+	slots    int // the number of slots of the frame, including the parameters
+	code     []byte
+	consts   []Type
+	protos   []*proto
+	upvalues []upvalueDesc
+	errors   []error
+	sites    []*callSite
+	// the source positions of the instructions that can fail, ordered by pc
+	positions []pcPosition
+}
+
+//entry is where the code of an overload of a function starts
+type entry struct {
+	params   int // including the parameter that takes the rest of the arguments, if variadic
+	variadic bool
+	pc       int
+}
+
+//upvalueDesc tells where the upvalue of a function is copied from when the function is created: from a slot of
+//the frame of the enclosing function, or from an upvalue of the enclosing function
+type upvalueDesc struct {
+	fromLocal bool
+	index     int
+}
+
+type pcPosition struct {
+	pc  int
+	pos *Position
+}
+
+//callSite is a call of a function that may turn out to be a macro, which wasn't defined yet when the call was
+//analyzed. In that case the call is expanded and compiled when it is first made, in the compiler's state at the call
+type callSite struct {
+	form      *List
+	scope     *scope
+	ns        *namespace
+	tail      bool
+	fc        *fnCompiler
+	fd        *frameDesc
+	end       int // the pc after the call
+	expansion *proto
+}
+
+//entryFor returns the entry of p to run for argc arguments, or nil if there is none
+func (p *proto) entryFor(argc int) *entry {
+	for i := range p.entries {
+		if e := &p.entries[i]; !e.variadic && e.params == argc {
+			return e
+		}
+	}
+	for i := range p.entries {
+		if e := &p.entries[i]; e.variadic && argc >= e.params-1 {
+			return e
+		}
+	}
+	return nil
+}
+
+//positionAt returns the position of the instruction at pc, or nil if it has none
+func (p *proto) positionAt(pc int) *Position {
+	i := sort.Search(len(p.positions), func(i int) bool { return p.positions[i].pc >= pc })
+	if i < len(p.positions) && p.positions[i].pc == pc {
+		return p.positions[i].pos
+	}
+	return nil
+}
+
+//fnCompiler compiles a function into its proto
+type fnCompiler struct {
+	in     *Interpreter
+	proto  *proto
+	parent *fnCompiler
+	// set once the function is compiled, after which no more upvalues can be added
+	finished bool
+	upvalues map[upvalueKey]int
+	// the number of slots in use
+	nslots int
+	// the loops around the expression being compiled, the innermost last, starting with the function's body
+	loops []loopTarget
+	err   error
+}
+
+//loopTarget is where a recur continues: the code from start on, with the variables rebound from slot base on
+type loopTarget struct {
+	start int
+	base  int
+	boxed []bool
+}
+
+type upvalueKey struct {
+	fd    *frameDesc
+	index int
+}
+
+//frameDesc is where the variables of a scope of the analyzer are: in the frame of the function fc compiles,
+//from slot base on
+type frameDesc struct {
+	fc    *fnCompiler
+	base  int
+	boxed []bool
+	outer *frameDesc
+}
+
+func newFnCompiler(in *Interpreter, parent *fnCompiler) *fnCompiler {
+	return &fnCompiler{
+		in:       in,
+		proto:    &proto{},
+		parent:   parent,
+		upvalues: make(map[upvalueKey]int),
+	}
+}
+
+//compileProto compiles a global form into a proto without parameters
+func (in *Interpreter) compileProto(e expr) (*proto, error) {
+	fc := newFnCompiler(in, nil)
+	fc.proto.inline = true
+	fc.entry(0, false)
+	fc.expr(e, nil, false)
+	fc.emit(opReturn)
+	return fc.finish()
+}
+
+func (fc *fnCompiler) finish() (*proto, error) {
+	fc.finished = true
+	return fc.proto, fc.err
+}
+
+//entry adds an entry for an overload taking params parameters, starting at the current end of the code
+func (fc *fnCompiler) entry(params int, variadic bool) {
+	fc.proto.entries = append(fc.proto.entries, entry{params: params, variadic: variadic, pc: len(fc.proto.code)})
+	fc.nslots = 0
+	fc.alloc(params)
+}
+
+func (fc *fnCompiler) fail(err error) {
+	if fc.err == nil {
+		fc.err = err
+	}
+}
+
+//emit appends an instruction and returns its pc
+func (fc *fnCompiler) emit(op opcode, operands ...int) int {
+	pc := len(fc.proto.code)
+	fc.proto.code = append(fc.proto.code, byte(op))
+	for _, operand := range operands {
+		if operand < 0 || operand > 0xffff {
+			fc.fail(fmt.Errorf("function too large to compile"))
+		}
+		fc.proto.code = append(fc.proto.code, byte(operand>>8), byte(operand))
+	}
+	return pc
+}
+
+//emitAt is emit for an instruction that can fail at pos
+func (fc *fnCompiler) emitAt(pos *Position, op opcode, operands ...int) int {
+	pc := fc.emit(op, operands...)
+	if pos != nil {
+		fc.proto.positions = append(fc.proto.positions, pcPosition{pc: pc, pos: pos})
+	}
+	return pc
+}
+
+//patch sets the target of the jump at pc to the current end of the code
+func (fc *fnCompiler) patch(pc int) {
+	target := len(fc.proto.code)
+	if target > 0xffff {
+		fc.fail(fmt.Errorf("function too large to compile"))
+	}
+	fc.proto.code[pc+1] = byte(target >> 8)
+	fc.proto.code[pc+2] = byte(target)
+}
+
+func (fc *fnCompiler) constant(value Type) int {
+	fc.proto.consts = append(fc.proto.consts, value)
+	return len(fc.proto.consts) - 1
+}
+
+//alloc reserves n slots and returns the first one
+func (fc *fnCompiler) alloc(n int) int {
+	base := fc.nslots
+	fc.nslots += n
+	if fc.nslots > fc.proto.slots {
+		fc.proto.slots = fc.nslots
+	}
+	return base
+}
+
+//child compiles the function of a fn* or lazy-seq
+func (fc *fnCompiler) child(e *fnExpr, fd *frameDesc) int {
+	child := newFnCompiler(fc.in, fc)
+	child.proto.name = e.name
+	for _, o := range e.overloads {
+		child.entry(o.params, o.variadic)
+		// a recur in the body runs it again
+		child.loops = []loopTarget{{start: len(child.proto.code)}}
+		child.expr(o.body, &frameDesc{fc: child, outer: fd}, true)
+		child.emit(opReturn)
+	}
+	child.loops = nil
+	p, err := child.finish()
+	if err != nil {
+		fc.fail(err)
+	}
+	fc.proto.protos = append(fc.proto.protos, p)
+	return len(fc.proto.protos) - 1
+}
+
+//upvalue returns the index of the upvalue holding variable index of fd, adding it if necessary. Once a function
+//is finished, only its existing upvalues can be used
+func (fc *fnCompiler) upvalue(fd *frameDesc, index int) (int, bool) {
+	key := upvalueKey{fd: fd, index: index}
+	if i, ok := fc.upvalues[key]; ok {
+		return i, true
+	}
+	if fc.finished {
+		return 0, false
+	}
+	desc := upvalueDesc{fromLocal: true, index: fd.base + index}
+	if fd.fc != fc.parent {
+		i, ok := fc.parent.upvalue(fd, index)
+		if !ok {
+			return 0, false
+		}
+		desc = upvalueDesc{index: i}
+	}
+	fc.proto.upvalues = append(fc.proto.upvalues, desc)
+	fc.upvalues[key] = len(fc.proto.upvalues) - 1
+	return len(fc.proto.upvalues) - 1, true
+}
+
+//expr compiles e, leaving its value on the stack. fd describes the scope e was analyzed in
+func (fc *fnCompiler) expr(e expr, fd *frameDesc, tail bool) {
+	switch e := e.(type) {
+	case *constExpr:
+		if _, ok := e.value.(*Nil); ok {
+			fc.emit(opNil)
+		} else {
+			fc.emit(opConst, fc.constant(e.value))
+		}
+	case *localExpr:
+		fc.local(e, fd)
+	case *globalExpr:
+		fc.emitAt(e.name.Pos, opGlobal, fc.constant(e))
+	case *defExpr:
+		fc.expr(e.value, fd, false)
+		op := opDef
+		if e.isMacro {
+			op = opDefMacro
+		}
+		fc.emitAt(e.pos, op, fc.constant(e))
+	case *letExpr:
+		letFd := fc.bind(e.bindings, e.boxed, fd)
+		fc.expr(e.body, letFd, tail)
+		fc.nslots = letFd.base
+	case *loopExpr:
+		loopFd := fc.bind(e.bindings, e.boxed, fd)
+		fc.loops = append(fc.loops, loopTarget{start: len(fc.proto.code), base: loopFd.base, boxed: e.boxed})
+		fc.expr(e.body, loopFd, tail)
+		fc.loops = fc.loops[:len(fc.loops)-1]
+		fc.nslots = loopFd.base
+	case *recurExpr:
+		fc.recur(e, fd)
+	case *doExpr:
+		for _, form := range e.forms[:len(e.forms)-1] {
+			fc.expr(form, fd, false)
+			fc.emit(opPop)
+		}
+		fc.expr(e.forms[len(e.forms)-1], fd, tail)
+	case *ifExpr:
+		fc.expr(e.cond, fd, false)
+		jumpToElse := fc.emit(opJumpIfFalse, 0)
+		fc.expr(e.then, fd, tail)
+		jumpToEnd := fc.emit(opJump, 0)
+		fc.patch(jumpToElse)
+		fc.expr(e.els, fd, tail)
+		fc.patch(jumpToEnd)
+	case *fnExpr:
+		fc.emit(opClosure, fc.child(e, fd))
+	case *macroexpandExpr:
+		fc.emit(opMacroexpand, fc.constant(e))
+	case *lazySeqExpr:
+		i := fc.child(&fnExpr{overloads: []*overloadExpr{{body: e.body}}}, fd)
+		fc.proto.protos[i].inline = true
+		fc.emit(opLazySeq, i)
+	case *tryExpr:
+		fc.try(e, fd, tail)
+	case *callExpr:
+		fc.call(e, fd, tail)
+	case *vectorExpr:
+		for _, item := range e.items {
+			fc.expr(item, fd, false)
+		}
+		fc.emit(opVector, len(e.items))
+	case *mapExpr:
+		for i, value := range e.values {
+			fc.expr(e.keys[i], fd, false)
+			fc.expr(value, fd, false)
+		}
+		fc.emit(opMap, len(e.values))
+	case *setExpr:
+		for _, item := range e.items {
+			fc.expr(item, fd, false)
+		}
+		fc.emit(opSet, len(e.items))
+	case *errorExpr:
+		fc.proto.errors = append(fc.proto.errors, e.err)
+		fc.emit(opError, len(fc.proto.errors)-1)
+	default:
+		panic(fmt.Sprintf("compile: unexpected expression %T", e))
+	}
+}
+
+//bind allocates slots for the variables of a let* or loop and binds them. It returns where they are
+func (fc *fnCompiler) bind(bindings []expr, boxed []bool, fd *frameDesc) *frameDesc {
+	base := fc.alloc(len(bindings))
+	bindFd := &frameDesc{fc: fc, base: base, boxed: boxed, outer: fd}
+	for i := range bindings {
+		if boxed[i] {
+			fc.emit(opBox, base+i)
+		}
+	}
+	for i, binding := range bindings {
+		fc.expr(binding, bindFd, false)
+		if boxed[i] {
+			fc.emit(opSetLocalBox, base+i)
+		} else {
+			fc.emit(opSetLocal, base+i)
+		}
+	}
+	return bindFd
+}
+
+//try compiles a try*. Its finally, if it has one, is compiled twice: for errors none of its catch* clauses catches,
+//which are thrown again after it, and at the end, where the value of the body or of the handler that ran is below
+//its own
+func (fc *fnCompiler) try(e *tryExpr, fd *frameDesc, tail bool) {
+	if len(e.catches) == 0 && e.finally == nil {
+		fc.expr(e.body, fd, false)
+		return
+	}
+	table := &catchTable{catches: e.catches, finally: -1}
+	fc.emit(opTry, fc.constant(table))
+	fc.expr(e.body, fd, false)
+	fc.emit(opEndTry)
+	jumpsToEnd := []int{fc.emit(opJump, 0)}
+	// the finally runs when a handler fails too
+	var failing *catchTable
+	if e.finally != nil {
+		failing = &catchTable{finally: -1}
+	}
+	for _, c := range e.catches {
+		table.pcs = append(table.pcs, len(fc.proto.code))
+		slot := fc.alloc(1)
+		fc.emit(opSetLocal, slot)
+		if failing != nil {
+			fc.emit(opTry, fc.constant(failing))
+		}
+		fc.expr(c.body, &frameDesc{fc: fc, base: slot, outer: fd}, tail && failing == nil)
+		if failing != nil {
+			fc.emit(opEndTry)
+		}
+		fc.nslots = slot
+		jumpsToEnd = append(jumpsToEnd, fc.emit(opJump, 0))
+	}
+	if e.finally == nil {
+		for _, jump := range jumpsToEnd {
+			fc.patch(jump)
+		}
+		return
+	}
+	table.finally = len(fc.proto.code)
+	failing.finally = table.finally
+	fc.expr(e.finally, fd, false)
+	fc.emit(opPop)
+	fc.emit(opRethrow)
+	for _, jump := range jumpsToEnd {
+		fc.patch(jump)
+	}
+	fc.expr(e.finally, fd, false)
+	fc.emit(opPop)
+}
+
+//recur rebinds the variables of the innermost loop, or the parameters of the function, and jumps back to its
+//start. Boxed variables get new cells, since functions created in the previous run still refer to the old ones
+func (fc *fnCompiler) recur(e *recurExpr, fd *frameDesc) {
+	if len(fc.loops) == 0 {
+		// only happens in the expansion of a macro call, where the function to run again is the one the call is in
+		fc.expr(&errorExpr{err: fmt.Errorf("recur is not available in the expansion of a macro defined after its use")}, fd, false)
+		return
+	}
+	target := fc.loops[len(fc.loops)-1]
+	for _, arg := range e.args {
+		fc.expr(arg, fd, false)
+	}
+	for i := len(e.args) - 1; i >= 0; i-- {
+		if target.boxed != nil && target.boxed[i] {
+			fc.emit(opBox, target.base+i)
+			fc.emit(opSetLocalBox, target.base+i)
+		} else {
+			fc.emit(opSetLocal, target.base+i)
+		}
+	}
+	fc.emit(opJump, target.start)
+}
+
+func (fc *fnCompiler) local(e *localExpr, fd *frameDesc) {
+	for i := 0; i < e.depth; i++ {
+		fd = fd.outer
+	}
+	boxed := fd.boxed != nil && fd.boxed[e.index]
+	if fd.fc == fc {
+		if boxed {
+			fc.emitAt(e.name.Pos, opLocalBox, fd.base+e.index, fc.constant(e.name))
+		} else {
+			fc.emit(opLocal, fd.base+e.index)
+		}
+		return
+	}
+	index, ok := fc.upvalue(fd, e.index)
+	if !ok {
+		// only happens in macro calls expanded after the function was compiled
+		fc.expr(&errorExpr{err: WithPosition(fmt.Errorf("'%s' is not available in the expansion of a macro defined after its use", e.name.Value), e.name.Pos)}, fd, false)
+		return
+	}
+	if boxed {
+		fc.emitAt(e.name.Pos, opUpvalueBox, index, fc.constant(e.name))
+	} else {
+		fc.emit(opUpvalue, index)
+	}
+}
+
+func (fc *fnCompiler) call(e *callExpr, fd *frameDesc, tail bool) {
+	var site *callSite
+	switch fn := e.fn.(type) {
+	case *globalExpr:
+		site = fc.site(e, fd)
+		fc.emitAt(fn.name.Pos, opGlobalFn, fc.constant(fn), len(fc.proto.sites)-1)
+	case *localExpr:
+		fc.expr(fn, fd, false)
+		site = fc.site(e, fd)
+		fc.emitAt(e.form.Pos, opMacroCheck, len(fc.proto.sites)-1)
+	default:
+		fc.expr(fn, fd, false)
+	}
+	for _, arg := range e.args {
+		fc.expr(arg, fd, false)
+	}
+	op := opCall
+	if tail {
+		op = opTailCall
+	}
+	fc.emitAt(e.form.Pos, op, len(e.args))
+	if site != nil {
+		site.end = len(fc.proto.code)
+	}
+}
+
+func (fc *fnCompiler) site(e *callExpr, fd *frameDesc) *callSite {
+	site := &callSite{form: e.form, scope: e.scope, ns: e.ns, tail: e.tail, fc: fc, fd: fd}
+	fc.proto.sites = append(fc.proto.sites, site)
+	return site
+}
+
+//expand compiles the expansion of the call at site by macro into a proto without parameters, which is a
+//child of the function the call is in
+func (in *Interpreter) expand(site *callSite, macro *Function) (*proto, error) {
+	if site.expansion == nil {
+		analyzed, err := in.checked(site.ns, func() expr { return in.analyzeMacroCall(macro, site.form, site.scope, site.tail) })
+		if err != nil {
+			return nil, err
+		}
+		fc := newFnCompiler(in, site.fc)
+		fc.proto.inline = true
+		fc.entry(0, false)
+		fc.expr(analyzed, site.fd, false)
+		fc.emit(opReturn)
+		p, err := fc.finish()
+		if err != nil {
+			return nil, err
+		}
+		site.expansion = p
+	}
+	return site.expansion, nil
+}
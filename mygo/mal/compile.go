@@ -0,0 +1,452 @@
+package mal
+
+// The compiler turns the expressions produced by the analyzer into Go closures, which evaluate them in a frame
+// holding the local variables. Calls to functions defined in mal that are in tail position don't call them, but
+// return tailCall to apply, which makes the call in a loop, so that tail calls don't grow the stack. Likewise
+// recur returns recurred to the loop or apply it rebinds
+
+import (
+	"fmt"
+)
+
+//code is a compiled expression
+type code func(fr *frame) (Type, error)
+
+//frame holds the local variables of a function call, let* or catch*, see scope
+type frame struct {
+	slots []Type
+	outer *frame
+}
+
+//lambda is a compiled fn*. Functions created from it hold it along with the frame they were created in
+type lambda struct {
+	name      string
+	overloads []*overload
+}
+
+//overload is a compiled overloadExpr
+type overload struct {
+	params   int
+	variadic bool
+	body     code
+}
+
+type tailCallMarker struct{}
+
+//tailCall is returned instead of a result by calls in tail position. The function and the arguments to call it
+//with are left in Interpreter.tailFn and tailArgs
+var tailCall Type = &tailCallMarker{}
+
+type recurMarker struct{}
+
+//recurred is returned by a recur, up to the innermost loop or function call, which runs again with the values
+//left in Interpreter.recurArgs
+var recurred Type = &recurMarker{}
+
+//eval evaluates a form in the interpreter's environment
+func (in *Interpreter) eval(ast Type) (Type, error) {
+	in.updateMaxDepth()
+	e, err := in.checked(in.ns, func() expr { return in.analyze(ast, nil, false) })
+	if err != nil {
+		return nil, err
+	}
+	if in.vm != nil {
+		p, err := in.compileProto(e)
+		if err != nil {
+			return nil, err
+		}
+		return in.vm.run(&Function{proto: p}, nil)
+	}
+	return in.compile(e, false)(nil)
+}
+
+//compile turns e into code. tail tells whether e is in tail position of a function body, i.e. whether its
+//value is returned from the function as is
+func (in *Interpreter) compile(e expr, tail bool) code {
+	switch e := e.(type) {
+	case *constExpr:
+		value := e.value
+		return func(*frame) (Type, error) {
+			return value, nil
+		}
+	case *localExpr:
+		return compileLocal(e)
+	case *globalExpr:
+		name, ns := e.name, e.ns
+		return func(*frame) (Type, error) {
+			if val := ns.env.Get(name); val != nil {
+				return val, nil
+			}
+			return nil, ns.notFound(name)
+		}
+	case *defExpr:
+		return in.compileDef(e)
+	case *letExpr:
+		bindings := in.compileAll(e.bindings)
+		body := in.compile(e.body, tail)
+		return func(fr *frame) (Type, error) {
+			letFrame := &frame{slots: make([]Type, len(bindings)), outer: fr}
+			for i, binding := range bindings {
+				val, err := binding(letFrame)
+				if err != nil {
+					return nil, err
+				}
+				letFrame.slots[i] = val
+			}
+			return body(letFrame)
+		}
+	case *loopExpr:
+		return in.compileLoop(e, tail)
+	case *recurExpr:
+		args := in.compileAll(e.args)
+		return func(fr *frame) (Type, error) {
+			values, err := evalAll(args, fr)
+			if err != nil {
+				return nil, err
+			}
+			in.recurArgs = values
+			return recurred, nil
+		}
+	case *doExpr:
+		forms := in.compileAll(e.forms[:len(e.forms)-1])
+		last := in.compile(e.forms[len(e.forms)-1], tail)
+		return func(fr *frame) (Type, error) {
+			for _, form := range forms {
+				if _, err := form(fr); err != nil {
+					return nil, err
+				}
+			}
+			return last(fr)
+		}
+	case *ifExpr:
+		cond := in.compile(e.cond, false)
+		then := in.compile(e.then, tail)
+		els := in.compile(e.els, tail)
+		return func(fr *frame) (Type, error) {
+			c, err := cond(fr)
+			if err != nil {
+				return nil, err
+			}
+			if isTruthy(c) {
+				return then(fr)
+			}
+			return els(fr)
+		}
+	case *fnExpr:
+		lam := &lambda{name: e.name}
+		for _, o := range e.overloads {
+			lam.overloads = append(lam.overloads, &overload{params: o.params, variadic: o.variadic, body: in.compile(o.body, true)})
+		}
+		return func(fr *frame) (Type, error) {
+			return in.closure(lam, fr), nil
+		}
+	case *macroexpandExpr:
+		form, ns, expand := e.form, e.ns, e.expand
+		return func(*frame) (Type, error) {
+			return expand(form, ns.env)
+		}
+	case *lazySeqExpr:
+		body := in.compile(e.body, false)
+		return func(fr *frame) (Type, error) {
+			lazyFrame := &frame{outer: fr}
+			return &LazySeq{fn: func() (Type, error) {
+				return body(lazyFrame)
+			}, in: in}, nil
+		}
+	case *tryExpr:
+		return in.compileTry(e, tail)
+	case *callExpr:
+		return in.compileCall(e, tail)
+	case *vectorExpr:
+		items := in.compileAll(e.items)
+		return func(fr *frame) (Type, error) {
+			values, err := evalAll(items, fr)
+			if err != nil {
+				return nil, err
+			}
+			return NewList(true, values...), nil
+		}
+	case *mapExpr:
+		keys := in.compileAll(e.keys)
+		values := in.compileAll(e.values)
+		return func(fr *frame) (Type, error) {
+			hmap := NewHashMap()
+			for i, value := range values {
+				key, err := keys[i](fr)
+				if err != nil {
+					return nil, err
+				}
+				val, err := value(fr)
+				if err != nil {
+					return nil, err
+				}
+				if hmap.Set(key, val); hmap.Len() == i {
+					return nil, duplicateKeyError(key)
+				}
+			}
+			return &hmap, nil
+		}
+	case *setExpr:
+		items := in.compileAll(e.items)
+		return func(fr *frame) (Type, error) {
+			values, err := evalAll(items, fr)
+			if err != nil {
+				return nil, err
+			}
+			set := NewSet()
+			for _, val := range values {
+				set.Add(val)
+			}
+			return &set, nil
+		}
+	case *errorExpr:
+		err := e.err
+		return func(*frame) (Type, error) {
+			return nil, err
+		}
+	}
+	panic(fmt.Sprintf("compile: unexpected expression %T", e))
+}
+
+func compileLocal(e *localExpr) code {
+	name, depth, index := e.name, e.depth, e.index
+	//a slot is empty if a function created in a let* binding refers to a variable bound after it,
+	//and is called before that variable is bound
+	notFound := func() (Type, error) {
+		return nil, WithPosition(fmt.Errorf("'%s' not found", name.Value), name.Pos)
+	}
+	switch depth {
+	case 0:
+		return func(fr *frame) (Type, error) {
+			if val := fr.slots[index]; val != nil {
+				return val, nil
+			}
+			return notFound()
+		}
+	case 1:
+		return func(fr *frame) (Type, error) {
+			if val := fr.outer.slots[index]; val != nil {
+				return val, nil
+			}
+			return notFound()
+		}
+	default:
+		return func(fr *frame) (Type, error) {
+			for i := 0; i < depth; i++ {
+				fr = fr.outer
+			}
+			if val := fr.slots[index]; val != nil {
+				return val, nil
+			}
+			return notFound()
+		}
+	}
+}
+
+//compileAll compiles expressions that are not in tail position
+func (in *Interpreter) compileAll(exprs []expr) []code {
+	codes := make([]code, len(exprs))
+	for i, e := range exprs {
+		codes[i] = in.compile(e, false)
+	}
+	return codes
+}
+
+func evalAll(codes []code, fr *frame) ([]Type, error) {
+	values := make([]Type, len(codes))
+	for i, c := range codes {
+		val, err := c(fr)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = val
+	}
+	return values, nil
+}
+
+func (in *Interpreter) compileDef(e *defExpr) code {
+	name, isMacro, private, ns, pos := e.name, e.isMacro, e.private, e.ns, e.pos
+	value := in.compile(e.value, false)
+	return func(fr *frame) (Type, error) {
+		val, err := value(fr)
+		if err != nil {
+			return nil, err
+		}
+		if isMacro {
+			fn, ok := val.(*Function)
+			if !ok {
+				return nil, WithPosition(fmt.Errorf("Argument 2 to defmacro! must be a function"), pos)
+			}
+			macro := CopyOfFunction(fn)
+			macro.IsMacro = true
+			val = macro
+		}
+		ns.define(name, val, private)
+		return val, nil
+	}
+}
+
+//compileLoop compiles a loop, which runs its body in a new frame for each recur, so that functions created in
+//the body keep the values of the variables they were created with
+func (in *Interpreter) compileLoop(e *loopExpr, tail bool) code {
+	bindings := in.compileAll(e.bindings)
+	body := in.compile(e.body, tail)
+	return func(fr *frame) (Type, error) {
+		loopFrame := &frame{slots: make([]Type, len(bindings)), outer: fr}
+		for i, binding := range bindings {
+			val, err := binding(loopFrame)
+			if err != nil {
+				return nil, err
+			}
+			loopFrame.slots[i] = val
+		}
+		for {
+			res, err := body(loopFrame)
+			if err != nil || res != recurred {
+				return res, err
+			}
+			if err := in.step(); err != nil {
+				return nil, err
+			}
+			loopFrame = &frame{slots: in.recurArgs, outer: fr}
+		}
+	}
+}
+
+func (in *Interpreter) compileTry(e *tryExpr, tail bool) code {
+	body := in.compile(e.body, false)
+	if len(e.catches) == 0 && e.finally == nil {
+		return body
+	}
+	// the handlers are only in tail position if there is no finally to run after them
+	catches := make([]code, len(e.catches))
+	for i, c := range e.catches {
+		catches[i] = in.compile(c.body, tail && e.finally == nil)
+	}
+	finally := func(*frame) (Type, error) { return nil, nil }
+	if e.finally != nil {
+		finally = in.compile(e.finally, false)
+	}
+	return func(fr *frame) (Type, error) {
+		res, err := body(fr)
+		if err != nil {
+			if i := catching(e.catches, err); i >= 0 {
+				res, err = catches[i](&frame{slots: []Type{caught(err)}, outer: fr})
+			}
+		}
+		if _, ferr := finally(fr); ferr != nil {
+			return nil, ferr
+		}
+		return res, err
+	}
+}
+
+func (in *Interpreter) compileCall(e *callExpr, tail bool) code {
+	fn := in.compile(e.fn, false)
+	args := in.compileAll(e.args)
+	form, sc, ns, pos, isTail := e.form, e.scope, e.ns, e.form.Pos, e.tail
+	var expansion code // set once fn turns out to be a macro
+	return func(fr *frame) (Type, error) {
+		if expansion != nil {
+			return expansion(fr)
+		}
+		f, err := fn(fr)
+		if err != nil {
+			return nil, WithPosition(err, pos)
+		}
+		callee, ok := f.(*Function)
+		if !ok {
+			return nil, WithPosition(fmt.Errorf("Expected function, got %s", typeName(f)), pos)
+		}
+		if callee.IsMacro {
+			analyzed, err := in.checked(ns, func() expr { return in.analyzeMacroCall(callee, form, sc, isTail) })
+			if err != nil {
+				return nil, err
+			}
+			expansion = in.compile(analyzed, tail)
+			return expansion(fr)
+		}
+		values, err := evalAll(args, fr)
+		if err != nil {
+			return nil, WithPosition(err, pos)
+		}
+		if tail && callee.lambda != nil {
+			in.tailFn, in.tailArgs = callee, values
+			return tailCall, nil
+		}
+		res, err := in.apply(callee, values)
+		return res, WithPosition(calledAt(err, pos), pos)
+	}
+}
+
+//closure creates a function from lam that runs in a frame below fr
+func (in *Interpreter) closure(lam *lambda, fr *frame) *Function {
+	fn := &Function{Name: lam.name, lambda: lam, frame: fr}
+	fn.Fn = func(args ...Type) (Type, error) {
+		return in.apply(fn, args)
+	}
+	return fn
+}
+
+//apply calls fn with args. Functions defined in mal are run in a loop here, which makes the calls
+//they return as tailCall, and runs them again for a recur, instead of growing the stack. Errors get the
+//call of the function that was running added to their stack
+func (in *Interpreter) apply(fn *Function, args []Type) (Type, error) {
+	if fn.lambda == nil {
+		res, err := fn.Fn(args...)
+		return res, withCall(err, fn.Name)
+	}
+	if in.depth >= in.maxDepth {
+		return nil, withCall(depthError(in.maxDepth), fn.Name)
+	}
+	// the depth isn't restored if a native function panics, EvalForm does it then
+	in.depth++
+	for {
+		o := fn.lambda.dispatch(len(args))
+		if o == nil {
+			in.depth--
+			return nil, withCall(arityError(len(args), fn.Name), fn.Name)
+		}
+		err := in.step()
+		var res Type
+		if err == nil {
+			res, err = o.body(&frame{slots: o.bind(args), outer: fn.frame})
+		}
+		for err == nil && res == recurred {
+			if err = in.step(); err == nil {
+				res, err = o.body(&frame{slots: in.recurArgs, outer: fn.frame})
+			}
+		}
+		if err != nil || res != tailCall {
+			in.depth--
+			return res, withCall(err, fn.Name)
+		}
+		fn, args = in.tailFn, in.tailArgs
+	}
+}
+
+//dispatch returns the overload of lam to run for argc arguments, or nil if there is none
+func (lam *lambda) dispatch(argc int) *overload {
+	for _, o := range lam.overloads {
+		if !o.variadic && o.params == argc {
+			return o
+		}
+	}
+	for _, o := range lam.overloads {
+		if o.variadic && argc >= o.params-1 {
+			return o
+		}
+	}
+	return nil
+}
+
+//bind returns the slots of a frame holding the parameters of o for args, of which there are as many as o takes
+func (o *overload) bind(args []Type) []Type {
+	if !o.variadic {
+		return args
+	}
+	slots := make([]Type, o.params)
+	fixed := copy(slots[:o.params-1], args)
+	slots[fixed] = NewList(false, args[fixed:]...)
+	return slots
+}
@@ -0,0 +1,106 @@
+package mal
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//perfForms are the forms tests/perf*.mal time, which the benchmarks evaluate after loading the file, and the files
+//it loads whose definitions the AST walker evaluates again for itself
+var perfForms = []struct {
+	file   string
+	form   string
+	walked []string
+}{
+	{"perf1", `(do
+	  (or false nil false nil false nil false nil false nil 4)
+	  (cond false 1 nil 2 false 3 nil 4 false 5 nil 6 "else" 7)
+	  (-> (list 1 2 3 4 5 6 7 8 9) rest rest rest rest rest rest first))`,
+		[]string{"../lib/threading.mal", "../lib/test_cascade.mal"}},
+	{"perf2", `(do
+	  (sumdown 10)
+	  (fib 12))`,
+		[]string{"../tests/computations.mal"}},
+	{"perf3", `(do
+	  (or false nil false nil false nil false nil false nil (first @atm))
+	  (cond false 1 nil 2 false 3 nil 4 false 5 nil 6 "else" (first @atm))
+	  (-> (deref atm) rest rest rest rest rest rest first)
+	  (swap! atm (fn* [a] (concat (rest a) (list (first a))))))`,
+		[]string{"../lib/threading.mal", "../lib/test_cascade.mal"}},
+}
+
+//BenchmarkPerf evaluates the forms of tests/perf*.mal with each evaluator, and with the AST walker they replaced as a
+//baseline. perf3.mal only runs its form for 10 seconds and prints how many iterations that took, so it is loaded up to
+//the definition of the atom it uses. The functions and macros of the standard library and of the libraries the
+//walked files load run compiled for the AST walker too
+func BenchmarkPerf(b *testing.B) {
+	// the files load their libraries relative to the directory of the mal implementation
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		b.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	for _, perf := range perfForms {
+		for _, backend := range []string{"ast", "closures", "vm"} {
+			opts := backends[backend]
+			opts.Stdout = ioutil.Discard
+			in := New(opts)
+			setup := "(load-file \"../tests/" + perf.file + ".mal\")"
+			if perf.file == "perf3" {
+				setup = `(load-file "../lib/load-file-once.mal")
+				  (load-file-once "../lib/threading.mal")
+				  (load-file-once "../lib/test_cascade.mal")
+				  (def! atm (atom (list 0 1 2 3 4 5 6 7 8 9)))`
+			}
+			if _, err := in.EvalString(context.Background(), setup); err != nil {
+				b.Fatal(err)
+			}
+			form, err := ReadStr(perf.form)
+			if err != nil {
+				b.Fatal(err)
+			}
+			eval := func() error {
+				_, err := in.EvalForm(context.Background(), form)
+				return err
+			}
+			if backend == "ast" {
+				for _, file := range perf.walked {
+					walkFile(b, in, file)
+				}
+				eval = func() error {
+					_, err := walk(form, in.ns.env)
+					return err
+				}
+			}
+			b.Run(perf.file+"/"+backend, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if err := eval(); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+//walkFile evaluates the forms of a file with the AST walker
+func walkFile(b *testing.B, in *Interpreter, filename string) {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		b.Fatal(err)
+	}
+	forms, err := ReadAll(string(src))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, form := range forms {
+		if _, err := walk(form, in.ns.env); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
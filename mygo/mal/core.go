@@ -3,10 +3,11 @@ package mal
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
-	"sort"
+	"regexp"
 	"strings"
 	"time"
 )
@@ -14,75 +15,130 @@ import (
 //CoreNS contains builtin functions for mal
 var CoreNS = map[*Symbol]*Function{
 	&Symbol{Value: "+"}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Number{Value: a.Value + b.Value}, nil
+		nums := numbers(args)
+		sum := NewInt(0)
+		for _, n := range nums {
+			sum = sum.Add(n)
+		}
+		return sum, nil
 	}},
+	//subtract all following arguments from the first one, or negate it if it is the only one
 	&Symbol{Value: "-"}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Number{Value: a.Value - b.Value}, nil
+		nums := numbers(args)
+		if len(nums) == 1 {
+			return nums[0].Neg(), nil
+		}
+		diff := nums[0]
+		for _, n := range nums[1:] {
+			diff = diff.Sub(n)
+		}
+		return diff, nil
 	}},
 	&Symbol{Value: "*"}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Number{Value: a.Value * b.Value}, nil
+		nums := numbers(args)
+		product := NewInt(1)
+		for _, n := range nums {
+			product = product.Mul(n)
+		}
+		return product, nil
 	}},
+	//divide the first argument by all following ones, or return its reciprocal if it is the only one
 	&Symbol{Value: "/"}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Number{Value: a.Value / b.Value}, nil
+		nums := numbers(args)
+		if len(nums) == 1 {
+			return NewInt(1).Div(nums[0])
+		}
+		quotient := nums[0]
+		for _, n := range nums[1:] {
+			var err error
+			quotient, err = quotient.Div(n)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return quotient, nil
+	}},
+	&Symbol{Value: "quot"}: &Function{Fn: func(args ...Type) (Type, error) {
+		return args[0].(*Number).Quot(args[1].(*Number))
+	}},
+	&Symbol{Value: "rem"}: &Function{Fn: func(args ...Type) (Type, error) {
+		return args[0].(*Number).Rem(args[1].(*Number))
+	}},
+	&Symbol{Value: "mod"}: &Function{Fn: func(args ...Type) (Type, error) {
+		return args[0].(*Number).Mod(args[1].(*Number))
+	}},
+	//truncate a number to an integer
+	&Symbol{Value: "int"}: &Function{Fn: func(args ...Type) (Type, error) {
+		nums := numbers(args)
+		return nums[0].Truncate()
+	}},
+	&Symbol{Value: "double"}: &Function{Fn: func(args ...Type) (Type, error) {
+		nums := numbers(args)
+		return NewFloat(nums[0].Float64()), nil
+	}},
+	&Symbol{Value: "numerator"}: &Function{Fn: func(args ...Type) (Type, error) {
+		n := args[0].(*Number)
+		if n.Kind == FloatNumber {
+			return nil, Errorf("numerator: Argument 1 must be an integer or ratio")
+		}
+		return n.Numerator(), nil
+	}},
+	&Symbol{Value: "denominator"}: &Function{Fn: func(args ...Type) (Type, error) {
+		n := args[0].(*Number)
+		if n.Kind == FloatNumber {
+			return nil, Errorf("denominator: Argument 1 must be an integer or ratio")
+		}
+		return n.Denominator(), nil
 	}},
 	//take the parameters and return them as a list.
 	&Symbol{Value: "list"}: &Function{Fn: func(args ...Type) (Type, error) {
-		return &List{Value: args}, nil
+		return NewList(false, args...), nil
 	}},
 	//return true if the first parameter is a list, false otherwise.
 	&Symbol{Value: "list?"}: &Function{Fn: func(args ...Type) (Type, error) {
 		l, ok := args[0].(*List)
 		return &Boolean{Value: ok && !l.IsVector}, nil
 	}},
-	//treat the first parameter as a list and return true if the list is empty and false if it contains any elements.
+	//treat the first parameter as a collection and return true if it is empty and false if it contains any elements.
 	&Symbol{Value: "empty?"}: &Function{Fn: func(args ...Type) (Type, error) {
-		if lst, ok := args[0].(*List); ok {
-			if len(lst.Value) == 0 {
-				return &Boolean{Value: true}, nil
-			}
+		seq, err := seqOf("empty?", args[0])
+		if err != nil {
+			return &Boolean{Value: false}, nil
 		}
-		return &Boolean{Value: false}, nil
+		_, _, ok, err := uncons(seq)
+		if err != nil {
+			return nil, err
+		}
+		return &Boolean{Value: !ok}, nil
 	}},
-	// treat the first parameter as a list and return the number of elements that it contains.
+	// treat the first parameter as a collection and return the number of elements that it contains.
 	&Symbol{Value: "count"}: &Function{Fn: func(args ...Type) (Type, error) {
-		if lst, ok := args[0].(*List); ok {
-			return &Number{Value: float64(len(lst.Value))}, nil
+		n, err := count(args[0])
+		if err != nil {
+			return nil, err
 		}
-		return &Number{Value: 0}, nil
+		return NewInt(int64(n)), nil
 	}},
 
 	&Symbol{Value: "<"}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Boolean{Value: a.Value < b.Value}, nil
+		return compareNumbers(args, func(c int) bool { return c < 0 })
 	}},
 	&Symbol{Value: ">"}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Boolean{Value: a.Value > b.Value}, nil
+		return compareNumbers(args, func(c int) bool { return c > 0 })
 	}},
 
 	&Symbol{Value: "<="}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Boolean{Value: a.Value <= b.Value}, nil
+		return compareNumbers(args, func(c int) bool { return c <= 0 })
 	}},
 
 	&Symbol{Value: ">="}: &Function{Fn: func(args ...Type) (Type, error) {
-		a, _ := args[0].(*Number)
-		b, _ := args[1].(*Number)
-		return &Boolean{Value: a.Value >= b.Value}, nil
+		return compareNumbers(args, func(c int) bool { return c >= 0 })
 	}},
 
 	&Symbol{Value: "pr-str"}: &Function{Fn: func(args ...Type) (Type, error) {
+		if err := realizeAll(args); err != nil {
+			return nil, err
+		}
 		var sb strings.Builder
 		for i, v := range args {
 			sb.WriteString(PrString(v, true))
@@ -93,6 +149,9 @@ var CoreNS = map[*Symbol]*Function{
 		return &String{Value: sb.String()}, nil
 	}},
 	&Symbol{Value: "str"}: &Function{Fn: func(args ...Type) (Type, error) {
+		if err := realizeAll(args); err != nil {
+			return nil, err
+		}
 		var sb strings.Builder
 		for _, v := range args {
 			sb.WriteString(PrString(v, false))
@@ -100,36 +159,31 @@ var CoreNS = map[*Symbol]*Function{
 		return &String{Value: sb.String()}, nil
 	}},
 	&Symbol{Value: "prn"}: &Function{Fn: func(args ...Type) (Type, error) {
-		var sb strings.Builder
-		for i, v := range args {
-			sb.WriteString(PrString(v, true))
-			if i < len(args)-1 {
-				sb.WriteString(" ")
-			}
-		}
-		fmt.Println(sb.String())
-		return &Nil{}, nil
+		return printLine(os.Stdout, args, true)
 	}},
 	&Symbol{Value: "println"}: &Function{Fn: func(args ...Type) (Type, error) {
-		var sb strings.Builder
-		for i, v := range args {
-			sb.WriteString(PrString(v, false))
-			if i < len(args)-1 {
-				sb.WriteString(" ")
-			}
-		}
-		fmt.Println(sb.String())
-		return &Nil{}, nil
+		return printLine(os.Stdout, args, false)
 	}},
 
 	&Symbol{Value: "read-string"}: &Function{Fn: func(args ...Type) (Type, error) {
-		v, _ := args[0].(*String)
-		return ReadStr(v.Value)
+		ast, err := ReadStr(args[0].(*String).Value)
+		if ast == nil && err == nil { //nothing but whitespace and comments
+			return &Nil{}, nil
+		}
+		return ast, err
+	}},
+
+	//read every form in the string and return them as a list
+	&Symbol{Value: "read-all-string"}: &Function{Fn: func(args ...Type) (Type, error) {
+		forms, err := ReadAll(args[0].(*String).Value)
+		if err != nil {
+			return nil, err
+		}
+		return NewList(false, forms...), nil
 	}},
 
 	&Symbol{Value: "slurp"}: &Function{Fn: func(args ...Type) (Type, error) {
-		filename, _ := args[0].(*String)
-		dat, err := ioutil.ReadFile(filename.Value)
+		dat, err := ioutil.ReadFile(args[0].(*String).Value)
 		if err != nil {
 			return nil, err
 		}
@@ -145,111 +199,211 @@ var CoreNS = map[*Symbol]*Function{
 		return &Boolean{Value: ok}, nil
 	}},
 	&Symbol{Value: "deref"}: &Function{Fn: func(args ...Type) (Type, error) {
-		v, ok := args[0].(*Atom)
-		if !ok {
-			return nil, fmt.Errorf("Argument to deref is not an atom")
-		}
-		return v.Value, nil
+		return args[0].(*Atom).Value, nil
 	}},
 	&Symbol{Value: "reset!"}: &Function{Fn: func(args ...Type) (Type, error) {
-		v, _ := args[0].(*Atom)
-		v.Value = args[1]
+		args[0].(*Atom).Value = args[1]
 		return args[1], nil
 	}},
 	&Symbol{Value: "cons"}: &Function{Fn: func(args ...Type) (Type, error) {
 		v := args[0]
-		lst, _ := args[1].(*List)
-		newLst := NewList(false)
-		newLst.Value = append(newLst.Value, v)
-		newLst.Value = append(newLst.Value, lst.Value...)
-		return &newLst, nil
+		switch seq := args[1].(type) {
+		case *List:
+			return seq.Cons(v), nil
+		case *LazySeq:
+			return lazyCons(v, seq), nil
+		}
+		return NewList(false, v), nil
 	}},
 	&Symbol{Value: "concat"}: &Function{Fn: func(args ...Type) (Type, error) {
-		newLst := NewList(false)
+		var items []Type
 		for _, val := range args {
-			if v, ok := val.(*List); ok {
-				newLst.Value = append(newLst.Value, v.Value...)
-			} else {
-				return nil, fmt.Errorf("concat expects all parameters to be lists")
+			switch v := val.(type) {
+			case *List:
+				items = append(items, v.Slice()...)
+			case *LazySeq:
+				elements, err := elementsOf("concat", v)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, elements...)
 			}
 		}
-		return &newLst, nil
+		return NewList(false, items...), nil
 	}},
 	&Symbol{Value: "first"}: &Function{Fn: func(args ...Type) (Type, error) {
-		lst, isList := args[0].(*List)
-		nul, isNil := args[0].(*Nil)
-
-		if !isList && isNil {
-			return nul, nil
+		if lazy, ok := args[0].(*LazySeq); ok {
+			first, _, ok, err := uncons(lazy)
+			if !ok {
+				return &Nil{}, err
+			}
+			return first, nil
 		}
-		if len(lst.Value) == 0 {
+		lst, isList := args[0].(*List)
+		if !isList || lst.Len() == 0 {
 			return &Nil{}, nil
 		}
-		return lst.Value[0], nil
+		return lst.First(), nil
 	}},
 
 	&Symbol{Value: "nth"}: &Function{Fn: func(args ...Type) (Type, error) {
-		lst, _ := args[0].(*List)
-		idx, _ := args[1].(*Number)
-		if idx.Value >= 0 && int(idx.Value) < len(lst.Value) {
-			return lst.Value[int(idx.Value)], nil
+		idx := args[1].(*Number)
+		if lazy, ok := args[0].(*LazySeq); ok {
+			var seq Type = lazy
+			i, ok := idx.Int64()
+			for ; ok && i >= 0; i-- {
+				first, rest, ok, err := uncons(seq)
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					break
+				}
+				if i == 0 {
+					return first, nil
+				}
+				seq = rest
+			}
+			return nil, fmt.Errorf("nth: Index out of range")
+		}
+		lst := args[0].(*List)
+		if i, ok := idx.Int64(); ok && i >= 0 && i < int64(lst.Len()) {
+			return lst.Nth(int(i)), nil
 		}
 		return nil, fmt.Errorf("nth: Index out of range")
 	}},
 	&Symbol{Value: "rest"}: &Function{Fn: func(args ...Type) (Type, error) {
-		lst, isList := args[0].(*List)
-		_, isNil := args[0].(*Nil)
-
-		if !isNil && isList && len(lst.Value) >= 1 {
-			l := NewList(false)
-			l.Value = lst.Value[1:]
-			return &l, nil
+		if lazy, ok := args[0].(*LazySeq); ok {
+			_, rest, ok, err := uncons(lazy)
+			if !ok {
+				return NewList(false), err
+			}
+			return rest, nil
 		}
-		l := NewList(false)
-		return &l, nil
+		if lst, isList := args[0].(*List); isList {
+			return lst.Rest(), nil
+		}
+		return NewList(false), nil
 	}},
 	&Symbol{Value: "throw"}: &Function{Fn: func(args ...Type) (Type, error) {
-		err := Error{Value: args[0]}
-		return nil, &err
+		return nil, &Error{Value: args[0], thrown: true}
 	}},
+	&Symbol{Value: "ex-info"}:    &Function{Fn: exInfo},
+	&Symbol{Value: "ex-message"}: &Function{Fn: exMessage},
+	&Symbol{Value: "ex-data"}:    &Function{Fn: exData},
+	&Symbol{Value: "ex-cause"}:   &Function{Fn: exCause},
+	&Symbol{Value: "ex-stack"}:   &Function{Fn: exStack},
 	&Symbol{Value: "apply"}: &Function{Fn: func(args ...Type) (Type, error) {
-		fn, isFN := args[0].(*Function)
-
-		if !isFN || len(args) <= 1 {
-			return nil, fmt.Errorf("Invalid arguments to 'apply'")
-		}
-		fnArgs := make([]Type, 0)
-		for _, v := range args[1:] {
-			if asList, ok := v.(*List); ok {
-				for _, listEl := range asList.Value {
-					fnArgs = append(fnArgs, listEl)
-				}
-				continue
-			}
-			fnArgs = append(fnArgs, v)
+		fn := args[0].(*Function)
+		//the arguments in between are passed as they are, the elements of the last one are appended to them
+		last := args[len(args)-1]
+		fnArgs := make([]Type, 0, len(args))
+		fnArgs = append(fnArgs, args[1:len(args)-1]...)
+		elements, err := elementsOf("apply", last)
+		if err != nil {
+			return nil, err
 		}
-		return fn.Fn(fnArgs...)
+		return fn.Fn(append(fnArgs, elements...)...)
 	}},
 
 	&Symbol{Value: "map"}: &Function{Fn: func(args ...Type) (Type, error) {
-		fn, isFN := args[0].(*Function)
-
-		if !isFN || len(args) <= 1 {
-			return nil, fmt.Errorf("Invalid arguments to 'map'")
-		}
-
-		rList := NewList(false)
-		if asList, ok := args[1].(*List); ok {
-			for _, listEl := range asList.Value {
-				res, err := fn.Fn(listEl)
-				if err != nil {
-					return nil, err
-				}
-				rList.Value = append(rList.Value, res)
+		fn := args[0].(*Function)
+		seqs := make([]Type, len(args)-1)
+		for i, coll := range args[1:] {
+			seq, err := seqOf("map", coll)
+			if err != nil {
+				return nil, err
 			}
+			seqs[i] = seq
+		}
+		//mapping over lazy sequences, which may be infinite, is lazy. Mapping over other collections is done right
+		//away, so that errors are raised where map is called
+		results := lazyMap(fn, seqs)
+		if isLazy(seqs) {
+			return results, nil
+		}
+		elements, err := elementsOf("map", results)
+		if err != nil {
+			return nil, err
 		}
+		return NewList(false, elements...), nil
+	}},
 
-		return &rList, nil
+	//(range), (range end), (range start end) or (range start end step) returns a lazy sequence of numbers from
+	//start (0 by default) up to, but not including end (infinite by default), in increments of step (1 by default)
+	&Symbol{Value: "range"}: &Function{Fn: func(args ...Type) (Type, error) {
+		nums := numbers(args)
+		switch len(nums) {
+		case 0:
+			return lazyRange(NewInt(0), nil, NewInt(1)), nil
+		case 1:
+			return lazyRange(NewInt(0), nums[0], NewInt(1)), nil
+		case 2:
+			return lazyRange(nums[0], nums[1], NewInt(1)), nil
+		}
+		if nums[2].Sign() == 0 {
+			return nil, fmt.Errorf("range: step must not be 0")
+		}
+		return lazyRange(nums[0], nums[1], nums[2]), nil
+	}},
+	&Symbol{Value: "iterate"}: &Function{Fn: func(args ...Type) (Type, error) {
+		return lazyIterate(args[0].(*Function), args[1]), nil
+	}},
+	//(repeat x) returns an infinite lazy sequence of x, (repeat n x) one of n times x
+	&Symbol{Value: "repeat"}: &Function{Fn: func(args ...Type) (Type, error) {
+		if len(args) == 1 {
+			return lazyRepeat(-1, args[0]), nil
+		}
+		n, err := countArg("repeat", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return lazyRepeat(n, args[1]), nil
+	}},
+	&Symbol{Value: "cycle"}: &Function{Fn: func(args ...Type) (Type, error) {
+		seq, err := seqOf("cycle", args[0])
+		if err != nil {
+			return nil, err
+		}
+		return lazyCycle(seq, seq), nil
+	}},
+	&Symbol{Value: "take"}: &Function{Fn: func(args ...Type) (Type, error) {
+		n, err := countArg("take", args[0])
+		if err != nil {
+			return nil, err
+		}
+		seq, err := seqOf("take", args[1])
+		if err != nil {
+			return nil, err
+		}
+		return lazyTake(n, seq), nil
+	}},
+	&Symbol{Value: "drop"}: &Function{Fn: func(args ...Type) (Type, error) {
+		n, err := countArg("drop", args[0])
+		if err != nil {
+			return nil, err
+		}
+		seq, err := seqOf("drop", args[1])
+		if err != nil {
+			return nil, err
+		}
+		return lazyDrop(n, seq), nil
+	}},
+	&Symbol{Value: "take-while"}: &Function{Fn: func(args ...Type) (Type, error) {
+		pred := args[0].(*Function)
+		seq, err := seqOf("take-while", args[1])
+		if err != nil {
+			return nil, err
+		}
+		return lazyTakeWhile(pred, seq), nil
+	}},
+	&Symbol{Value: "filter"}: &Function{Fn: func(args ...Type) (Type, error) {
+		pred := args[0].(*Function)
+		seq, err := seqOf("filter", args[1])
+		if err != nil {
+			return nil, err
+		}
+		return lazyFilter(pred, seq), nil
 	}},
 
 	/* Takes an atom, a function, and zero or more function arguments.
@@ -257,8 +411,8 @@ var CoreNS = map[*Symbol]*Function{
 	with the atom's value as the first argument and the optionally given
 	function arguments as the rest of the arguments. The new atom's value is returned */
 	&Symbol{Value: "swap!"}: &Function{Fn: func(args ...Type) (Type, error) {
-		v, _ := args[0].(*Atom)
-		fn, _ := args[1].(*Function)
+		v := args[0].(*Atom)
+		fn := args[1].(*Function)
 		optargs := args[2:]
 		fnArgs := make([]Type, len(optargs)+1)
 		fnArgs[0] = v.Value
@@ -289,32 +443,31 @@ var CoreNS = map[*Symbol]*Function{
 		return &Boolean{Value: ok}, nil
 	}},
 	&Symbol{Value: "symbol"}: &Function{Fn: func(args ...Type) (Type, error) {
-		str, _ := args[0].(*String)
-		return &Symbol{Value: str.Value}, nil
+		return &Symbol{Value: args[0].(*String).Value}, nil
 	}},
 	&Symbol{Value: "keyword"}: &Function{Fn: func(args ...Type) (Type, error) {
 		if kw, ok := args[0].(*Keyword); ok {
 			return kw, nil
 		}
-		str, _ := args[0].(*String)
-		return &Keyword{Value: ":" + str.Value}, nil
+		return &Keyword{Value: ":" + args[0].(*String).Value}, nil
 	}},
 	&Symbol{Value: "keyword?"}: &Function{Fn: func(args ...Type) (Type, error) {
 		_, ok := args[0].(*Keyword)
 		return &Boolean{Value: ok}, nil
 	}},
 	&Symbol{Value: "vector"}: &Function{Fn: func(args ...Type) (Type, error) {
-		vec := NewList(true)
-		vec.Value = append(vec.Value, args...)
-		return &vec, nil
+		return NewList(true, args...), nil
 	}},
 	&Symbol{Value: "vector?"}: &Function{Fn: func(args ...Type) (Type, error) {
 		vec, ok := args[0].(*List)
 		return &Boolean{Value: ok && vec.IsVector == true}, nil
 	}},
 	&Symbol{Value: "sequential?"}: &Function{Fn: func(args ...Type) (Type, error) {
-		_, ok := args[0].(*List)
-		return &Boolean{Value: ok}, nil
+		switch args[0].(type) {
+		case *List, *LazySeq:
+			return &Boolean{Value: true}, nil
+		}
+		return &Boolean{Value: false}, nil
 	}},
 	&Symbol{Value: "hash-map"}: &Function{Fn: func(args ...Type) (Type, error) {
 		if len(args)%2 != 0 {
@@ -322,13 +475,7 @@ var CoreNS = map[*Symbol]*Function{
 		}
 		hmap := NewHashMap()
 		for i := 0; i < len(args); i += 2 {
-			key := args[i]
-			value := args[i+1]
-			strKey, err := TypeToHashKey(key)
-			if err != nil {
-				return nil, err
-			}
-			hmap.Value[strKey] = value
+			hmap.Set(args[i], args[i+1])
 		}
 		return &hmap, nil
 	}},
@@ -340,119 +487,170 @@ var CoreNS = map[*Symbol]*Function{
 	&Symbol{Value: "assoc"}: &Function{Fn: func(args ...Type) (Type, error) {
 		toAssoc := args[1:]
 		if len(toAssoc)%2 != 0 {
-			return nil, fmt.Errorf("hash-map requires an even number of arguments")
-		}
-		originalMap, ok := args[0].(*HashMap)
-		if !ok {
-			return nil, fmt.Errorf("First argument to assoc must be a hash map")
-		}
-		hmap := NewHashMap()
-		for k, v := range originalMap.Value {
-			hmap.Value[k] = v
+			return nil, fmt.Errorf("assoc requires a map followed by an even number of keys and values")
 		}
+		hmap := args[0].(*HashMap).Copy()
+		hmap.Meta = nil
 		for i := 0; i < len(toAssoc); i += 2 {
-			key := toAssoc[i]
-			value := toAssoc[i+1]
-			strKey, err := TypeToHashKey(key)
-			if err != nil {
-				return nil, err
-			}
-			hmap.Value[strKey] = value
+			hmap.Set(toAssoc[i], toAssoc[i+1])
 		}
 		return &hmap, nil
 	}},
 
 	&Symbol{Value: "dissoc"}: &Function{Fn: func(args ...Type) (Type, error) {
 		toDissoc := args[1:]
-		originalMap, ok := args[0].(*HashMap)
-		if !ok {
-			return nil, fmt.Errorf("First argument to dissoc must be a hash map")
+		originalMap := args[0].(*HashMap)
+		hmap := originalMap
+		for _, key := range toDissoc {
+			hmap = hmap.Dissoc(key)
 		}
-		hmap := NewHashMap()
-	outer:
-		for k, v := range originalMap.Value {
-			for _, key := range toDissoc {
-				strKey, err := TypeToHashKey(key)
-				if err != nil {
-					return nil, err
-				}
-				if strKey == k {
-					continue outer
-				}
-			}
-			hmap.Value[k] = v
+		if hmap == originalMap {
+			return hmap, nil
 		}
-		return &hmap, nil
+		newMap := hmap.Copy()
+		newMap.Meta = nil
+		return &newMap, nil
 	}},
 	&Symbol{Value: "get"}: &Function{Fn: func(args ...Type) (Type, error) {
 		key := args[1]
-		hmap, ok := args[0].(*HashMap)
-		if !ok {
-			return &Nil{}, nil
-		}
-		strKey, err := TypeToHashKey(key)
-		if err != nil {
-			return nil, err
+		var val Type
+		var ok bool
+		switch coll := args[0].(type) {
+		case *HashMap:
+			val, ok = coll.Get(key)
+		case *Set:
+			val, ok = coll.Get(key)
 		}
-		if val, ok := hmap.Value[strKey]; ok {
+		if ok {
 			return val, nil
 		}
 		return &Nil{}, nil
 	}},
 	&Symbol{Value: "contains?"}: &Function{Fn: func(args ...Type) (Type, error) {
 		key := args[1]
-		hmap, ok := args[0].(*HashMap)
-		if !ok {
-			return nil, fmt.Errorf("First argument to contains? must be a hash map")
+		if hmap, ok := args[0].(*HashMap); ok {
+			_, ok := hmap.Get(key)
+			return &Boolean{Value: ok}, nil
+		}
+		return &Boolean{Value: args[0].(*Set).Contains(key)}, nil
+	}},
+	&Symbol{Value: "keys"}: &Function{Fn: func(args ...Type) (Type, error) {
+		hmap := args[0].(*HashMap)
+		var keys []Type
+		for _, e := range hmap.Entries() {
+			keys = append(keys, e.Key)
 		}
-		strKey, err := TypeToHashKey(key)
+		return NewList(false, keys...), nil
+	}},
+	&Symbol{Value: "vals"}: &Function{Fn: func(args ...Type) (Type, error) {
+		hmap := args[0].(*HashMap)
+		var vals []Type
+		for _, e := range hmap.Entries() {
+			vals = append(vals, e.Value)
+		}
+		return NewList(false, vals...), nil
+	}},
+
+	&Symbol{Value: "hash-set"}: &Function{Fn: func(args ...Type) (Type, error) {
+		set := NewSet()
+		for _, v := range args {
+			set.Add(v)
+		}
+		return &set, nil
+	}},
+	//return a set of the elements of a collection
+	&Symbol{Value: "set"}: &Function{Fn: func(args ...Type) (Type, error) {
+		elements, err := elementsOf("set", args[0])
 		if err != nil {
 			return nil, err
 		}
-		if _, ok := hmap.Value[strKey]; ok {
-			return &Boolean{Value: true}, nil
+		set := NewSet()
+		for _, v := range elements {
+			set.Add(v)
 		}
-		return &Boolean{Value: false}, nil
+		return &set, nil
 	}},
-	&Symbol{Value: "keys"}: &Function{Fn: func(args ...Type) (Type, error) {
-		hmap, ok := args[0].(*HashMap)
-		if !ok {
-			return nil, fmt.Errorf("First argument to assoc must be a hash map")
-		}
-		keyList := NewList(false)
-		for k := range hmap.Value {
-			keyList.Value = append(keyList.Value, NativeStringToMalHashKey(k))
+	&Symbol{Value: "set?"}: &Function{Fn: func(args ...Type) (Type, error) {
+		_, ok := args[0].(*Set)
+		return &Boolean{Value: ok}, nil
+	}},
+	&Symbol{Value: "disj"}: &Function{Fn: func(args ...Type) (Type, error) {
+		newSet := args[0].(*Set).Disj(args[1:]...)
+		newSet.Meta = nil
+		return newSet, nil
+	}},
+	&Symbol{Value: "union"}: &Function{Fn: func(args ...Type) (Type, error) {
+		sets := setsOf(args)
+		union := NewSet()
+		for _, set := range sets {
+			for _, v := range set.Slice() {
+				union.Add(v)
+			}
 		}
-		return &keyList, nil
+		return &union, nil
 	}},
-	&Symbol{Value: "vals"}: &Function{Fn: func(args ...Type) (Type, error) {
-		hmap, ok := args[0].(*HashMap)
-		if !ok {
-			return nil, fmt.Errorf("First argument to assoc must be a hash map")
+	//return a set of the elements of the first set that are contained in all others
+	&Symbol{Value: "intersection"}: &Function{Fn: func(args ...Type) (Type, error) {
+		sets := setsOf(args)
+		intersection := NewSet()
+	elements:
+		for _, v := range sets[0].Slice() {
+			for _, set := range sets[1:] {
+				if !set.Contains(v) {
+					continue elements
+				}
+			}
+			intersection.Add(v)
 		}
-		valList := NewList(false)
-		for _, val := range hmap.Value {
-			valList.Value = append(valList.Value, val)
+		return &intersection, nil
+	}},
+	//return a set of the elements of the first set that are not contained in any of the others
+	&Symbol{Value: "difference"}: &Function{Fn: func(args ...Type) (Type, error) {
+		sets := setsOf(args)
+		difference := *sets[0]
+		difference.Meta = nil
+		for _, set := range sets[1:] {
+			difference = *difference.Disj(set.Slice()...)
 		}
-		return &valList, nil
+		return &difference, nil
 	}},
 
-	&Symbol{Value: "readline"}: &Function{Fn: func(args ...Type) (Type, error) {
-		str, isString := args[0].(*String)
-		stdin := bufio.NewReader(os.Stdin)
-		if isString {
-			fmt.Print(str.Value)
+	&Symbol{Value: "re-pattern"}: &Function{Fn: func(args ...Type) (Type, error) {
+		if re, ok := args[0].(*Regex); ok {
+			return re, nil
 		}
-		s, err := stdin.ReadString('\n')
-		s = strings.Trim(s, "\n")
+		re, err := regexp.Compile(args[0].(*String).Value)
 		if err != nil {
-			return &Nil{}, nil
+			return nil, err
 		}
-		return &String{Value: s}, nil
+		return &Regex{Value: re}, nil
+	}},
+	//return the first match of the regex in the string, as a string, or as a vector of the match and
+	//its groups if the regex has groups
+	&Symbol{Value: "re-find"}: &Function{Fn: func(args ...Type) (Type, error) {
+		re, str := args[0].(*Regex).Value, args[1].(*String).Value
+		return regexMatch(re, re.FindStringSubmatch(str)), nil
+	}},
+	//like re-find, but the regex has to match the whole string
+	&Symbol{Value: "re-matches"}: &Function{Fn: func(args ...Type) (Type, error) {
+		re, str := args[0].(*Regex).Value, args[1].(*String).Value
+		anchored, err := regexp.Compile(`^(?:` + re.String() + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		return regexMatch(anchored, anchored.FindStringSubmatch(str)), nil
+	}},
+	&Symbol{Value: "regex?"}: &Function{Fn: func(args ...Type) (Type, error) {
+		_, ok := args[0].(*Regex)
+		return &Boolean{Value: ok}, nil
+	}},
+
+	&Symbol{Value: "readline"}: &Function{Fn: func(args ...Type) (Type, error) {
+		return readLine(bufio.NewReader(os.Stdin), os.Stdout, args)
 	}},
 	&Symbol{Value: "time-ms"}: &Function{Fn: func(args ...Type) (Type, error) {
-		t := time.Now().UnixNano() / time.Millisecond.Milliseconds()
-		return &Number{Value: float64(t)}, nil
+		t := time.Now().UnixNano() / time.Millisecond.Nanoseconds()
+		return NewInt(t), nil
 	}},
 	&Symbol{Value: "fn?"}: &Function{Fn: func(args ...Type) (Type, error) {
 		fn, ok := args[0].(*Function)
@@ -473,50 +671,48 @@ var CoreNS = map[*Symbol]*Function{
 	}},
 	&Symbol{Value: "seq"}: &Function{Fn: func(args ...Type) (Type, error) {
 		if list, ok := args[0].(*List); ok {
-			if len(list.Value) == 0 {
+			if list.Len() == 0 {
 				return &Nil{}, nil
 			}
-			if list.IsVector {
-				newList := NewList(false)
-				newList.Value = list.Value
-				return &newList, nil
-			}
-			return list, nil
+			return list.asList(), nil
 		}
 		if str, ok := args[0].(*String); ok {
 			if len(str.Value) == 0 {
 				return &Nil{}, nil
 			}
-			newList := NewList(false)
-			sList := strings.Split(str.Value, "")
-			for _, val := range sList {
-				newList.Value = append(newList.Value, &String{Value: val})
+			var chars []Type
+			for _, val := range strings.Split(str.Value, "") {
+				chars = append(chars, &String{Value: val})
 			}
-			return &newList, nil
+			return NewList(false, chars...), nil
+		}
+		if set, ok := args[0].(*Set); ok {
+			if set.Len() == 0 {
+				return &Nil{}, nil
+			}
+			return NewList(false, set.Slice()...), nil
 		}
-		if nul, ok := args[0].(*Nil); ok {
-			return nul, nil
+		if lazy, ok := args[0].(*LazySeq); ok {
+			if _, _, ok, err := uncons(lazy); !ok {
+				return &Nil{}, err
+			}
+			return lazy, nil
 		}
-		return nil, fmt.Errorf("seq: Argument 1 must be of type vector, list or string")
+		return args[0], nil
 	}},
 	&Symbol{Value: "conj"}: &Function{Fn: func(args ...Type) (Type, error) {
 		if list, ok := args[0].(*List); ok {
-			newList := NewList(list.IsVector)
-			els := args[1:]
-			if !list.IsVector {
-				for i := len(els) - 1; i >= 0; i-- {
-					newList.Value = append(newList.Value, els[i])
-				}
-				newList.Value = append(newList.Value, list.Value...)
-				return &newList, nil
-			}
-			newList.Value = append(newList.Value, list.Value...)
-			for _, el := range els {
-				newList.Value = append(newList.Value, el)
+			return list.Conj(args[1:]...), nil
+		}
+		if lazy, ok := args[0].(*LazySeq); ok {
+			for _, v := range args[1:] {
+				lazy = lazyCons(v, lazy)
 			}
-			return &newList, nil
+			return lazy, nil
 		}
-		return nil, fmt.Errorf("conj: Argument 1 must be of type vector, or list")
+		newSet := args[0].(*Set).Conj(args[1:]...)
+		newSet.Meta = nil
+		return newSet, nil
 	}},
 	&Symbol{Value: "meta"}: &Function{Fn: func(args ...Type) (Type, error) {
 		if hmap, ok := args[0].(*HashMap); ok {
@@ -531,44 +727,49 @@ var CoreNS = map[*Symbol]*Function{
 			}
 			return list.Meta, nil
 		}
+		if set, ok := args[0].(*Set); ok {
+			if set.Meta == nil {
+				return &Nil{}, nil
+			}
+			return set.Meta, nil
+		}
 		if atom, ok := args[0].(*Atom); ok {
 			if atom.Meta == nil {
 				return &Nil{}, nil
 			}
 			return atom.Meta, nil
 		}
-		if fn, ok := args[0].(*Function); ok {
-			if fn.Meta == nil {
-				return &Nil{}, nil
-			}
-			return fn.Meta, nil
+		fn := args[0].(*Function)
+		if fn.Meta == nil {
+			return &Nil{}, nil
 		}
-		return nil, fmt.Errorf("meta: Cannot retrieve metadata to non composite or function type %T", args[0])
+		return fn.Meta, nil
 	}},
 	&Symbol{Value: "with-meta"}: &Function{Fn: func(args ...Type) (Type, error) {
 		if hmap, ok := args[0].(*HashMap); ok {
-			newmap := NewHashMap()
-			newmap.Value = hmap.Value
+			newmap := *hmap
 			newmap.Meta = args[1]
 			return &newmap, nil
 		}
 		if list, ok := args[0].(*List); ok {
-			newList := NewList(list.IsVector)
-			newList.Value = list.Value
+			newList := *list
 			newList.Meta = args[1]
+			newList.Pos = nil
 			return &newList, nil
 		}
+		if set, ok := args[0].(*Set); ok {
+			newSet := *set
+			newSet.Meta = args[1]
+			return &newSet, nil
+		}
 		if atom, ok := args[0].(*Atom); ok {
 			newAtom := Atom{Value: atom.Value}
 			newAtom.Meta = args[1]
 			return &newAtom, nil
 		}
-		if fn, ok := args[0].(*Function); ok {
-			newFn := CopyOfFunction(fn)
-			newFn.Meta = args[1]
-			return newFn, nil
-		}
-		return nil, fmt.Errorf("with-meta: Cannot add metadata to non composite or function type %T", args[0])
+		newFn := CopyOfFunction(args[0].(*Function))
+		newFn.Meta = args[1]
+		return newFn, nil
 	}},
 
 	// compare the first two parameters and return true if they are the same type and
@@ -579,80 +780,284 @@ var CoreNS = map[*Symbol]*Function{
 	&Symbol{Value: "="}: &Function{Fn: compareFunc},
 }
 
+//numbers returns the arguments of a function whose signature only accepts numbers
+func numbers(args []Type) []*Number {
+	nums := make([]*Number, len(args))
+	for i, arg := range args {
+		nums[i] = arg.(*Number)
+	}
+	return nums
+}
+
+//setsOf returns the arguments of a function whose signature only accepts sets
+func setsOf(args []Type) []*Set {
+	sets := make([]*Set, len(args))
+	for i, arg := range args {
+		sets[i] = arg.(*Set)
+	}
+	return sets
+}
+
+//count returns the number of elements of a collection, or 0 for anything else. Lazy sequences are fully realized
+func count(coll Type) (int, error) {
+	switch v := coll.(type) {
+	case *List:
+		return v.Len(), nil
+	case *HashMap:
+		return v.Len(), nil
+	case *Set:
+		return v.Len(), nil
+	case *LazySeq:
+		elements, err := elementsOf("count", v)
+		return len(elements), err
+	}
+	return 0, nil
+}
+
+//elementsOf returns the elements of a collection. The elements of a hash map are [key value] vectors
+func elementsOf(name string, coll Type) ([]Type, error) {
+	switch v := coll.(type) {
+	case *List:
+		return v.Slice(), nil
+	case *Set:
+		return v.Slice(), nil
+	case *HashMap:
+		var entries []Type
+		for _, e := range v.Entries() {
+			entries = append(entries, NewList(true, e.Key, e.Value))
+		}
+		return entries, nil
+	case *String:
+		var chars []Type
+		for _, c := range v.Value {
+			chars = append(chars, &String{Value: string(c)})
+		}
+		return chars, nil
+	case *LazySeq:
+		return v.Slice()
+	case *Nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%s: expected a collection, got %s", name, typeName(coll))
+}
+
+//printLine prints args separated by spaces and followed by a newline, like prn and println do
+func printLine(w io.Writer, args []Type, readably bool) (Type, error) {
+	if err := realizeAll(args); err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	for i, v := range args {
+		sb.WriteString(PrString(v, readably))
+		if i < len(args)-1 {
+			sb.WriteString(" ")
+		}
+	}
+	fmt.Fprintln(w, sb.String())
+	return &Nil{}, nil
+}
+
+//readLine prints the prompt given in args and reads a line from in. At the end of input it returns nil
+func readLine(in *bufio.Reader, out io.Writer, args []Type) (Type, error) {
+	if len(args) > 0 {
+		if str, isString := args[0].(*String); isString {
+			fmt.Fprint(out, str.Value)
+		}
+	}
+	s, err := in.ReadString('\n')
+	s = strings.Trim(s, "\n")
+	if err != nil {
+		return &Nil{}, nil
+	}
+	return &String{Value: s}, nil
+}
+
+//realizeAll realizes the lazy sequences in values, so that errors they run into are reported before printing them
+func realizeAll(values []Type) error {
+	for _, v := range values {
+		if err := Realize(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//countArg checks that arg is an integer that can be used as a number of elements
+func countArg(name string, arg Type) (int64, error) {
+	if n, ok := arg.(*Number); ok {
+		if i, ok := n.Int64(); ok {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: Argument 1 must be of type integer, got %s", name, PrString(arg, true))
+}
+
+//compareNumbers checks that every argument compares to the next one as expected by ok, e.g. (< 1 2 3)
+func compareNumbers(args []Type, ok func(c int) bool) (Type, error) {
+	nums := numbers(args)
+	for i := 1; i < len(nums); i++ {
+		if !ok(nums[i-1].Cmp(nums[i])) {
+			return &Boolean{Value: false}, nil
+		}
+	}
+	return &Boolean{Value: true}, nil
+}
+
+func regexMatch(re *regexp.Regexp, match []string) Type {
+	if match == nil {
+		return &Nil{}
+	}
+	if re.NumSubexp() == 0 {
+		return &String{Value: match[0]}
+	}
+	groups := make([]Type, len(match))
+	for i, group := range match {
+		groups[i] = &String{Value: group}
+	}
+	return NewList(true, groups...)
+}
+
+//compareSequences compares lists, vectors and lazy sequences, which are nested depth levels deep, element by element
+func compareSequences(a Type, b Type, depth int) (bool, error) {
+	for _, v := range []Type{a, b} {
+		switch v.(type) {
+		case *List, *LazySeq:
+		default:
+			return false, nil
+		}
+	}
+	for {
+		first1, rest1, ok1, err := uncons(a)
+		if err != nil {
+			return false, err
+		}
+		first2, rest2, ok2, err := uncons(b)
+		if err != nil {
+			return false, err
+		}
+		if !ok1 || !ok2 {
+			return ok1 == ok2, nil
+		}
+		if eq, err := equalNested(first1, first2, depth+1); err != nil || !eq {
+			return false, err
+		}
+		a, b = rest1, rest2
+	}
+}
+
+//nestingError is the error of comparing or realizing collections nested deeper than MaxNesting
+func nestingError() error {
+	return Errorf("Maximum nesting depth of %d exceeded", MaxNesting)
+}
+
+//equals compares two mal values like the = function does
+func equals(a Type, b Type) bool {
+	eq, err := equal(a, b)
+	return err == nil && eq
+}
+
 func compareFunc(args ...Type) (Type, error) {
-	if reflect.TypeOf(args[0]) != reflect.TypeOf(args[1]) {
-		return &Boolean{Value: false}, nil
+	eq, err := equal(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return &Boolean{Value: eq}, nil
+}
+
+//equal compares two mal values without allocating, which matters for the keys of hash maps and sets
+func equal(a Type, b Type) (bool, error) {
+	return equalNested(a, b, 0)
+}
+
+//equalNested compares two mal values that are nested depth levels deep. It fails for collections nested deeper
+//than MaxNesting
+func equalNested(a Type, b Type, depth int) (bool, error) {
+	if a == b { // however deep it is nested
+		return true, nil
 	}
-	switch v := args[0].(type) {
+	switch a.(type) {
+	case *List, *LazySeq, *HashMap, *Set:
+		if depth >= MaxNesting {
+			return false, nestingError()
+		}
+	}
+	if isLazy([]Type{a, b}) {
+		return compareSequences(a, b, depth)
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false, nil
+	}
+	switch v := a.(type) {
 	case *Symbol:
-		v2, _ := args[1].(*Symbol)
-		return &Boolean{Value: v.Value == v2.Value}, nil
+		v2, _ := b.(*Symbol)
+		return v.Value == v2.Value, nil
 	case *Number:
-		v2, _ := args[1].(*Number)
-		return &Boolean{Value: v.Value == v2.Value}, nil
+		//exact numbers and floats are never equal, like in clojure
+		v2, _ := b.(*Number)
+		return (v.Kind == FloatNumber) == (v2.Kind == FloatNumber) && v.Cmp(v2) == 0, nil
 	case *List:
-		v2, _ := args[1].(*List)
-		if len(v.Value) != len(v2.Value) {
-			return &Boolean{Value: false}, nil
+		v2, _ := b.(*List)
+		if v.Len() != v2.Len() {
+			return false, nil
 		}
-		for i := range v.Value {
-			r, _ := compareFunc(v.Value[i], v2.Value[i])
-			rbool, _ := r.(*Boolean)
-			if rbool.Value == false {
-				return &Boolean{Value: false}, nil
+		items, items2 := v.Slice(), v2.Slice()
+		for i := range items {
+			if eq, err := equalNested(items[i], items2[i], depth+1); err != nil || !eq {
+				return false, err
 			}
 		}
-		return &Boolean{Value: true}, nil
+		return true, nil
 	case *HashMap:
-		v2, _ := args[1].(*HashMap)
-		k1 := keysFromMap(v.Value)
-		k2 := keysFromMap(v2.Value)
-		if len(k1) != len(k2) {
-			return &Boolean{Value: false}, nil
+		v2, _ := b.(*HashMap)
+		if v.Len() != v2.Len() {
+			return false, nil
 		}
-		sort.Strings(k1)
-		sort.Strings(k2)
-
-		for i := range k1 {
-			if k1[i] != k2[i] {
-				return &Boolean{Value: false}, nil
+		for _, e := range v.Entries() {
+			entry, ok, err := v2.find(e.Key, depth+1)
+			if err != nil || !ok {
+				return false, err
 			}
-			r, _ := compareFunc(v.Value[k1[i]], v2.Value[k2[i]])
-			rbool, _ := r.(*Boolean)
-			if rbool.Value == false {
-				return &Boolean{Value: false}, nil
+			if eq, err := equalNested(e.Value, entry.Value, depth+1); err != nil || !eq {
+				return false, err
 			}
 		}
-
-		return &Boolean{Value: true}, nil
+		return true, nil
 	case *Boolean:
-		v2, _ := args[1].(*Boolean)
-		return &Boolean{Value: v.Value == v2.Value}, nil
+		v2, _ := b.(*Boolean)
+		return v.Value == v2.Value, nil
 	case *Nil:
-		return &Boolean{Value: true}, nil
+		return true, nil
 	case *Function:
-		return &Boolean{Value: false}, nil // Go cant == functions, false seems to make the most sense
+		v2, _ := b.(*Function)
+		return v == v2, nil // functions are only equal to themselves
 	case *String:
-		v2, _ := args[1].(*String)
-		return &Boolean{Value: v.Value == v2.Value}, nil
+		v2, _ := b.(*String)
+		return v.Value == v2.Value, nil
 	case *Keyword:
-		v2, _ := args[1].(*Keyword)
-		return &Boolean{Value: v.Value == v2.Value}, nil
+		v2, _ := b.(*Keyword)
+		return v.Value == v2.Value, nil
 	case *Atom:
-		v2, _ := args[1].(*Atom)
-		return &Boolean{Value: v == v2}, nil
+		v2, _ := b.(*Atom)
+		return v == v2, nil
+	case *ExInfo:
+		v2, _ := b.(*ExInfo)
+		return v == v2, nil // like exceptions in clojure
+	case *Set:
+		v2, _ := b.(*Set)
+		if v.Len() != v2.Len() {
+			return false, nil
+		}
+		for _, el := range v.Slice() {
+			if _, ok, err := v2.elements.find(el, depth+1); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case *Regex:
+		v2, _ := b.(*Regex)
+		return v.Value.String() == v2.Value.String(), nil
 
 	default:
-		return nil, fmt.Errorf("No equals operation implemented for type: %T", v)
-	}
-}
-
-func keysFromMap(themap map[string]Type) []string {
-	keys := make([]string, len(themap))
-	i := 0
-	for k := range themap {
-		keys[i] = k
-		i++
+		return false, fmt.Errorf("No equals operation implemented for type: %s", typeName(v))
 	}
-	return keys
 }
@@ -0,0 +1,285 @@
+package mal
+
+// Destructuring of the binding forms of let*, loop and fn*. The analyzer turns the bindings of sequential and
+// associative patterns into bindings of plain symbols, before it analyzes them. For example
+//
+//	(let* [[a & more :as all] xs] ...)
+//
+// becomes
+//
+//	(let* [s (destructure-seq xs [a & more :as all]) a (destructure-nth s 0) more (destructure-rest s 1) all s] ...)
+//
+// where s is a generated symbol that can't be read, and destructure-seq, destructure-nth and destructure-rest are
+// the functions below. Those fail with an error naming the pattern if the value doesn't have its shape
+
+import (
+	"fmt"
+)
+
+//destructurer turns the bindings of the binding form at pos into bindings of plain symbols
+type destructurer struct {
+	in  *Interpreter
+	pos *Position
+}
+
+//isPattern tells whether a binding form is a pattern rather than a symbol
+func isPattern(target Type) bool {
+	switch target.(type) {
+	case *List, *HashMap:
+		return true
+	}
+	return false
+}
+
+//bindings turns a list of binding forms and values into one in which all binding forms are symbols
+func (d *destructurer) bindings(bindings *List) ([]Type, error) {
+	var flat []Type
+	for i := 0; i+1 < bindings.Len(); i += 2 {
+		var err error
+		if flat, err = d.bind(flat, bindings.Nth(i), bindings.Nth(i+1)); err != nil {
+			return nil, err
+		}
+	}
+	return flat, nil
+}
+
+//bind appends to bindings the bindings that bind the symbols in target to the parts of the value of form
+func (d *destructurer) bind(bindings []Type, target Type, form Type) ([]Type, error) {
+	switch t := target.(type) {
+	case *Symbol:
+		return append(bindings, t, form), nil
+	case *List:
+		return d.bindSeq(bindings, t, form)
+	case *HashMap:
+		return d.bindMap(bindings, t, form)
+	}
+	return nil, fmt.Errorf("Unsupported binding form: %s", PrString(target, true))
+}
+
+//bindSeq destructures a sequential pattern like [a b & more :as all]
+func (d *destructurer) bindSeq(bindings []Type, pattern *List, form Type) ([]Type, error) {
+	seq := d.in.generateSymbol()
+	bindings = append(bindings, seq, d.call(destructureSeq, form, quoted(pattern)))
+	items := pattern.Slice()
+	n := 0
+	rest := false
+	for i := 0; i < len(items); i++ {
+		if kw, ok := items[i].(*Keyword); ok && kw.Value == ":as" {
+			name, ok := nthItem(items, i+1).(*Symbol)
+			if !ok || i+2 != len(items) {
+				return nil, fmt.Errorf("%s: :as must be followed by a symbol at the end of the pattern", PrString(pattern, true))
+			}
+			return append(bindings, name, seq), nil
+		}
+		if rest {
+			return nil, fmt.Errorf("%s: only :as can follow the binding form after &", PrString(pattern, true))
+		}
+		var err error
+		if name, ok := items[i].(*Symbol); ok && name.Value == "&" {
+			if i+1 == len(items) {
+				return nil, fmt.Errorf("%s: & must be followed by a binding form", PrString(pattern, true))
+			}
+			i++
+			rest = true
+			bindings, err = d.bind(bindings, items[i], d.call(destructureRest, seq, NewInt(int64(n))))
+		} else {
+			bindings, err = d.bind(bindings, items[i], d.call(destructureNth, seq, NewInt(int64(n))))
+			n++
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+//bindMap destructures an associative pattern like {a :a, :keys [b c], :or {c 1}, :as all}
+func (d *destructurer) bindMap(bindings []Type, pattern *HashMap, form Type) ([]Type, error) {
+	hmap := d.in.generateSymbol()
+	bindings = append(bindings, hmap, d.call(destructureMap, form, quoted(pattern)))
+	defaults := NewHashMap()
+	if or, ok := pattern.Get(&Keyword{Value: ":or"}); ok {
+		orMap, ok := or.(*HashMap)
+		if !ok {
+			return nil, fmt.Errorf("%s: :or must be followed by a map of symbols to default values", PrString(pattern, true))
+		}
+		defaults = *orMap
+	}
+	// a default value is only evaluated if the map doesn't have the key
+	get := func(name Type, key Type) Type {
+		if def, ok := defaults.Get(name); ok {
+			return NewList(false, &Symbol{Value: "if"}, d.call(destructureHas, hmap, key), d.call(destructureGet, hmap, key), def)
+		}
+		return d.call(destructureGet, hmap, key)
+	}
+
+	for _, e := range pattern.Entries() {
+		kw, _ := e.Key.(*Keyword)
+		var err error
+		switch {
+		case kw != nil && kw.Value == ":or":
+		case kw != nil && kw.Value == ":as":
+			name, ok := e.Value.(*Symbol)
+			if !ok {
+				return nil, fmt.Errorf("%s: :as must be followed by a symbol", PrString(pattern, true))
+			}
+			bindings = append(bindings, name, hmap)
+		case kw != nil && (kw.Value == ":keys" || kw.Value == ":strs" || kw.Value == ":syms"):
+			names, ok := e.Value.(*List)
+			if !ok {
+				return nil, fmt.Errorf("%s: %s must be followed by a vector of symbols", PrString(pattern, true), kw.Value)
+			}
+			for _, n := range names.Slice() {
+				name, ok := n.(*Symbol)
+				if !ok {
+					return nil, fmt.Errorf("%s: %s must be followed by a vector of symbols", PrString(pattern, true), kw.Value)
+				}
+				var key Type
+				switch kw.Value {
+				case ":keys":
+					key = &Keyword{Value: ":" + name.Value}
+				case ":strs":
+					key = &String{Value: name.Value}
+				default:
+					key = quoted(&Symbol{Value: name.Value})
+				}
+				bindings = append(bindings, name, get(name, key))
+			}
+		case isPattern(e.Key):
+			bindings, err = d.bind(bindings, e.Key, d.call(destructureGet, hmap, e.Value))
+		default:
+			bindings, err = d.bind(bindings, e.Key, get(e.Key, e.Value))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bindings, nil
+}
+
+//call returns a form that calls fn with args. Errors of the call are reported at the binding form
+func (d *destructurer) call(fn *Function, args ...Type) *List {
+	call := NewList(false, append([]Type{fn}, args...)...)
+	call.Pos = d.pos
+	return call
+}
+
+func quoted(form Type) *List {
+	return NewList(false, &Symbol{Value: "quote"}, form)
+}
+
+func nthItem(items []Type, i int) Type {
+	if i < len(items) {
+		return items[i]
+	}
+	return nil
+}
+
+//generateSymbol returns a new symbol that can't be read, for the analyzer to bind values to
+func (in *Interpreter) generateSymbol() *Symbol {
+	in.generated++
+	return &Symbol{Value: fmt.Sprintf("generated %d", in.generated)}
+}
+
+//destructureSeq checks that its first argument can be destructured with the sequential pattern that is its second
+var destructureSeq = &Function{Name: "destructure-seq", Fn: func(args ...Type) (Type, error) {
+	switch args[0].(type) {
+	case *List, *LazySeq, *String, *Nil:
+		return args[0], nil
+	}
+	return nil, fmt.Errorf("%s: expected a sequence to destructure, got %s", PrString(args[1], true), typeName(args[0]))
+}}
+
+//destructureNth returns the element of a sequence at an index, or nil if the sequence is shorter
+var destructureNth = &Function{Name: "destructure-nth", Fn: func(args ...Type) (Type, error) {
+	i, _ := args[1].(*Number).Int64()
+	if lst, ok := args[0].(*List); ok {
+		if i < int64(lst.Len()) {
+			return lst.Nth(int(i)), nil
+		}
+		return &Nil{}, nil
+	}
+	seq, err := seqOf("destructuring", args[0])
+	for ; err == nil; i-- {
+		var first Type
+		var ok bool
+		if first, seq, ok, err = uncons(seq); err != nil || !ok {
+			break
+		}
+		if i == 0 {
+			return first, nil
+		}
+	}
+	return &Nil{}, err
+}}
+
+//destructureRest returns the elements of a sequence after the first n. It is lazy if the sequence is
+var destructureRest = &Function{Name: "destructure-rest", Fn: func(args ...Type) (Type, error) {
+	n, _ := args[1].(*Number).Int64()
+	switch seq := args[0].(type) {
+	case *List:
+		if n >= int64(seq.Len()) {
+			return NewList(false), nil
+		}
+		return NewList(false, seq.Slice()[n:]...), nil
+	case *LazySeq:
+		return lazyDrop(n, seq), nil
+	}
+	seq, err := seqOf("destructuring", args[0])
+	if err != nil {
+		return nil, err
+	}
+	if lst, ok := seq.(*List); ok && n < int64(lst.Len()) {
+		return NewList(false, lst.Slice()[n:]...), nil
+	}
+	return NewList(false), nil
+}}
+
+//destructureMap checks that its first argument can be destructured with the associative pattern that is its second.
+//Lists of keys and values, like the rest of the arguments of a function, are turned into maps
+var destructureMap = &Function{Name: "destructure-map", Fn: func(args ...Type) (Type, error) {
+	var items []Type
+	switch v := args[0].(type) {
+	case *HashMap, *Nil:
+		return args[0], nil
+	case *List:
+		if v.IsVector {
+			return nil, fmt.Errorf("%s: expected a map to destructure, got %s", PrString(args[1], true), typeName(args[0]))
+		}
+		items = v.Slice()
+	case *LazySeq:
+		var err error
+		if items, err = v.Slice(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%s: expected a map to destructure, got %s", PrString(args[1], true), typeName(args[0]))
+	}
+	if len(items)%2 != 0 {
+		return nil, fmt.Errorf("%s: expected a map or a sequence of keys and values to destructure, got %d elements", PrString(args[1], true), len(items))
+	}
+	hmap := NewHashMap()
+	for i := 0; i < len(items); i += 2 {
+		hmap.Set(items[i], items[i+1])
+	}
+	return &hmap, nil
+}}
+
+//destructureGet returns the value of a key of a map, or nil if the map doesn't have the key
+var destructureGet = &Function{Name: "destructure-get", Fn: func(args ...Type) (Type, error) {
+	if hmap, ok := args[0].(*HashMap); ok {
+		if val, ok := hmap.Get(args[1]); ok {
+			return val, nil
+		}
+	}
+	return &Nil{}, nil
+}}
+
+//destructureHas tells whether a map has a key, so that the default value of a symbol is only evaluated if it hasn't
+var destructureHas = &Function{Name: "destructure-has", Fn: func(args ...Type) (Type, error) {
+	if hmap, ok := args[0].(*HashMap); ok {
+		_, ok := hmap.Get(args[1])
+		return &Boolean{Value: ok}, nil
+	}
+	return &Boolean{Value: false}, nil
+}}
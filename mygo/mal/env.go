@@ -1,12 +1,20 @@
 package mal
 
+import (
+	"fmt"
+)
+
 //Env contains a lisp environment, and a pointer to the outer environment, if any
 type Env struct {
 	outer *Env
 	data  map[string]Type
+	// the namespace whose variables the environment holds, if any, see namespace.go
+	ns *namespace
 }
 
-//NewEnv creates a new lisp environment, taking a pointer to an outer environment, or nil, if none
+//NewEnv creates a new lisp environment, taking a pointer to an outer environment, or nil, if none, and binding
+//the parameters in binds to exprs. The parameters must have been checked by CheckParams, and NewFnEnv checks the
+//number of exprs: parameters without a value are left unbound
 func NewEnv(outer *Env, binds []Type, exprs []Type) *Env {
 	env := Env{outer: outer, data: make(map[string]Type)}
 	if binds != nil && exprs != nil {
@@ -14,20 +22,54 @@ func NewEnv(outer *Env, binds []Type, exprs []Type) *Env {
 			if val, ok := binds[i].(*Symbol); ok {
 				//variadic functions
 				if val.Value == "&" {
-					symbol := binds[i+1].(*Symbol)
-					l := NewList(false)
-					l.Value = exprs[i:]
-					env.Set(symbol, &l)
+					symbol, ok := binds[len(binds)-1].(*Symbol)
+					if !ok || i != len(binds)-2 {
+						break
+					}
+					rest := NewList(false)
+					if i < len(exprs) {
+						rest = NewList(false, exprs[i:]...)
+					}
+					env.Set(symbol, rest)
 					break
 				}
 				//regular
-				env.Set(val, exprs[i])
+				if i < len(exprs) {
+					env.Set(val, exprs[i])
+				}
 			}
 		}
 	}
 	return &env
 }
 
+//CheckParams checks the parameters of a fn*: symbols, of which & must be followed by exactly one more
+func CheckParams(binds []Type) error {
+	for i, bind := range binds {
+		symbol, ok := bind.(*Symbol)
+		if !ok {
+			return fmt.Errorf("fn*: parameters must be symbols, got %s", typeName(bind))
+		}
+		if symbol.Value == "&" && i != len(binds)-2 {
+			return fmt.Errorf("fn*: & must be followed by exactly one parameter")
+		}
+	}
+	return nil
+}
+
+//NewFnEnv creates the environment of a call with the arguments exprs to a function whose parameters, checked by
+//CheckParams, are binds, or fails if the function doesn't take that many arguments
+func NewFnEnv(outer *Env, binds []Type, exprs []Type) (*Env, error) {
+	params, variadic := len(binds), false
+	if params >= 2 && binds[params-2].(*Symbol).Value == "&" {
+		params, variadic = params-2, true
+	}
+	if len(exprs) < params || !variadic && len(exprs) > params {
+		return nil, arityError(len(exprs), "")
+	}
+	return NewEnv(outer, binds, exprs), nil
+}
+
 //Set sets a value in the environment
 func (env *Env) Set(symbol *Symbol, value Type) {
 	env.data[symbol.Value] = value
@@ -44,10 +86,16 @@ func (env *Env) Find(symbol *Symbol) *Env {
 	return env.outer.Find(symbol)
 }
 
-//Get obtains the value for a given symbol in an environment, recursing up all its parents if neccessary
+//Get obtains the value for a given symbol in an environment, recursing up all its parents if neccessary.
+//A qualified symbol, e.g. q/x, is looked up in the namespace it refers to from the namespace of the environment
 func (env *Env) Get(symbol *Symbol) Type {
 	e := env.Find(symbol)
 	if e == nil {
+		for e = env; e != nil; e = e.outer {
+			if e.ns != nil {
+				return e.ns.getQualified(symbol.Value)
+			}
+		}
 		return nil
 	}
 	if val, ok := e.data[symbol.Value]; ok {
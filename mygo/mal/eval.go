@@ -0,0 +1,184 @@
+package mal
+
+// Helpers of the evaluator, which is made up of the analyzer (analyze.go) and the compiler (compile.go)
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+func isMacroCall(ast Type, env *Env) bool {
+	astLst, isList := ast.(*List)
+	if !isList || astLst.Len() == 0 {
+		return false
+	}
+	symbol, hasSymbolFirst := astLst.Nth(0).(*Symbol)
+	if !hasSymbolFirst {
+		return false
+	}
+	if fn, ok := env.Get(symbol).(*Function); ok {
+		return fn.IsMacro
+	}
+	return false
+}
+
+func macroExpand(ast Type, env *Env) (Type, error) {
+	for {
+		expanded, ok, err := macroExpand1(ast, env)
+		if err != nil || !ok {
+			return expanded, err
+		}
+		ast = expanded
+	}
+}
+
+//Uh yeah... I just implemented https://github.com/kanaka/mal/blob/master/process/guide.md#step7
+//I haven't tried understanding this function in detail yet. Symbols are quoted as syntaxQuoter.symbol returns them
+func (q *syntaxQuoter) quasiquote(ast Type) Type {
+	if !isPair(ast) {
+		if symbol, ok := ast.(*Symbol); ok {
+			ast = q.symbol(symbol)
+		}
+		return NewList(false, &Symbol{Value: "quote"}, ast)
+	}
+	astLst, _ := ast.(*List)
+	if symbol, ok := astLst.Nth(0).(*Symbol); ok && symbol.Value == "unquote" {
+		return astLst.Nth(1)
+	}
+
+	if isPair(astLst.Nth(0)) {
+		if l2, ok := astLst.Nth(0).(*List); ok && isPair(l2) {
+			if symb, ok := l2.Nth(0).(*Symbol); ok && symb.Value == "splice-unquote" {
+				return NewList(false, &Symbol{Value: "concat"}, l2.Nth(1), q.quasiquote(astLst.Rest()))
+			}
+		}
+	}
+
+	return NewList(false, &Symbol{Value: "cons"}, q.quasiquote(astLst.First()), q.quasiquote(astLst.Rest()))
+}
+
+func isPair(ast Type) bool {
+	if lst, ok := ast.(*List); ok {
+		if lst.Len() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//maxEvalDepth is the variable holding the maximum number of nested calls of mal functions
+var maxEvalDepth = &Symbol{Value: "*max-eval-depth*"}
+
+//updateMaxDepth takes the maximum number of nested calls from *max-eval-depth*. A value that isn't a positive
+//integer below the depth limit means the limit: MaxEvalDepthLimit, so that the calls never exhaust the stack, or
+//the initial value in a sandbox, see depthLimit
+func (in *Interpreter) updateMaxDepth() {
+	in.maxDepth = in.depthLimit
+	if n, ok := in.ns.env.Get(maxEvalDepth).(*Number); ok {
+		if i, ok := n.Int64(); ok && i > 0 && i < int64(in.depthLimit) {
+			in.maxDepth = int(i)
+		}
+	}
+}
+
+//step counts a step of the evaluation, i.e. a call of a mal function or an iteration of a loop. It returns an
+//error if the evaluation has to stop: because it exceeded its maximum number of steps, or because its context
+//is done, e.g. because the user interrupted it or it timed out. It runs very often, and must be cheap
+func (in *Interpreter) step() error {
+	in.steps++
+	if in.steps > in.maxSteps {
+		return stepsError(in.maxSteps)
+	}
+	select {
+	case <-in.done:
+		return &stopError{msg: "Evaluation interrupted: " + in.ctx.Err().Error(), ctxErr: in.ctx.Err()}
+	default:
+		return nil
+	}
+}
+
+//stopError is the error of an evaluation that has to stop before it is done: because its context is done, or
+//because it exceeded its maximum number of steps or of nested calls. A lazy sequence whose realization fails with
+//it isn't realized, so that a later evaluation can try again. catch* catches the latter, but not the error of a
+//context that is done, which would keep the evaluation running, see catchExpr.catches
+type stopError struct {
+	msg    string
+	ctxErr error // the error of the context, if it is done
+}
+
+func (err *stopError) Error() string {
+	return err.msg
+}
+
+func (err *stopError) Unwrap() error {
+	return err.ctxErr
+}
+
+//isStop tells whether err is a stopError, and whether it is the one of a context that is done
+func isStop(err error) (stop bool, done bool) {
+	var stopErr *stopError
+	if !errors.As(err, &stopErr) {
+		return false, false
+	}
+	return true, stopErr.ctxErr != nil
+}
+
+//nest counts a level of recursion in Go that isn't a call of a mal function, like realizing a lazy sequence
+//computed from another one, against *max-eval-depth*. The depth must be decremented again once it returns. The
+//frames of the VM, but the one of the top level form, count as well, see machine.tooDeep
+func (in *Interpreter) nest() error {
+	depth := in.depth
+	if in.vm != nil && len(in.vm.frames) > 0 {
+		depth += len(in.vm.frames) - 1
+	}
+	if depth >= in.maxDepth {
+		return depthError(in.maxDepth)
+	}
+	in.depth++
+	return nil
+}
+
+//depthError is the error of a call of a mal function beyond the maximum number of nested calls
+func depthError(maxDepth int) error {
+	return &stopError{msg: fmt.Sprintf("Maximum evaluation depth of %d exceeded, see *max-eval-depth*", maxDepth)}
+}
+
+//loadFile reads and evaluates the forms in a file one after another
+func (in *Interpreter) loadFile(filename string) (Type, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return in.load(f, filename)
+}
+
+//load reads and evaluates the forms of the file called filename from r one after another
+func (in *Interpreter) load(r io.Reader, filename string) (Type, error) {
+	// a file that switches to another namespace doesn't switch the one of the code that loads it
+	defer in.setNamespace(in.ns)
+	reader := NewReader(r, filename)
+	for {
+		ast, err := reader.ReadForm()
+		if err == io.EOF {
+			return &Nil{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := in.eval(ast); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//errorValue returns the value catch* binds for err: the value thrown by throw, or the error message
+func errorValue(err error) Type {
+	var malErr *Error
+	if errors.As(err, &malErr) {
+		return malErr.Value
+	}
+	return &String{Value: StripPosition(err).Error()}
+}
@@ -0,0 +1,36 @@
+package mal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//backends are the options of interpreters using each of the evaluators
+var backends = map[string]Options{"closures": {}, "vm": {VM: true}}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"(def! x 1)\n(let* [y 2]\n  (+ y (f 3)))", "3:9: 'f' not found"},
+		{"(do\n  (nth [] 3))", "2:3: nth: Index out of range"},
+		{"(def! g (fn* [a]\n  (if a\n    (throw \"boom\")\n    1)))\n(g true)", "3:5: boom"},
+		{"(let* [v [1\n         (nth (list 1) 1)]]\n  v)", "2:10: nth: Index out of range"},
+	}
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.mal")
+	for name, opts := range backends {
+		for _, test := range tests {
+			if err := os.WriteFile(filename, []byte(test.src), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := New(opts).LoadFile(context.Background(), filename)
+			if want := filename + ":" + test.want; err == nil || err.Error() != want {
+				t.Errorf("%s: loading %q: got error %v, want %s", name, test.src, err, want)
+			}
+		}
+	}
+}
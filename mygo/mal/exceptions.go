@@ -0,0 +1,235 @@
+package mal
+
+// Exceptions. (try* body... clauses...) runs body, and if that fails the first of its (catch* e handler...) clauses
+// that catches the error, with e bound to the value thrown, or to the message of an error of the interpreter. A
+// catch* clause may name the kind of errors it catches before e: :host for errors of the interpreter and of the native
+// functions, :mal for the values thrown by throw, :default for any error, like a clause without a kind, or any other
+// keyword for the thrown ex-infos and maps whose data has it as :type. A (finally forms...) clause, the last one, runs
+// after body and the handler, whether they fail or not, and its value is ignored. No clause catches the error of an
+// evaluation that was interrupted or timed out, which has to stop, but finally still runs.
+//
+// (ex-stack e) returns the calls the error caught as e propagated out of, as a vector of maps with the :name of the
+// function called and the :file, :line and :column of the call, if known, innermost first, leaving out throw and the
+// internal functions destructuring calls. The value catch* binds holds its stack, wherever it is passed, and
+// ex-stack returns nil for values that weren't caught, and for nil and booleans, which can't hold one.
+//
+// ex-info creates an exception with a message, a map of data and, optionally, the exception that caused it
+
+import (
+	"errors"
+)
+
+//catches tells whether the clause catches err
+func (c *catchExpr) catches(err error) bool {
+	if _, done := isStop(err); done {
+		return false
+	}
+	if c.kind == nil {
+		return true
+	}
+	var malErr *Error
+	thrown := errors.As(err, &malErr) && malErr.thrown
+	switch c.kind.Value {
+	case ":default":
+		return true
+	case ":host":
+		return !thrown
+	case ":mal":
+		return thrown
+	}
+	if !thrown {
+		return false
+	}
+	kind, ok := exType(malErr.Value).(*Keyword)
+	return ok && kind.Value == c.kind.Value
+}
+
+//catching returns the first of the clauses that catches err, or -1 if none does
+func catching(clauses []*catchExpr, err error) int {
+	for i, c := range clauses {
+		if c.catches(err) {
+			return i
+		}
+	}
+	return -1
+}
+
+//exType returns the :type of the data of an ex-info or of a map, or nil if there is none
+func exType(value Type) Type {
+	if info, ok := value.(*ExInfo); ok {
+		value = info.Data
+	}
+	if hmap, ok := value.(*HashMap); ok {
+		kind, _ := hmap.Get(&Keyword{Value: ":type"})
+		return kind
+	}
+	return nil
+}
+
+//errorMessage returns the message of an error whose value is value: the string itself, the message of an ExInfo
+//followed by its data and the message of its cause, or the printed value otherwise
+func errorMessage(value Type) string {
+	switch v := value.(type) {
+	case *String:
+		return v.Value
+	case *ExInfo:
+		msg := v.Message
+		if data, _ := v.Data.(*HashMap); data == nil || data.Len() > 0 {
+			msg += " " + PrString(v.Data, true)
+		}
+		if v.Cause != nil {
+			msg += ", caused by: " + errorMessage(v.Cause)
+		}
+		return msg
+	}
+	return PrString(value, true)
+}
+
+//exInfo is (ex-info message data cause?)
+func exInfo(args ...Type) (Type, error) {
+	info := &ExInfo{Message: args[0].(*String).Value, Data: args[1]}
+	if len(args) > 2 {
+		if _, isNil := args[2].(*Nil); !isNil {
+			info.Cause = args[2]
+		}
+	}
+	return info, nil
+}
+
+//exMessage returns the message of an ex-info, or a string itself, like the message catch* binds for an error of
+//the interpreter, and nil for other values
+func exMessage(args ...Type) (Type, error) {
+	switch v := args[0].(type) {
+	case *ExInfo:
+		return &String{Value: v.Message}, nil
+	case *String:
+		return v, nil
+	}
+	return &Nil{}, nil
+}
+
+//exData returns the data of an ex-info, or nil for other values
+func exData(args ...Type) (Type, error) {
+	if info, ok := args[0].(*ExInfo); ok {
+		return info.Data, nil
+	}
+	return &Nil{}, nil
+}
+
+//caughtStack is the stack of the error a value was caught from, which catch* keeps with the value it binds
+type caughtStack struct {
+	frames []StackFrame
+}
+
+//internalCalls are the native functions left out of the stacks of caught values: throw, where the error is raised
+//rather than a call it propagated out of, and the ones destructuring is compiled to, see destructure.go
+var internalCalls = map[string]bool{
+	"throw":              true,
+	destructureSeq.Name:  true,
+	destructureNth.Name:  true,
+	destructureRest.Name: true,
+	destructureMap.Name:  true,
+	destructureGet.Name:  true,
+	destructureHas.Name:  true,
+}
+
+//caught returns the value catch* binds for err, with the stack of err. Values compared by value are copied, so
+//that the stack stays with the one caught wherever it is passed, while an ex-info, which is only equal to itself,
+//keeps the stack of the latest catch*. nil, booleans and the other values that can be thrown have no stack
+func caught(err error) Type {
+	value := errorValue(err)
+	stack := &caughtStack{}
+	for _, frame := range StackOf(err) {
+		if !internalCalls[frame.Name] {
+			stack.frames = append(stack.frames, frame)
+		}
+	}
+	switch v := value.(type) {
+	case *ExInfo:
+		v.stack = stack
+	case *String:
+		c := *v
+		c.stack = stack
+		return &c
+	case *Number:
+		c := *v
+		c.stack = stack
+		return &c
+	case *Keyword:
+		c := *v
+		c.stack = stack
+		return &c
+	case *Symbol:
+		c := *v
+		c.stack = stack
+		return &c
+	case *List:
+		c := *v
+		c.stack = stack
+		return &c
+	case *HashMap:
+		c := *v
+		c.stack = stack
+		return &c
+	case *Set:
+		c := *v
+		c.stack = stack
+		return &c
+	}
+	return value
+}
+
+//exStack is (ex-stack e): the stack of the error e was caught from, or nil if e wasn't caught by catch*
+func exStack(args ...Type) (Type, error) {
+	var stack *caughtStack
+	switch v := args[0].(type) {
+	case *ExInfo:
+		stack = v.stack
+	case *String:
+		stack = v.stack
+	case *Number:
+		stack = v.stack
+	case *Keyword:
+		stack = v.stack
+	case *Symbol:
+		stack = v.stack
+	case *List:
+		stack = v.stack
+	case *HashMap:
+		stack = v.stack
+	case *Set:
+		stack = v.stack
+	}
+	if stack == nil {
+		return &Nil{}, nil
+	}
+	return stackValue(stack.frames), nil
+}
+
+//stackValue returns the mal value of a stack, see ex-stack
+func stackValue(stack []StackFrame) Type {
+	frames := make([]Type, len(stack))
+	for i, frame := range stack {
+		hmap := NewHashMap()
+		if frame.Name == "" {
+			hmap.Set(&Keyword{Value: ":name"}, &Nil{})
+		} else {
+			hmap.Set(&Keyword{Value: ":name"}, &String{Value: frame.Name})
+		}
+		if frame.Pos != nil {
+			hmap.Set(&Keyword{Value: ":file"}, &String{Value: frame.Pos.File})
+			hmap.Set(&Keyword{Value: ":line"}, NewInt(int64(frame.Pos.Line)))
+			hmap.Set(&Keyword{Value: ":column"}, NewInt(int64(frame.Pos.Col)))
+		}
+		frames[i] = &hmap
+	}
+	return NewList(true, frames...)
+}
+
+//exCause returns the cause of an ex-info, or nil if it has none or for other values
+func exCause(args ...Type) (Type, error) {
+	if info, ok := args[0].(*ExInfo); ok && info.Cause != nil {
+		return info.Cause, nil
+	}
+	return &Nil{}, nil
+}
@@ -0,0 +1,101 @@
+package mal
+
+import (
+	"hash/fnv"
+	"math"
+	"reflect"
+)
+
+//Hash returns a hash of a mal value that is consistent with =, i.e. equal values have equal hashes
+func Hash(value Type) uint64 {
+	return hashNested(value, 0)
+}
+
+//hashNested returns the hash of value, which is nested depth levels deep. The elements of collections nested
+//deeper than MaxNesting don't contribute to it
+func hashNested(value Type, depth int) uint64 {
+	switch value.(type) {
+	case *List, *LazySeq, *HashMap, *Set:
+		if depth >= MaxNesting {
+			return mix(4)
+		}
+	}
+	switch v := value.(type) {
+	case *Nil:
+		return 0x9e3779b97f4a7c15
+	case *Boolean:
+		if v.Value {
+			return mix(1)
+		}
+		return mix(2)
+	case *Number:
+		switch v.Kind {
+		case BigIntNumber:
+			return hashBytes(byte('n'), v.Big.Bytes()) ^ uint64(v.Big.Sign())
+		case RatioNumber:
+			return mix(hashBytes(byte('n'), v.Rat.Num().Bytes())^uint64(v.Rat.Sign())) + hashBytes(byte('d'), v.Rat.Denom().Bytes())
+		case FloatNumber:
+			if v.Float == 0 { // 0.0 and -0.0 are equal
+				return mix(3)
+			}
+			return mix(math.Float64bits(v.Float))
+		default:
+			return mix(uint64(v.Int))
+		}
+	case *String:
+		return hashBytes('s', []byte(v.Value))
+	case *Keyword:
+		return hashBytes('k', []byte(v.Value))
+	case *Symbol:
+		return hashBytes('y', []byte(v.Value))
+	case *List:
+		// lists and vectors with the same elements are equal, so IsVector is ignored
+		h := uint64(17)
+		for _, el := range v.Slice() {
+			h = h*31 + hashNested(el, depth+1)
+		}
+		return mix(h)
+	case *LazySeq:
+		// lazy sequences are equal to lists with the same elements, so they have to be hashed like them
+		h := uint64(17)
+		for _, el := range lazyElements(v) {
+			h = h*31 + hashNested(el, depth+1)
+		}
+		return mix(h)
+	case *HashMap:
+		// the order of entries doesn't matter, so combine their hashes commutatively
+		h := uint64(19)
+		for _, e := range v.Entries() {
+			h += mix(hashNested(e.Key, depth+1)*31 + hashNested(e.Value, depth+1))
+		}
+		return mix(h)
+	case *Set:
+		h := uint64(23)
+		for _, el := range v.Slice() {
+			h += hashNested(el, depth+1)
+		}
+		return mix(h)
+	case *Regex:
+		return hashBytes('r', []byte(v.Value.String()))
+	default:
+		// functions, atoms etc. are only equal to themselves
+		return mix(uint64(reflect.ValueOf(value).Pointer()))
+	}
+}
+
+func hashBytes(tag byte, b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte{tag})
+	h.Write(b)
+	return h.Sum64()
+}
+
+//mix scrambles the bits of h, so that similar values don't end up with similar hashes
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,320 @@
+package mal
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Hash maps are immutable, like lists. Small maps keep their entries in a slice, in the order the keys were
+// added, which is copied when the map is modified. Once a map grows beyond smallMapSize entries it is turned
+// into a hash array mapped trie (HAMT), see https://en.wikipedia.org/wiki/Hash_array_mapped_trie, where
+// modifications only copy the path from the root to the changed entry
+
+//smallMapSize is the maximum number of entries of a map that is stored in a slice
+const smallMapSize = 8
+
+//MapEntry is a key and its value in a HashMap
+type MapEntry struct {
+	Key   Type
+	Value Type
+}
+
+//hamtNode is a node of the trie. Each level of the trie consumes 5 bits of the hash of a key, and bitmap tells
+//which of the 32 possible children are present. A child is either an entry or a node of the next level. Children
+//are immutable and shared by the copies of a node, so that copying one only copies pointers
+type hamtNode struct {
+	bitmap   uint32
+	children []*hamtChild
+	// keys whose hashes are identical end up in a node below the last level, which holds them in a slice
+	collisions []MapEntry
+}
+
+type hamtChild struct {
+	hash  uint64
+	entry MapEntry
+	node  *hamtNode
+}
+
+const hamtBits = 5
+
+//Get returns the value for key, and whether the map contains it
+func (hmap *HashMap) Get(key Type) (Type, bool) {
+	e, ok, _ := hmap.find(key, 0)
+	return e.Value, ok
+}
+
+//find returns the entry for key, which is nested depth levels deep in a value being compared, and whether the map
+//contains it. Comparing keys that are nested too deep fails, see equalNested
+func (hmap *HashMap) find(key Type, depth int) (MapEntry, bool, error) {
+	if hmap.root == nil {
+		for _, e := range hmap.entries {
+			if eq, err := equalNested(e.Key, key, depth); err != nil || eq {
+				return e, eq, err
+			}
+		}
+		return MapEntry{}, false, nil
+	}
+	return hmap.root.get(0, Hash(key), key, depth)
+}
+
+//mapLiteral returns the map of a map literal whose keys and values are forms. Keys that are equal as forms are
+//merged in the map, e.g. the two (f) in {(f) 1 (f) 2}, but the literal keeps all of them, in order, for the
+//analyzer to evaluate each one
+func mapLiteral(forms []Type) *HashMap {
+	hmap := NewHashMap()
+	for i := 0; i+1 < len(forms); i += 2 {
+		hmap.Set(forms[i], forms[i+1])
+	}
+	hmap.literal = forms
+	return &hmap
+}
+
+//forms returns the keys and values of a map literal one after another, or those of the entries of another map
+func (hmap *HashMap) forms() []Type {
+	if hmap.literal != nil {
+		return hmap.literal
+	}
+	var forms []Type
+	for _, e := range hmap.Entries() {
+		forms = append(forms, e.Key, e.Value)
+	}
+	return forms
+}
+
+//duplicateKeyError is the error of a map literal with two keys that are equal
+func duplicateKeyError(key Type) error {
+	return fmt.Errorf("duplicate key in hash map literal: %s", PrString(key, true))
+}
+
+//Assoc returns a copy of the map with key set to value
+func (hmap *HashMap) Assoc(key Type, value Type) *HashMap {
+	newMap := *hmap
+	newMap.literal = nil
+	if hmap.root == nil {
+		for i, e := range hmap.entries {
+			if equals(e.Key, key) {
+				newMap.entries = make([]MapEntry, len(hmap.entries))
+				copy(newMap.entries, hmap.entries)
+				newMap.entries[i].Value = value
+				return &newMap
+			}
+		}
+		if len(hmap.entries) < smallMapSize {
+			newMap.entries = make([]MapEntry, len(hmap.entries), len(hmap.entries)+1)
+			copy(newMap.entries, hmap.entries)
+			newMap.entries = append(newMap.entries, MapEntry{Key: key, Value: value})
+			return &newMap
+		}
+		// too big for a slice
+		newMap.entries = nil
+		newMap.count = len(hmap.entries)
+		newMap.root = &hamtNode{}
+		for _, e := range hmap.entries {
+			newMap.root, _ = newMap.root.assoc(0, Hash(e.Key), e)
+		}
+	}
+	var added bool
+	newMap.root, added = newMap.root.assoc(0, Hash(key), MapEntry{Key: key, Value: value})
+	if added {
+		newMap.count++
+	}
+	return &newMap
+}
+
+//Dissoc returns a copy of the map without key
+func (hmap *HashMap) Dissoc(key Type) *HashMap {
+	newMap := *hmap
+	newMap.literal = nil
+	if hmap.root == nil {
+		for i, e := range hmap.entries {
+			if equals(e.Key, key) {
+				newMap.entries = make([]MapEntry, 0, len(hmap.entries)-1)
+				newMap.entries = append(newMap.entries, hmap.entries[:i]...)
+				newMap.entries = append(newMap.entries, hmap.entries[i+1:]...)
+				return &newMap
+			}
+		}
+		return hmap
+	}
+	var removed bool
+	newMap.root, removed = hmap.root.dissoc(0, Hash(key), key)
+	if !removed {
+		return hmap
+	}
+	newMap.count--
+	return &newMap
+}
+
+//Set sets the value for key. This modifies the map, so it may only be used while building a new map
+func (hmap *HashMap) Set(key Type, value Type) {
+	*hmap = *hmap.Assoc(key, value)
+}
+
+//Copy returns a copy of the map. Since maps are immutable this doesn't copy any entries
+func (hmap *HashMap) Copy() HashMap {
+	return *hmap
+}
+
+//Len returns the number of entries in the map
+func (hmap *HashMap) Len() int {
+	if hmap.root == nil {
+		return len(hmap.entries)
+	}
+	return hmap.count
+}
+
+//Entries returns the entries of the map. The entries of small maps are in the order their keys were first added,
+//those of bigger maps are in no particular order. The result must not be modified
+func (hmap *HashMap) Entries() []MapEntry {
+	if hmap.root == nil {
+		return hmap.entries
+	}
+	entries := make([]MapEntry, 0, hmap.count)
+	return hmap.root.appendEntries(entries)
+}
+
+//childIndex returns the bit of the child for hash at the level given by shift, and its index in children
+func (node *hamtNode) childIndex(shift uint, hash uint64) (uint32, int) {
+	bit := uint32(1) << ((hash >> shift) & (1<<hamtBits - 1))
+	return bit, bits.OnesCount32(node.bitmap & (bit - 1))
+}
+
+func (node *hamtNode) get(shift uint, hash uint64, key Type, depth int) (MapEntry, bool, error) {
+	for {
+		if shift >= 64 {
+			for _, e := range node.collisions {
+				if eq, err := equalNested(e.Key, key, depth); err != nil || eq {
+					return e, eq, err
+				}
+			}
+			return MapEntry{}, false, nil
+		}
+		bit, i := node.childIndex(shift, hash)
+		if node.bitmap&bit == 0 {
+			return MapEntry{}, false, nil
+		}
+		child := node.children[i]
+		if child.node == nil {
+			if child.hash != hash {
+				return MapEntry{}, false, nil
+			}
+			eq, err := equalNested(child.entry.Key, key, depth)
+			return child.entry, eq, err
+		}
+		node = child.node
+		shift += hamtBits
+	}
+}
+
+//assoc returns a copy of the node with entry added or replaced, and whether it was added
+func (node *hamtNode) assoc(shift uint, hash uint64, entry MapEntry) (*hamtNode, bool) {
+	if shift >= 64 {
+		collisions := make([]MapEntry, len(node.collisions), len(node.collisions)+1)
+		copy(collisions, node.collisions)
+		for i, e := range collisions {
+			if equals(e.Key, entry.Key) {
+				collisions[i] = entry
+				return &hamtNode{collisions: collisions}, false
+			}
+		}
+		return &hamtNode{collisions: append(collisions, entry)}, true
+	}
+	bit, i := node.childIndex(shift, hash)
+	if node.bitmap&bit == 0 {
+		children := make([]*hamtChild, len(node.children)+1)
+		copy(children, node.children[:i])
+		children[i] = &hamtChild{hash: hash, entry: entry}
+		copy(children[i+1:], node.children[i:])
+		return &hamtNode{bitmap: node.bitmap | bit, children: children}, true
+	}
+
+	child := *node.children[i]
+	added := true
+	switch {
+	case child.node != nil:
+		child.node, added = child.node.assoc(shift+hamtBits, hash, entry)
+	case child.hash == hash && equals(child.entry.Key, entry.Key):
+		child.entry = entry
+		added = false
+	default:
+		// two different keys in the same place, move both of them to a new node on the next level
+		sub, _ := (&hamtNode{}).assoc(shift+hamtBits, child.hash, child.entry)
+		sub, _ = sub.assoc(shift+hamtBits, hash, entry)
+		child = hamtChild{node: sub}
+	}
+	return node.with(i, &child), added
+}
+
+//dissoc returns a copy of the node without key, and whether the key was removed
+func (node *hamtNode) dissoc(shift uint, hash uint64, key Type) (*hamtNode, bool) {
+	if shift >= 64 {
+		for i, e := range node.collisions {
+			if equals(e.Key, key) {
+				collisions := make([]MapEntry, 0, len(node.collisions)-1)
+				collisions = append(collisions, node.collisions[:i]...)
+				return &hamtNode{collisions: append(collisions, node.collisions[i+1:]...)}, true
+			}
+		}
+		return node, false
+	}
+	bit, i := node.childIndex(shift, hash)
+	if node.bitmap&bit == 0 {
+		return node, false
+	}
+
+	child := node.children[i]
+	if child.node != nil {
+		sub, removed := child.node.dissoc(shift+hamtBits, hash, key)
+		if !removed {
+			return node, false
+		}
+		switch {
+		case sub.isEmpty():
+			return node.without(bit, i), true
+		case len(sub.children) == 1 && sub.children[0].node == nil:
+			// a single entry doesn't need a node of its own
+			child = sub.children[0]
+		case len(sub.collisions) == 1:
+			child = &hamtChild{hash: hash, entry: sub.collisions[0]}
+		default:
+			child = &hamtChild{node: sub}
+		}
+		return node.with(i, child), true
+	}
+	if child.hash == hash && equals(child.entry.Key, key) {
+		return node.without(bit, i), true
+	}
+	return node, false
+}
+
+//with returns a copy of the node with child at index i
+func (node *hamtNode) with(i int, child *hamtChild) *hamtNode {
+	children := make([]*hamtChild, len(node.children))
+	copy(children, node.children)
+	children[i] = child
+	return &hamtNode{bitmap: node.bitmap, children: children}
+}
+
+//without returns a copy of the node without the child at index i
+func (node *hamtNode) without(bit uint32, i int) *hamtNode {
+	children := make([]*hamtChild, 0, len(node.children)-1)
+	children = append(children, node.children[:i]...)
+	children = append(children, node.children[i+1:]...)
+	return &hamtNode{bitmap: node.bitmap &^ bit, children: children}
+}
+
+func (node *hamtNode) isEmpty() bool {
+	return len(node.children) == 0 && len(node.collisions) == 0
+}
+
+func (node *hamtNode) appendEntries(entries []MapEntry) []MapEntry {
+	entries = append(entries, node.collisions...)
+	for _, child := range node.children {
+		if child.node != nil {
+			entries = child.node.appendEntries(entries)
+		} else {
+			entries = append(entries, child.entry)
+		}
+	}
+	return entries
+}
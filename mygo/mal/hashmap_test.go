@@ -0,0 +1,152 @@
+package mal
+
+import (
+	"fmt"
+	"testing"
+)
+
+//mapSizes are the numbers of entries of the maps the benchmarks work on
+var mapSizes = []int{10, 1000, 100000}
+
+func intKeys(n int) []Type {
+	keys := make([]Type, n)
+	for i := range keys {
+		keys[i] = NewInt(int64(i))
+	}
+	return keys
+}
+
+func mapOf(keys []Type) *HashMap {
+	hmap := NewHashMap()
+	m := &hmap
+	for _, k := range keys {
+		m = m.Assoc(k, k)
+	}
+	return m
+}
+
+func TestHashMapPersistence(t *testing.T) {
+	keys := intKeys(5000)
+	full := mapOf(keys)
+	if full.Len() != len(keys) {
+		t.Fatalf("got %d entries, want %d", full.Len(), len(keys))
+	}
+	m := full
+	for i, k := range keys {
+		if i%2 == 0 {
+			m = m.Dissoc(k)
+		}
+	}
+	replaced := full.Assoc(keys[1], &String{Value: "one"})
+	for i, k := range keys {
+		if v, ok := full.Get(k); !ok || !equals(v, k) {
+			t.Fatalf("the full map has %v for %v after it was modified", v, k)
+		}
+		if _, ok := m.Get(k); ok != (i%2 == 1) {
+			t.Fatalf("the map without even keys has %v: %v", k, ok)
+		}
+	}
+	if m.Len() != len(keys)/2 || replaced.Len() != len(keys) {
+		t.Errorf("got %d and %d entries, want %d and %d", m.Len(), replaced.Len(), len(keys)/2, len(keys))
+	}
+	if v, _ := replaced.Get(keys[1]); PrString(v, true) != `"one"` {
+		t.Errorf("got %s for the replaced key", PrString(v, true))
+	}
+	if len(m.Entries()) != m.Len() {
+		t.Errorf("got %d entries for a map of %d", len(m.Entries()), m.Len())
+	}
+}
+
+//copyingMap is a map that copies all of its entries on every change, like hash maps did before they were
+//persistent, kept to compare them
+type copyingMap struct {
+	entries []MapEntry
+	index   map[uint64][]int
+}
+
+func (m *copyingMap) assoc(key Type, value Type) *copyingMap {
+	entries := make([]MapEntry, len(m.entries), len(m.entries)+1)
+	copy(entries, m.entries)
+	index := make(map[uint64][]int, len(m.index)+1)
+	for h, positions := range m.index {
+		index[h] = positions
+	}
+	h := Hash(key)
+	for _, i := range index[h] {
+		if equals(entries[i].Key, key) {
+			entries[i].Value = value
+			return &copyingMap{entries: entries, index: index}
+		}
+	}
+	index[h] = append(append([]int{}, index[h]...), len(entries))
+	return &copyingMap{entries: append(entries, MapEntry{Key: key, Value: value}), index: index}
+}
+
+func BenchmarkHashMapAssoc(b *testing.B) {
+	for _, n := range mapSizes {
+		keys := intKeys(n)
+		full := mapOf(keys)
+		value := &String{Value: "v"}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				full.Assoc(keys[i%n], value)
+			}
+		})
+	}
+}
+
+func BenchmarkCopyingMapAssoc(b *testing.B) {
+	for _, n := range mapSizes[:2] {
+		keys := intKeys(n)
+		full := &copyingMap{}
+		for _, k := range keys {
+			full = full.assoc(k, k)
+		}
+		value := &String{Value: "v"}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				full.assoc(keys[i%n], value)
+			}
+		})
+	}
+}
+
+//BenchmarkHashMapBuild builds a map of n entries with n assocs, keeping every map on the way
+func BenchmarkHashMapBuild(b *testing.B) {
+	for _, n := range mapSizes {
+		keys := intKeys(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mapOf(keys)
+			}
+		})
+	}
+}
+
+func BenchmarkHashMapDissoc(b *testing.B) {
+	for _, n := range mapSizes {
+		keys := intKeys(n)
+		full := mapOf(keys)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				full.Dissoc(keys[i%n])
+			}
+		})
+	}
+}
+
+func BenchmarkHashMapGet(b *testing.B) {
+	for _, n := range mapSizes {
+		keys := intKeys(n)
+		full := mapOf(keys)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				full.Get(keys[i%n])
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package mal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+//Options configures an Interpreter. Streams that are nil default to the ones of the process
+type Options struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	//VM selects the bytecode compiler and virtual machine (see bytecode.go and vm.go) instead of compiling
+	//to closures. It is experimental, and no faster than the closures
+	VM bool
+	//MaxEvalDepth is the initial value of *max-eval-depth*, DefaultMaxEvalDepth if it is 0. The depth is never
+	//more than MaxEvalDepthLimit, and with a Profile other than ProfileFull never more than MaxEvalDepth
+	MaxEvalDepth int
+	//Profile determines the native functions the interpreter has, ProfileFull if it is empty. See sandbox.go
+	Profile Profile
+	//Root is the directory ProfileReadOnlyFS can read files in, the current directory if it is empty
+	Root string
+	//MaxSteps limits the number of calls of mal functions, iterations of loops and elements of lazy sequences
+	//realized of each evaluation of EvalForm, EvalString, LoadFile or Realize. 0 means no limit
+	MaxSteps int
+	//MaxCollectionSize limits the number of elements of the collections and the length of the strings
+	//the native functions return. 0 means no limit
+	MaxCollectionSize int
+	//MaxNumberBits limits the number of bits of the integers, and of the numerators and denominators of the
+	//ratios, the native functions return. 0 means 64 bits per element MaxCollectionSize allows, or no limit if
+	//that is 0 too
+	MaxNumberBits int
+	//LibPath holds the directories load-lib and require look libraries up in, before the standard library.
+	//The current directory if it is empty. See lib.go
+	LibPath []string
+}
+
+//DefaultMaxEvalDepth is the default maximum number of nested calls of mal functions
+const DefaultMaxEvalDepth = 100000
+
+//MaxEvalDepthLimit bounds *max-eval-depth*. Deeper calls could exhaust the 1GB the Go stack can grow to, which
+//kills the process rather than failing the form
+const MaxEvalDepthLimit = 200000
+
+//MaxNesting bounds the nesting of the forms that are read, analyzed or macroexpanded and of the collections that are
+//printed, compared, hashed or realized, which recurse in Go for the same reason. Printing and hashing don't
+//descend any deeper, the others fail
+const MaxNesting = MaxEvalDepthLimit
+
+//Interpreter evaluates mal code in an environment of its own, so that several interpreters can be used in the
+//same process independently of each other. An Interpreter must not be used by several goroutines at once
+type Interpreter struct {
+	// the namespaces by name, mal.core, which holds the core functions, and the current one, see namespace.go
+	namespaces map[string]*namespace
+	core       *namespace
+	ns         *namespace
+
+	stdin  *bufio.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// the context of the evaluation, and its Done channel, see step
+	ctx  context.Context
+	done <-chan struct{}
+
+	// the pending call of a call in tail position, see tailCall
+	tailFn   *Function
+	tailArgs []Type
+	// the values of a recur, see recurred
+	recurArgs []Type
+	// the first error found while analyzing a form, see checked
+	analyzeErr error
+	// the number of forms the one being analyzed is nested in, see MaxNesting
+	nesting int
+	// the number of symbols generated by the analyzer, see generateSymbol, and by gensym
+	generated int
+	gensyms   int
+	// the number of calls of mal functions that are running, the maximum, and the maximum of the maximum, see
+	// *max-eval-depth*
+	depth      int
+	maxDepth   int
+	depthLimit int
+	// the library path, the libraries loaded, and the ones being loaded, see lib.go
+	libPath []string
+	loaded  map[string]bool
+	loading map[string]bool
+	// the restrictions of the sandbox, see Options and sandbox.go
+	profile           Profile
+	root              string
+	steps             int
+	maxSteps          int
+	maxCollectionSize int
+	maxNumberBits     int
+	// nil unless Options.VM is set
+	vm *machine
+}
+
+//New creates an interpreter whose mal.core namespace contains the core functions, eval, load-file, not, cond and
+//the functions of namespaces. It evaluates forms in the namespace user
+func New(opts Options) *Interpreter {
+	in := &Interpreter{
+		namespaces: make(map[string]*namespace),
+		libPath:    libPathOf(opts.LibPath),
+		loaded:     make(map[string]bool),
+		loading:    make(map[string]bool),
+
+		stdin:  bufio.NewReader(orReader(opts.Stdin, os.Stdin)),
+		stdout: orWriter(opts.Stdout, os.Stdout),
+		stderr: orWriter(opts.Stderr, os.Stderr),
+
+		profile:           opts.Profile,
+		root:              rootDir(opts.Profile, opts.Root),
+		maxSteps:          maxSteps(opts.MaxSteps),
+		maxCollectionSize: opts.MaxCollectionSize,
+		maxNumberBits:     maxNumberBits(opts.MaxNumberBits, opts.MaxCollectionSize),
+	}
+	if in.profile == "" {
+		in.profile = ProfileFull
+	}
+	in.setContext(context.Background())
+	if opts.VM {
+		in.vm = newMachine(in)
+	}
+	in.core = in.namespace(coreNamespace)
+	in.setNamespace(in.core)
+	for k, v := range CoreNS {
+		if in.profile.allows(k.Value) {
+			in.core.env.Set(k, in.limited(in.stepping(v)))
+		}
+	}
+
+	// the I/O functions of CoreNS use the streams and the files of the process, replace them by ones using the
+	// interpreter's
+	in.registerNative("prn", func(args ...Type) (Type, error) {
+		return printLine(in.stdout, args, true)
+	})
+	in.registerNative("println", func(args ...Type) (Type, error) {
+		return printLine(in.stdout, args, false)
+	})
+	in.registerNative("readline", func(args ...Type) (Type, error) {
+		return readLine(in.stdin, in.stdout, args)
+	})
+	in.registerNative("slurp", func(args ...Type) (Type, error) {
+		path, err := in.filePath("slurp", args[0].(*String).Value)
+		if err != nil {
+			return nil, err
+		}
+		dat, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return &String{Value: string(dat)}, nil
+	})
+
+	in.registerNative("eval", func(args ...Type) (Type, error) {
+		return in.eval(args[0])
+	})
+	in.registerNative("load-file", func(args ...Type) (Type, error) {
+		return in.loadAnyFile(args[0].(*String).Value)
+	})
+	//(load-lib name) loads the library called name from the library path, unless it was loaded before. It
+	//replaces lib/load-file-once.mal, and load-file-once is the same function
+	in.registerNative("load-lib", func(args ...Type) (Type, error) {
+		return &Nil{}, in.loadLib("load-lib", args[0].(*String).Value)
+	})
+	in.registerNative("load-file-once", func(args ...Type) (Type, error) {
+		return &Nil{}, in.loadLib("load-file-once", args[0].(*String).Value)
+	})
+	//(call-with-timeout ms f) calls f, and throws an error if it takes longer than ms milliseconds. Until then, the
+	//error stopping f can't be caught in f. See with-timeout
+	in.registerNative("call-with-timeout", func(args ...Type) (Type, error) {
+		ms, ok := args[0].(*Number).Int64()
+		if !ok || ms < 0 {
+			return nil, Errorf("call-with-timeout: Argument 1 must be a number of milliseconds, got %s", PrString(args[0], true))
+		}
+		outer := in.ctx
+		ctx, cancel := context.WithTimeout(outer, time.Duration(ms)*time.Millisecond)
+		defer cancel()
+		defer in.setContext(outer)
+		in.setContext(ctx)
+		res, err := args[1].(*Function).Fn()
+		if errors.Is(err, context.DeadlineExceeded) && outer.Err() == nil {
+			return nil, Errorf("Timeout: evaluation took longer than %d ms", ms)
+		}
+		return res, err
+	})
+	//(in-ns name) makes the namespace called name the current one, creating it if there is none
+	in.registerNative("in-ns", func(args ...Type) (Type, error) {
+		in.setNamespace(in.namespace(args[0].(*Symbol).Value))
+		return args[0], nil
+	})
+	//(require spec...) makes the namespaces of the specs available to the current one, see Interpreter.require
+	in.registerNative("require", func(args ...Type) (Type, error) {
+		for _, spec := range args {
+			if err := in.require(spec); err != nil {
+				return nil, err
+			}
+		}
+		return &Nil{}, nil
+	})
+	in.registerNative("ns-publics", func(args ...Type) (Type, error) {
+		return in.nsMap("ns-publics", args[0].(*Symbol), true)
+	})
+	in.registerNative("ns-map", func(args ...Type) (Type, error) {
+		return in.nsMap("ns-map", args[0].(*Symbol), false)
+	})
+	//(gensym) returns a new symbol, (gensym prefix) one starting with prefix
+	in.registerNative("gensym", func(args ...Type) (Type, error) {
+		prefix := "G__"
+		if len(args) > 0 {
+			prefix = args[0].(*String).Value
+		}
+		return in.gensym(prefix), nil
+	})
+	ns := native("ns", nsForm)
+	ns.IsMacro = true
+	in.Define("ns", ns)
+	in.Define("*host-language*", &String{Value: "Go"})
+	//the maximum number of nested calls of mal functions. A call beyond it throws an error rather than exhausting
+	//the stack. Changes take effect with the next form evaluated, and a value other than a positive integer
+	//below MaxEvalDepthLimit means MaxEvalDepthLimit. In a sandbox, it can't exceed the initial value
+	maxDepth := opts.MaxEvalDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxEvalDepth
+	}
+	in.depthLimit = depthLimit(in.profile, maxDepth)
+	in.Define(maxEvalDepth.Value, NewInt(int64(maxDepth)))
+	in.mustEvalString("(def! not (fn* (a) (if a false true)))")
+	in.mustEvalString("(defmacro! with-timeout (fn* (ms & body) `(call-with-timeout ~ms (fn* () ~@body))))")
+	in.mustEvalString(`(defmacro! cond (fn* (& xs) (if (> (count xs) 0) (list 'if (first xs) (if (> (count xs) 1) (nth xs 1) (throw "odd number of forms to cond")) (cons 'cond (rest (rest xs)))))))`)
+	in.setNamespace(in.namespace(userNamespace))
+	return in
+}
+
+func orReader(r io.Reader, def io.Reader) io.Reader {
+	if r == nil {
+		return def
+	}
+	return r
+}
+
+func orWriter(w io.Writer, def io.Writer) io.Writer {
+	if w == nil {
+		return def
+	}
+	return w
+}
+
+func (in *Interpreter) mustEvalString(src string) {
+	if _, err := in.EvalString(context.Background(), src); err != nil {
+		panic(err)
+	}
+}
+
+//Stdout returns the writer the interpreter prints to
+func (in *Interpreter) Stdout() io.Writer {
+	return in.stdout
+}
+
+//Stderr returns the writer errors should be reported to
+func (in *Interpreter) Stderr() io.Writer {
+	return in.stderr
+}
+
+//EvalString reads all forms in src and evaluates them one after another. It returns the result of the last form,
+//or nil if src contains no forms
+func (in *Interpreter) EvalString(ctx context.Context, src string) (Type, error) {
+	reader := NewReader(strings.NewReader(src), "")
+	var res Type = &Nil{}
+	for {
+		form, err := reader.ReadForm()
+		if err == io.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res, err = in.EvalForm(ctx, form); err != nil {
+			return nil, err
+		}
+	}
+}
+
+//setContext makes ctx the context of the evaluation
+func (in *Interpreter) setContext(ctx context.Context) {
+	in.ctx, in.done = ctx, ctx.Done()
+}
+
+//EvalForm evaluates a form that has already been read. The evaluation stops with an error once ctx is done
+func (in *Interpreter) EvalForm(ctx context.Context, form Type) (Type, error) {
+	return in.evaluation(ctx, func() (Type, error) {
+		return in.eval(form)
+	})
+}
+
+//LoadFile reads and evaluates the forms in a file one after another. The evaluation stops with an error once ctx is done
+func (in *Interpreter) LoadFile(ctx context.Context, filename string) (Type, error) {
+	return in.evaluation(ctx, func() (Type, error) {
+		return in.loadFile(filename)
+	})
+}
+
+//Realize computes all elements of the lazy sequences in value, see Realize, as an evaluation of its own, which
+//stops with an error once ctx is done or it takes more than Options.MaxSteps steps
+func (in *Interpreter) Realize(ctx context.Context, value Type) error {
+	_, err := in.evaluation(ctx, func() (Type, error) {
+		return nil, Realize(value)
+	})
+	return err
+}
+
+//evaluation runs eval as an evaluation of its own in ctx: with a step count of its own, and with a panic turned
+//into an error
+func (in *Interpreter) evaluation(ctx context.Context, eval func() (Type, error)) (_ Type, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer recoverPanic(&err)
+	defer in.setContext(in.ctx)
+	in.setContext(ctx)
+	defer func(depth int, steps int) { in.depth, in.steps = depth, steps }(in.depth, in.steps)
+	in.steps = 0
+	return eval()
+}
+
+//recoverPanic turns a panic of the evaluation, a bug of the interpreter or of a registered function, into an
+//internal error in *err, which fails the form like a mal exception would instead of ending the host program
+func recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = Errorf("internal error: %v", r)
+	}
+}
+
+//Define binds name to value in the interpreter's mal.core namespace, which all namespaces refer to
+func (in *Interpreter) Define(name string, value Type) {
+	in.core.env.Set(&Symbol{Value: name}, value)
+}
+
+//RegisterFunc makes a Go function callable from mal under name
+func (in *Interpreter) RegisterFunc(name string, fn func(args ...Type) (Type, error)) {
+	in.Define(name, &Function{Name: name, Fn: fn})
+}
+
+//registerNative is RegisterFunc for the functions of the interpreter, whose signatures are in coreSignatures.
+//It only defines the ones the profile of the interpreter allows
+func (in *Interpreter) registerNative(name string, fn func(args ...Type) (Type, error)) {
+	if in.profile.allows(name) {
+		in.Define(name, in.limited(in.stepping(native(name, fn))))
+	}
+}
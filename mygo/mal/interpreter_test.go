@@ -0,0 +1,282 @@
+package mal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvalString(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		tests := []struct {
+			src  string
+			want string
+		}{
+			{"(+ 1 2)", "3"},
+			{"(def! x 10) (* x x)", "100"},
+			{"x", "10"},
+			{"", "nil"},
+			{"(map (fn* [x] (+ x 1)) [1 2 3])", "(2 3 4)"},
+		}
+		for _, test := range tests {
+			res, err := in.EvalString(context.Background(), test.src)
+			if err != nil {
+				t.Errorf("%s: %s: %v", name, test.src, err)
+			} else if got := PrString(res, true); got != test.want {
+				t.Errorf("%s: %s: got %s, want %s", name, test.src, got, test.want)
+			}
+		}
+	}
+}
+
+func TestEvalStringErrors(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		tests := []struct {
+			src  string
+			want string
+		}{
+			{"(undefined 1)", "'undefined' not found"},
+			{"(+ 1", "unbalanced parenthesis in list, expected ')'"},
+			{"(throw {:a 1})", "{:a 1}"},
+			{"(def! y 1) (nth [] y)", "nth: Index out of range"},
+		}
+		for _, test := range tests {
+			_, err := in.EvalString(context.Background(), test.src)
+			if err == nil || err.Error() != test.want {
+				t.Errorf("%s: %s: got error %v, want %s", name, test.src, err, test.want)
+			}
+		}
+		// values thrown by mal code reach the host as they are
+		_, err := in.EvalString(context.Background(), "(throw [1 2])")
+		var malErr *Error
+		if !errors.As(err, &malErr) || PrString(malErr.Value, true) != "[1 2]" {
+			t.Errorf("%s: got %v, want an *Error with the value thrown", name, err)
+		}
+		// incomplete input can be told apart from invalid input
+		if _, err := in.EvalString(context.Background(), "(a [b"); !errors.Is(err, ErrIncomplete) {
+			t.Errorf("%s: got %v, want ErrIncomplete", name, err)
+		}
+		// a failed evaluation leaves the interpreter usable
+		if res, err := in.EvalString(context.Background(), "(+ y 1)"); err != nil || PrString(res, true) != "2" {
+			t.Errorf("%s: got %v, %v after errors", name, res, err)
+		}
+	}
+}
+
+func TestEvalStringContext(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := in.EvalString(ctx, "(def! f (fn* [] (f))) (f)")
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v, want the deadline to be exceeded", name, err)
+		}
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		if _, err := in.EvalString(ctx, "1"); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v for a cancelled context", name, err)
+		}
+	}
+}
+
+func TestInfiniteLazySeqTimeout(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		res, err := in.EvalString(context.Background(), "(try* (with-timeout 200 (count (range))) (catch* e e))")
+		if err != nil || PrString(res, true) != `"Timeout: evaluation took longer than 200 ms"` {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		// an infinite sequence the evaluation returns can't be realized beyond the deadline either
+		seq, err := in.EvalString(context.Background(), "(map (fn* [x] x) (range))")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		err = in.Realize(ctx, seq)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: got %v, want the deadline to be exceeded", name, err)
+		}
+		in = New(Options{VM: opts.VM, MaxSteps: 1000})
+		if _, err := in.EvalString(context.Background(), "(count (range))"); err == nil || err.Error() != "The evaluation exceeded its limit of 1000 steps" {
+			t.Errorf("%s: got error %v", name, err)
+		}
+	}
+}
+
+func TestRealizeAfterStop(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		src := "(def! s (lazy-seq (loop [i 0] (if (< i 1000000) (recur (+ i 1)) (list i)))))" +
+			"(try* (with-timeout 1 (first s)) (catch* e e))"
+		res, err := in.EvalString(context.Background(), src)
+		if err != nil || PrString(res, true) != `"Timeout: evaluation took longer than 1 ms"` {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		// the sequence wasn't realized, a new evaluation with a context of its own can do that
+		if res, err := in.EvalString(context.Background(), "(first s)"); err != nil || PrString(res, true) != "1000000" {
+			t.Errorf("%s: got %v, %v after a timeout", name, res, err)
+		}
+		in = New(Options{VM: opts.VM, MaxSteps: 1000})
+		src = "(def! s (lazy-seq (loop [i 0] (if (< i 600) (recur (+ i 1)) (list i)))))" +
+			"(loop [i 0] (if (< i 600) (recur (+ i 1)) (first s)))"
+		if _, err := in.EvalString(context.Background(), src); err == nil || err.Error() != "The evaluation exceeded its limit of 1000 steps" {
+			t.Errorf("%s: got error %v", name, err)
+		}
+		if res, err := in.EvalString(context.Background(), "(first s)"); err != nil || PrString(res, true) != "600" {
+			t.Errorf("%s: got %v, %v after exceeding the steps", name, res, err)
+		}
+	}
+}
+
+func TestEvalForm(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		form := NewList(false, &Symbol{Value: "str"}, &String{Value: "a"}, NewInt(1), &Keyword{Value: ":k"})
+		res, err := in.EvalForm(context.Background(), form)
+		if err != nil || PrString(res, true) != `"a1:k"` {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		if _, err := in.EvalForm(context.Background(), &Symbol{Value: "nope"}); err == nil || err.Error() != "'nope' not found" {
+			t.Errorf("%s: got error %v", name, err)
+		}
+	}
+}
+
+func TestDefine(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		hmap := NewHashMap()
+		hmap.Set(&Keyword{Value: ":port"}, NewInt(8080))
+		in.Define("config", &hmap)
+		in.Define("greeting", &String{Value: "hello"})
+		res, err := in.EvalString(context.Background(), "[(get config :port) (str greeting \" world\")]")
+		if err != nil || PrString(res, true) != `[8080 "hello world"]` {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		// definitions are visible from every namespace
+		res, err = in.EvalString(context.Background(), "(in-ns 'other) greeting")
+		if err != nil || PrString(res, true) != `"hello"` {
+			t.Errorf("%s: got %v, %v in another namespace", name, res, err)
+		}
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		var got []Type
+		in.RegisterFunc("record", func(args ...Type) (Type, error) {
+			got = append(got, args...)
+			return NewList(true, args...), nil
+		})
+		in.RegisterFunc("fail", func(args ...Type) (Type, error) {
+			return nil, Errorf("failed with %d arguments", len(args))
+		})
+		res, err := in.EvalString(context.Background(), "(record 1 \"two\" :three (fn* [x] x))")
+		if err != nil || !strings.HasPrefix(PrString(res, true), `[1 "two" :three #<function>]`) {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		if len(got) != 4 {
+			t.Fatalf("%s: the function got %d arguments", name, len(got))
+		}
+		// functions defined in mal can be called from Go
+		fn, ok := got[3].(*Function)
+		if !ok {
+			t.Fatalf("%s: got %T, want a function", name, got[3])
+		}
+		if res, err := fn.Fn(NewInt(5)); err != nil || PrString(res, true) != "5" {
+			t.Errorf("%s: calling a mal function from Go: got %v, %v", name, res, err)
+		}
+		// errors of Go functions can be caught in mal, and reach the host otherwise
+		res, err = in.EvalString(context.Background(), "(try* (fail 1 2) (catch* e e))")
+		if err != nil || PrString(res, true) != `"failed with 2 arguments"` {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		if _, err := in.EvalString(context.Background(), "(fail)"); err == nil || err.Error() != "failed with 0 arguments" {
+			t.Errorf("%s: got error %v", name, err)
+		}
+	}
+}
+
+func TestPanics(t *testing.T) {
+	for name, opts := range backends {
+		in := New(opts)
+		in.RegisterFunc("boom", func(args ...Type) (Type, error) {
+			panic("boom")
+		})
+		// a panic fails the form with an error, which leaves the interpreter usable
+		for _, src := range []string{"(boom)", "(let* [f (fn* [] (boom))] (+ 1 (f)))"} {
+			_, err := in.EvalString(context.Background(), src)
+			var malErr *Error
+			if !errors.As(err, &malErr) || err.Error() != "internal error: boom" {
+				t.Errorf("%s: %s: got error %v", name, src, err)
+			}
+		}
+		if res, err := in.EvalString(context.Background(), "(+ 1 2)"); err != nil || PrString(res, true) != "3" {
+			t.Errorf("%s: after a panic: got %v, %v", name, res, err)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "lib.mal")
+	if err := os.WriteFile(filename, []byte("(def! double (fn* [x] (* 2 x)))\n(prn :loaded)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, opts := range backends {
+		var stdout bytes.Buffer
+		opts.Stdout = &stdout
+		in := New(opts)
+		if _, err := in.LoadFile(context.Background(), filename); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		res, err := in.EvalString(context.Background(), "(double 21)")
+		if err != nil || PrString(res, true) != "42" {
+			t.Errorf("%s: got %v, %v", name, res, err)
+		}
+		if stdout.String() != ":loaded\n" {
+			t.Errorf("%s: printed %q", name, stdout.String())
+		}
+		if _, err := in.LoadFile(context.Background(), filename+".missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: got %v for a missing file", name, err)
+		}
+	}
+}
+
+func TestStreams(t *testing.T) {
+	var stdout bytes.Buffer
+	in := New(Options{Stdin: strings.NewReader("a line\n"), Stdout: &stdout})
+	res, err := in.EvalString(context.Background(), `(do (println "read:" (readline "> ")) (prn "x"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.(*Nil); !ok {
+		t.Errorf("got %s", PrString(res, true))
+	}
+	if want := "> read: a line\n\"x\"\n"; stdout.String() != want {
+		t.Errorf("printed %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestIndependentInterpreters(t *testing.T) {
+	a, b := New(Options{}), New(Options{VM: true})
+	if _, err := a.EvalString(context.Background(), "(def! x :a)"); err != nil {
+		t.Fatal(err)
+	}
+	a.Define("only-a", NewInt(1))
+	if _, err := b.EvalString(context.Background(), "x"); err == nil {
+		t.Error("a variable of one interpreter is visible in another")
+	}
+	if _, err := b.EvalString(context.Background(), "only-a"); err == nil {
+		t.Error("a definition of one interpreter is visible in another")
+	}
+}
@@ -0,0 +1,331 @@
+package mal
+
+import "fmt"
+
+// A lazy sequence is realized one element at a time: the first time its first element or its rest is needed,
+// fn is called to compute the sequence, which may be a list, a vector, nil or another lazy sequence. The result
+// (or the error) is kept, so fn is called at most once, unless the evaluation realizing it had to stop, e.g. because
+// it timed out. A realized lazy sequence is a cons cell whose rest may again be a lazy sequence. Realizing an element
+// of a sequence of an interpreter is a step of its evaluation, so that an infinite sequence can't keep it from timing
+// out
+
+//NewLazySeq creates a lazy sequence that is computed by fn
+func NewLazySeq(fn func() (Type, error)) *LazySeq {
+	return &LazySeq{fn: fn}
+}
+
+//stepping returns fn, a native function, such that realizing the lazy sequences it returns are steps of the
+//evaluation, like the sequences that follow them
+func (in *Interpreter) stepping(fn *Function) *Function {
+	stepping := CopyOfFunction(fn)
+	stepping.Fn = func(args ...Type) (Type, error) {
+		res, err := fn.Fn(args...)
+		if seq, ok := res.(*LazySeq); ok && seq.in == nil {
+			seq.in = in
+		}
+		return res, err
+	}
+	return stepping
+}
+
+//lazyCons returns a sequence of first followed by the elements of the sequence rest, without realizing rest
+func lazyCons(first Type, rest Type) *LazySeq {
+	return &LazySeq{realized: true, first: first, rest: rest}
+}
+
+func (seq *LazySeq) realize() error {
+	if seq.realized {
+		return seq.err
+	}
+	in := seq.in
+	if in != nil {
+		if err := in.step(); err != nil {
+			return err
+		}
+		// computing the sequence may realize the one it is computed from, and so on, which recurses in Go
+		if err := in.nest(); err != nil {
+			return err
+		}
+	}
+	v, err := seq.fn()
+	if in != nil {
+		in.depth--
+	}
+	if stop, _ := isStop(err); stop {
+		return err
+	}
+	seq.fn = nil
+	seq.realized = true
+	if err != nil {
+		seq.err = err
+		return err
+	}
+	first, rest, ok, err := uncons(v)
+	seq.first, seq.rest, seq.empty, seq.err = first, rest, !ok, err
+	return err
+}
+
+//Slice realizes the whole sequence and returns its elements
+func (seq *LazySeq) Slice() ([]Type, error) {
+	var elements []Type
+	var rest Type = seq
+	for {
+		first, next, ok, err := uncons(rest)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return elements, nil
+		}
+		elements = append(elements, first)
+		rest = next
+	}
+}
+
+//uncons splits a sequence into its first element and the rest. ok is false if the sequence is empty
+func uncons(seq Type) (first Type, rest Type, ok bool, err error) {
+	switch v := seq.(type) {
+	case *List:
+		if v.Len() == 0 {
+			return nil, nil, false, nil
+		}
+		return v.Nth(0), v.Rest(), true, nil
+	case *LazySeq:
+		if err := v.realize(); err != nil || v.empty {
+			return nil, nil, false, err
+		}
+		if rest, ok := v.rest.(*LazySeq); ok && rest.in == nil {
+			rest.in = v.in
+		}
+		return v.first, v.rest, true, nil
+	case *Nil:
+		return nil, nil, false, nil
+	}
+	return nil, nil, false, fmt.Errorf("lazy-seq: expected a sequence, got %s", typeName(seq))
+}
+
+//seqOf returns a sequence of the elements of a collection, which can be taken apart with uncons
+func seqOf(name string, coll Type) (Type, error) {
+	switch coll.(type) {
+	case *List, *LazySeq, *Nil:
+		return coll, nil
+	}
+	elements, err := elementsOf(name, coll)
+	if err != nil {
+		return nil, err
+	}
+	return NewList(false, elements...), nil
+}
+
+//isLazy reports whether any of values is a lazy sequence
+func isLazy(values []Type) bool {
+	for _, v := range values {
+		if _, ok := v.(*LazySeq); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//Realize computes all elements of the lazy sequences in value, including nested ones, and returns the first
+//error it runs into. Printing a value doesn't report errors, so this should be called before printing
+func Realize(value Type) error {
+	return realizeNested(value, 0)
+}
+
+//realizeNested realizes the lazy sequences in value, which is nested depth levels deep. It fails for collections
+//nested deeper than MaxNesting
+func realizeNested(value Type, depth int) error {
+	switch value.(type) {
+	case *List, *LazySeq, *HashMap, *Set:
+		if depth >= MaxNesting {
+			return nestingError()
+		}
+	}
+	switch v := value.(type) {
+	case *LazySeq:
+		var seq Type = v
+		for {
+			first, rest, ok, err := uncons(seq)
+			if err != nil || !ok {
+				return err
+			}
+			if err := realizeNested(first, depth+1); err != nil {
+				return err
+			}
+			seq = rest
+		}
+	case *List:
+		for _, el := range v.Slice() {
+			if err := realizeNested(el, depth+1); err != nil {
+				return err
+			}
+		}
+	case *HashMap:
+		for _, e := range v.Entries() {
+			if err := realizeNested(e.Key, depth+1); err != nil {
+				return err
+			}
+			if err := realizeNested(e.Value, depth+1); err != nil {
+				return err
+			}
+		}
+	case *Set:
+		for _, el := range v.Slice() {
+			if err := realizeNested(el, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//lazyElements returns the elements of a lazy sequence that can be realized without an error
+func lazyElements(seq *LazySeq) []Type {
+	var elements []Type
+	var rest Type = seq
+	for {
+		first, next, ok, err := uncons(rest)
+		if err != nil || !ok {
+			return elements
+		}
+		elements = append(elements, first)
+		rest = next
+	}
+}
+
+//lazyMap returns a lazy sequence of the results of calling fn with the first elements of all seqs, then with the
+//second ones and so on, until one of seqs runs out of elements
+func lazyMap(fn *Function, seqs []Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		args := make([]Type, len(seqs))
+		rests := make([]Type, len(seqs))
+		for i, seq := range seqs {
+			first, rest, ok, err := uncons(seq)
+			if err != nil || !ok {
+				return &Nil{}, err
+			}
+			args[i], rests[i] = first, rest
+		}
+		v, err := fn.Fn(args...)
+		if err != nil {
+			return nil, err
+		}
+		return lazyCons(v, lazyMap(fn, rests)), nil
+	})
+}
+
+//lazyFilter returns a lazy sequence of the elements of seq for which pred returns a truthy value
+func lazyFilter(pred *Function, seq Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		for {
+			first, rest, ok, err := uncons(seq)
+			if err != nil || !ok {
+				return &Nil{}, err
+			}
+			keep, err := pred.Fn(first)
+			if err != nil {
+				return nil, err
+			}
+			if isTruthy(keep) {
+				return lazyCons(first, lazyFilter(pred, rest)), nil
+			}
+			seq = rest
+		}
+	})
+}
+
+//lazyRange returns a lazy sequence of the numbers from start up to, but not including end, in increments of step.
+//If end is nil, the sequence is infinite
+func lazyRange(start *Number, end *Number, step *Number) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		if end != nil && (step.Sign() >= 0 && start.Cmp(end) >= 0 || step.Sign() < 0 && start.Cmp(end) <= 0) {
+			return &Nil{}, nil
+		}
+		return lazyCons(start, lazyRange(start.Add(step), end, step)), nil
+	})
+}
+
+//lazyIterate returns the infinite lazy sequence x, (fn x), (fn (fn x)), ...
+func lazyIterate(fn *Function, x Type) *LazySeq {
+	return lazyCons(x, NewLazySeq(func() (Type, error) {
+		next, err := fn.Fn(x)
+		if err != nil {
+			return nil, err
+		}
+		return lazyIterate(fn, next), nil
+	}))
+}
+
+//lazyRepeat returns a lazy sequence of n times x, or an infinite one if n is negative
+func lazyRepeat(n int64, x Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		if n == 0 {
+			return &Nil{}, nil
+		}
+		return lazyCons(x, lazyRepeat(n-1, x)), nil
+	})
+}
+
+//lazyCycle returns an infinite lazy sequence that repeats the elements of coll, which starts at seq
+func lazyCycle(coll Type, seq Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		first, rest, ok, err := uncons(seq)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if seq == coll { // the collection is empty
+				return &Nil{}, nil
+			}
+			return lazyCycle(coll, coll), nil
+		}
+		return lazyCons(first, lazyCycle(coll, rest)), nil
+	})
+}
+
+//lazyTake returns a lazy sequence of the first n elements of seq
+func lazyTake(n int64, seq Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		if n <= 0 {
+			return &Nil{}, nil
+		}
+		first, rest, ok, err := uncons(seq)
+		if err != nil || !ok {
+			return &Nil{}, err
+		}
+		return lazyCons(first, lazyTake(n-1, rest)), nil
+	})
+}
+
+//lazyDrop returns a lazy sequence of all but the first n elements of seq
+func lazyDrop(n int64, seq Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		for ; n > 0; n-- {
+			_, rest, ok, err := uncons(seq)
+			if err != nil || !ok {
+				return &Nil{}, err
+			}
+			seq = rest
+		}
+		return seq, nil
+	})
+}
+
+//lazyTakeWhile returns a lazy sequence of the elements of seq up to the first one for which pred returns a falsy value
+func lazyTakeWhile(pred *Function, seq Type) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		first, rest, ok, err := uncons(seq)
+		if err != nil || !ok {
+			return &Nil{}, err
+		}
+		keep, err := pred.Fn(first)
+		if err != nil {
+			return nil, err
+		}
+		if !isTruthy(keep) {
+			return &Nil{}, nil
+		}
+		return lazyCons(first, lazyTakeWhile(pred, rest)), nil
+	})
+}
@@ -0,0 +1,247 @@
+package mal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokLBrace
+	tokRBrace
+	tokQuote
+	tokQuasiquote
+	tokUnquote
+	tokSpliceUnquote
+	tokDeref
+	tokMeta
+	tokString
+	tokSetStart
+	tokFnStart
+	tokRegex
+	tokDiscard
+	tokNumber
+	tokKeyword
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  Position
+}
+
+//lexer splits mal source into tokens, reading runes lazily from the underlying reader
+type lexer struct {
+	in   io.RuneReader
+	file string
+	line int
+	col  int
+
+	// one rune of lookahead
+	ahead    rune
+	hasAhead bool
+	err      error
+
+	buf bytes.Buffer
+}
+
+func newLexer(in io.Reader, filename string) *lexer {
+	rr, ok := in.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(in)
+	}
+	return &lexer{in: rr, file: filename, line: 1, col: 1}
+}
+
+//peekRune returns the next rune without consuming it. eof is true at the end of input or on a read error
+func (lex *lexer) peekRune() (r rune, eof bool) {
+	if !lex.hasAhead {
+		if lex.err != nil {
+			return 0, true
+		}
+		r, _, err := lex.in.ReadRune()
+		if err != nil {
+			lex.err = err
+			return 0, true
+		}
+		lex.ahead = r
+		lex.hasAhead = true
+	}
+	return lex.ahead, false
+}
+
+func (lex *lexer) nextRune() (r rune, eof bool) {
+	r, eof = lex.peekRune()
+	if eof {
+		return 0, true
+	}
+	lex.hasAhead = false
+	if r == '\n' {
+		lex.line++
+		lex.col = 1
+	} else {
+		lex.col++
+	}
+	return r, false
+}
+
+func (lex *lexer) position() Position {
+	return Position{File: lex.file, Line: lex.line, Col: lex.col}
+}
+
+func isDelimiter(r rune) bool {
+	switch r {
+	case '[', ']', '{', '}', '(', ')', '\'', '"', '`', ',', ';':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+//skipWhitespace skips whitespace, commas and comments
+func (lex *lexer) skipWhitespace() {
+	for {
+		r, eof := lex.peekRune()
+		if eof {
+			return
+		}
+		switch {
+		case r == ';':
+			for r != '\n' && !eof {
+				r, eof = lex.nextRune()
+			}
+		case r == ',' || unicode.IsSpace(r):
+			lex.nextRune()
+		default:
+			return
+		}
+	}
+}
+
+//next returns the next token. At the end of input a token of kind tokEOF is returned
+func (lex *lexer) next() (token, error) {
+	lex.skipWhitespace()
+	pos := lex.position()
+	r, eof := lex.nextRune()
+	if eof {
+		if lex.err != io.EOF {
+			return token{}, lex.err
+		}
+		return token{kind: tokEOF, pos: pos}, nil
+	}
+
+	switch r {
+	case '(':
+		return token{kind: tokLParen, val: "(", pos: pos}, nil
+	case ')':
+		return token{kind: tokRParen, val: ")", pos: pos}, nil
+	case '[':
+		return token{kind: tokLBracket, val: "[", pos: pos}, nil
+	case ']':
+		return token{kind: tokRBracket, val: "]", pos: pos}, nil
+	case '{':
+		return token{kind: tokLBrace, val: "{", pos: pos}, nil
+	case '}':
+		return token{kind: tokRBrace, val: "}", pos: pos}, nil
+	case '\'':
+		return token{kind: tokQuote, val: "'", pos: pos}, nil
+	case '`':
+		return token{kind: tokQuasiquote, val: "`", pos: pos}, nil
+	case '@':
+		return token{kind: tokDeref, val: "@", pos: pos}, nil
+	case '^':
+		return token{kind: tokMeta, val: "^", pos: pos}, nil
+	case '~':
+		if p, eof := lex.peekRune(); !eof && p == '@' {
+			lex.nextRune()
+			return token{kind: tokSpliceUnquote, val: "~@", pos: pos}, nil
+		}
+		return token{kind: tokUnquote, val: "~", pos: pos}, nil
+	case '"':
+		return lex.readString(pos)
+	case '#':
+		return lex.readDispatch(pos)
+	}
+
+	lex.buf.Reset()
+	lex.buf.WriteRune(r)
+	for {
+		p, eof := lex.peekRune()
+		if eof || isDelimiter(p) {
+			break
+		}
+		lex.nextRune()
+		lex.buf.WriteRune(p)
+	}
+	val := lex.buf.String()
+
+	kind := tokSymbol
+	switch {
+	case isDigit(r):
+		kind = tokNumber
+	case (r == '-' || r == '+') && len(val) > 1 && isDigit(rune(val[1])):
+		kind = tokNumber
+	case r == ':':
+		kind = tokKeyword
+	}
+	return token{kind: kind, val: val, pos: pos}, nil
+}
+
+//readString reads a string literal up to and including the closing quote. Escape sequences are kept as is,
+//they are parsed by ReadString
+func (lex *lexer) readString(pos Position) (token, error) {
+	lex.buf.Reset()
+	lex.buf.WriteByte('"')
+	for {
+		r, eof := lex.nextRune()
+		if eof {
+			return token{}, WithPosition(&IncompleteError{Msg: "unbalanced \", expected '\"', got EOF"}, &pos)
+		}
+		lex.buf.WriteRune(r)
+		switch r {
+		case '"':
+			return token{kind: tokString, val: lex.buf.String(), pos: pos}, nil
+		case '\\':
+			if r, eof := lex.nextRune(); !eof {
+				lex.buf.WriteRune(r)
+			}
+		}
+	}
+}
+
+//readDispatch reads the token following a #, e.g. #{ for a set literal
+func (lex *lexer) readDispatch(pos Position) (token, error) {
+	r, eof := lex.nextRune()
+	if eof {
+		return token{}, WithPosition(&IncompleteError{Msg: "expected a dispatch character after #, got EOF"}, &pos)
+	}
+	switch r {
+	case '{':
+		return token{kind: tokSetStart, val: "#{", pos: pos}, nil
+	case '(':
+		return token{kind: tokFnStart, val: "#(", pos: pos}, nil
+	case '_':
+		return token{kind: tokDiscard, val: "#_", pos: pos}, nil
+	case '"':
+		tok, err := lex.readString(pos)
+		if err != nil {
+			return token{}, err
+		}
+		tok.kind = tokRegex
+		return tok, nil
+	}
+	return token{}, WithPosition(fmt.Errorf("unsupported reader dispatch #%c", r), &pos)
+}
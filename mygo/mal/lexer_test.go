@@ -0,0 +1,96 @@
+package mal
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//perfFiles are the sources the benchmarks read, each repeated to the size of a large file loaded with load-file
+var perfFiles = []string{"perf1", "perf2", "perf3"}
+
+const perfRepeat = 200
+
+func perfSource(b *testing.B, name string) string {
+	src, err := ioutil.ReadFile("../../tests/" + name + ".mal")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return strings.Repeat(string(src)+"\n", perfRepeat)
+}
+
+//regexpTokenize is the tokenizer the lexer replaced, kept to compare them
+func regexpTokenize(s string) []string {
+	re := regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" +
+		`~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" +
+		`,;)]*)`)
+	matches := re.FindAllStringSubmatch(s, -1)
+	res := make([]string, len(matches))
+	for i := range matches {
+		res[i] = matches[i][1]
+	}
+	return res
+}
+
+func BenchmarkRegexpTokenize(b *testing.B) {
+	for _, name := range perfFiles {
+		src := perfSource(b, name)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			for i := 0; i < b.N; i++ {
+				regexpTokenize(src)
+			}
+		})
+	}
+}
+
+func BenchmarkLex(b *testing.B) {
+	for _, name := range perfFiles {
+		src := perfSource(b, name)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			for i := 0; i < b.N; i++ {
+				lex := newLexer(strings.NewReader(src), name)
+				for {
+					tok, err := lex.next()
+					if err != nil {
+						b.Fatal(err)
+					}
+					if tok.kind == tokEOF {
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReadAll(b *testing.B) {
+	for _, name := range perfFiles {
+		src := perfSource(b, name)
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(src)))
+			for i := 0; i < b.N; i++ {
+				if _, err := ReadAll(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+//BenchmarkReadString is read-string called in a loop on a small form, which recompiled the regexp every time
+func BenchmarkReadString(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadStr("(+ 1 (* 2 3) [a b] {:c \"d\"})"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegexpTokenizeString(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		regexpTokenize("(+ 1 (* 2 3) [a b] {:c \"d\"})")
+	}
+}
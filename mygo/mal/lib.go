@@ -0,0 +1,166 @@
+package mal
+
+// Libraries. load-lib and require look libraries up on the library path: in the directories of Options.LibPath,
+// then in the standard library, a copy of the lib directory of the mal repository embedded in the binary, so that
+// it is always available. Each library is loaded only once, unless loading it fails: then it is loaded again the
+// next time, and the namespaces it created are dropped, so that require doesn't take them for loaded. The libraries of the standard library refer to each
+// other as ../lib/name.mal, like code written for the layout of the repository does, so load-file and load-lib fall
+// back to the standard library for such names
+
+import (
+	"embed"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//go:generate sh -c "rm -f lib/*.mal && cp ../../lib/*.mal lib/"
+
+//stdlib holds the standard library
+//go:embed lib/*.mal
+var stdlib embed.FS
+
+//library is a library found on the library path: a file, or a file of stdlib if embedded is set
+type library struct {
+	path     string
+	embedded bool
+}
+
+//findLib returns the library called name, a path relative to the directories of the library path, for the
+//native function called fn. Interpreters whose profile can't read files only find the standard library
+func (in *Interpreter) findLib(fn string, name string) (library, error) {
+	if in.profile.capabilities()&capReadFiles != 0 {
+		for _, dir := range in.libPath {
+			p := name
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(dir, p)
+			}
+			p, err := in.filePath(fn, p)
+			if err != nil {
+				continue
+			}
+			if info, err := os.Stat(p); err == nil && !info.IsDir() {
+				return library{path: p}, nil
+			}
+		}
+	}
+	if p, ok := stdlibPath(name); ok {
+		return library{path: p, embedded: true}, nil
+	}
+	where := "the standard library"
+	if in.profile.capabilities()&capReadFiles != 0 {
+		where = strings.Join(in.libPath, ", ") + " or " + where
+	}
+	return library{}, Errorf("%s: can't find %s in %s", fn, name, where)
+}
+
+//stdlibPath returns the path in stdlib of the library called name, which may also be a path to the lib directory
+//of the repository, e.g. ../lib/name.mal
+func stdlibPath(name string) (string, bool) {
+	name = path.Clean(filepath.ToSlash(name))
+	for strings.HasPrefix(name, "../") {
+		name = name[len("../"):]
+	}
+	p := "lib/" + strings.TrimPrefix(name, "lib/")
+	f, err := stdlib.Open(p)
+	if err != nil {
+		return "", false
+	}
+	f.Close()
+	return p, true
+}
+
+//key identifies a library among the ones an interpreter loaded
+func (lib library) key() string {
+	if lib.embedded {
+		return "embedded:" + lib.path
+	}
+	if abs, err := filepath.Abs(lib.path); err == nil {
+		return abs
+	}
+	return lib.path
+}
+
+//loadLib loads the library called name for the native function called fn, unless it was loaded before or is being
+//loaded, by a library it requires
+func (in *Interpreter) loadLib(fn string, name string) error {
+	lib, err := in.findLib(fn, name)
+	if err != nil {
+		return err
+	}
+	key := lib.key()
+	if in.loaded[key] || in.loading[key] {
+		return nil
+	}
+	existing := make(map[string]bool, len(in.namespaces))
+	for name := range in.namespaces {
+		existing[name] = true
+	}
+	in.loading[key] = true
+	_, err = in.loadLibrary(lib)
+	delete(in.loading, key)
+	if err != nil {
+		for name := range in.namespaces {
+			if !existing[name] {
+				delete(in.namespaces, name)
+			}
+		}
+		return err
+	}
+	in.loaded[key] = true
+	return nil
+}
+
+//loadLibrary reads and evaluates the forms of a library one after another
+func (in *Interpreter) loadLibrary(lib library) (Type, error) {
+	if !lib.embedded {
+		return in.loadFile(lib.path)
+	}
+	f, err := stdlib.Open(lib.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return in.load(f, lib.path)
+}
+
+//loadAnyFile is load-file, which loads the file called name, or the library of the standard library it refers to
+//if there is no such file or the profile of the interpreter can't read files
+func (in *Interpreter) loadAnyFile(name string) (Type, error) {
+	if in.profile.capabilities()&capReadFiles == 0 {
+		if p, ok := stdlibPath(name); ok {
+			return in.loadLibrary(library{path: p, embedded: true})
+		}
+		return nil, Errorf("load-file: can't read %s, the %s profile can't read files", name, in.profile)
+	}
+	p, err := in.filePath("load-file", name)
+	if err != nil || !exists(p) {
+		if p, ok := stdlibPath(name); ok {
+			return in.loadLibrary(library{path: p, embedded: true})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return in.loadFile(p)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
+
+//libPathOf returns the library path of Options.LibPath
+func libPathOf(dirs []string) []string {
+	var libPath []string
+	for _, dir := range dirs {
+		if dir != "" {
+			libPath = append(libPath, dir)
+		}
+	}
+	if len(libPath) == 0 {
+		return []string{"."}
+	}
+	return libPath
+}
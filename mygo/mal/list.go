@@ -0,0 +1,252 @@
+package mal
+
+// Lists and vectors are immutable. Operations that "modify" them return a new List that shares as much
+// as possible with the original, so that e.g. consing onto a list or conjing onto a vector doesn't copy it.
+//
+// The elements of a List are stored in one of three ways:
+//   - in a flat slice, for lists and vectors that are built in one go, e.g. by the reader or by evaluating
+//     a list of arguments. This is the cheapest to access, and the rest of such a list is just a subslice
+//   - as a cons cell: an element followed by another List. This is what cons and conj on lists produce
+//   - in a persistent vector (a 32-way trie), for vectors built with conj and assoc. A list can also be
+//     a view of the elements of such a vector from some offset on, which is what rest on a vector produces
+
+//NewList creates a list or vector of items. The list takes ownership of items, which must not be modified afterwards
+func NewList(isVector bool, items ...Type) *List {
+	return &List{IsVector: isVector, items: items}
+}
+
+//Len returns the number of elements
+func (list *List) Len() int {
+	switch {
+	case list.next != nil:
+		return list.count
+	case list.vec != nil:
+		return list.vec.count - list.offset
+	default:
+		return len(list.items)
+	}
+}
+
+//Nth returns the element at index i, which must be in the range [0, Len()). Indexing is constant time for flat
+//lists and vectors, logarithmic with a base of 32 for persistent vectors and linear in i for cons cells
+func (list *List) Nth(i int) Type {
+	for list.next != nil {
+		if i == 0 {
+			return list.first
+		}
+		list = list.next
+		i--
+	}
+	if list.vec != nil {
+		return list.vec.nth(list.offset + i)
+	}
+	return list.items[i]
+}
+
+//First returns the first element, or nil if the list is empty
+func (list *List) First() Type {
+	if list.Len() == 0 {
+		return nil
+	}
+	return list.Nth(0)
+}
+
+//Rest returns a list of all elements but the first one. The rest of an empty list is an empty list
+func (list *List) Rest() *List {
+	switch {
+	case list.next != nil:
+		return list.next.asList()
+	case list.Len() == 0:
+		return NewList(false)
+	case list.vec != nil:
+		return &List{vec: list.vec, offset: list.offset + 1}
+	default:
+		return NewList(false, list.items[1:]...)
+	}
+}
+
+//asList returns a list with the same elements and without metadata, which is list itself if possible
+func (list *List) asList() *List {
+	if !list.IsVector && list.Meta == nil {
+		return list
+	}
+	newList := *list
+	newList.IsVector = false
+	newList.Meta = nil
+	newList.Pos = nil
+	return &newList
+}
+
+//Cons returns a list of value followed by the elements of list
+func (list *List) Cons(value Type) *List {
+	return &List{first: value, next: list, count: list.Len() + 1}
+}
+
+//Conj adds values to a list or vector, like the conj function does: vectors get them appended at the end,
+//lists get them prepended one after another
+func (list *List) Conj(values ...Type) *List {
+	if !list.IsVector {
+		for _, v := range values {
+			list = list.Cons(v)
+		}
+		return list
+	}
+	vec := list.persistent()
+	for _, v := range values {
+		vec = vec.conj(v)
+	}
+	return &List{IsVector: true, vec: vec}
+}
+
+//Assoc returns a copy of a vector with the element at index i replaced by value. i may be Len(), in which case
+//value is appended
+func (list *List) Assoc(i int, value Type) *List {
+	vec := list.persistent()
+	if i == vec.count {
+		vec = vec.conj(value)
+	} else {
+		vec = vec.assoc(i, value)
+	}
+	return &List{IsVector: true, vec: vec}
+}
+
+//persistent returns the elements of a vector as a persistent vector
+func (list *List) persistent() *vector {
+	if list.vec != nil && list.offset == 0 {
+		return list.vec
+	}
+	vec := emptyVector
+	for _, v := range list.Slice() {
+		vec = vec.conj(v)
+	}
+	return vec
+}
+
+//Slice returns the elements as a slice. For flat lists this doesn't copy anything, so the result must not be modified
+func (list *List) Slice() []Type {
+	if list.next == nil && list.vec == nil {
+		return list.items
+	}
+	items := make([]Type, 0, list.Len())
+	for list.next != nil {
+		items = append(items, list.first)
+		list = list.next
+	}
+	if list.vec != nil {
+		for i := list.offset; i < list.vec.count; i++ {
+			items = append(items, list.vec.nth(i))
+		}
+		return items
+	}
+	return append(items, list.items...)
+}
+
+const (
+	vectorBits  = 5
+	vectorWidth = 1 << vectorBits
+	vectorMask  = vectorWidth - 1
+)
+
+//vector is a persistent vector, see https://hypirion.com/musings/understanding-persistent-vector-pt-1
+//The last (up to 32) elements are kept in tail, all others in a trie of nodes with 32 children each,
+//whose leaves hold 32 elements each
+type vector struct {
+	count int
+	shift uint
+	root  *vectorNode
+	tail  []Type
+}
+
+type vectorNode struct {
+	children []*vectorNode
+	values   []Type
+}
+
+var emptyVector = &vector{shift: vectorBits, root: &vectorNode{}}
+
+//tailOffset returns the index of the first element in the tail
+func (vec *vector) tailOffset() int {
+	return vec.count - len(vec.tail)
+}
+
+func (vec *vector) nth(i int) Type {
+	if i >= vec.tailOffset() {
+		return vec.tail[i-vec.tailOffset()]
+	}
+	node := vec.root
+	for level := vec.shift; level > 0; level -= vectorBits {
+		node = node.children[(i>>level)&vectorMask]
+	}
+	return node.values[i&vectorMask]
+}
+
+func (vec *vector) conj(value Type) *vector {
+	if len(vec.tail) < vectorWidth {
+		tail := make([]Type, len(vec.tail), len(vec.tail)+1)
+		copy(tail, vec.tail)
+		return &vector{count: vec.count + 1, shift: vec.shift, root: vec.root, tail: append(tail, value)}
+	}
+	// the tail is full, move it into the trie
+	tailNode := &vectorNode{values: vec.tail}
+	newVec := &vector{count: vec.count + 1, shift: vec.shift, tail: []Type{value}}
+	if vec.count>>vectorBits > 1<<vec.shift {
+		// the trie is full, add a level
+		newVec.root = &vectorNode{children: []*vectorNode{vec.root, newPath(vec.shift, tailNode)}}
+		newVec.shift += vectorBits
+	} else {
+		newVec.root = vec.pushTail(vec.shift, vec.root, tailNode)
+	}
+	return newVec
+}
+
+func (vec *vector) pushTail(level uint, parent *vectorNode, tailNode *vectorNode) *vectorNode {
+	i := ((vec.count - 1) >> level) & vectorMask
+	node := &vectorNode{children: make([]*vectorNode, len(parent.children), i+1)}
+	copy(node.children, parent.children)
+	child := tailNode
+	if level > vectorBits {
+		if i < len(parent.children) {
+			child = vec.pushTail(level-vectorBits, parent.children[i], tailNode)
+		} else {
+			child = newPath(level-vectorBits, tailNode)
+		}
+	}
+	if i < len(node.children) {
+		node.children[i] = child
+	} else {
+		node.children = append(node.children, child)
+	}
+	return node
+}
+
+//newPath returns a branch of the trie from level down to the leaf node
+func newPath(level uint, node *vectorNode) *vectorNode {
+	if level == 0 {
+		return node
+	}
+	return &vectorNode{children: []*vectorNode{newPath(level-vectorBits, node)}}
+}
+
+func (vec *vector) assoc(i int, value Type) *vector {
+	if i >= vec.tailOffset() {
+		tail := make([]Type, len(vec.tail))
+		copy(tail, vec.tail)
+		tail[i-vec.tailOffset()] = value
+		return &vector{count: vec.count, shift: vec.shift, root: vec.root, tail: tail}
+	}
+	return &vector{count: vec.count, shift: vec.shift, root: assocNode(vec.shift, vec.root, i, value), tail: vec.tail}
+}
+
+func assocNode(level uint, node *vectorNode, i int, value Type) *vectorNode {
+	if level == 0 {
+		values := make([]Type, len(node.values))
+		copy(values, node.values)
+		values[i&vectorMask] = value
+		return &vectorNode{values: values}
+	}
+	children := make([]*vectorNode, len(node.children))
+	copy(children, node.children)
+	j := (i >> level) & vectorMask
+	children[j] = assocNode(level-vectorBits, children[j], i, value)
+	return &vectorNode{children: children}
+}
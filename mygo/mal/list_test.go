@@ -0,0 +1,123 @@
+package mal
+
+import (
+	"fmt"
+	"testing"
+)
+
+//listSizes are the numbers of elements of the lists and vectors the benchmarks work on
+var listSizes = []int{10, 1000, 100000}
+
+func vectorOf(n int) *List {
+	vec := NewList(true)
+	for i := 0; i < n; i++ {
+		vec = vec.Conj(NewInt(int64(i)))
+	}
+	return vec
+}
+
+func TestVectorPersistence(t *testing.T) {
+	vec := vectorOf(5000)
+	replaced := vec.Assoc(1234, &String{Value: "x"})
+	appended := vec.Conj(&String{Value: "y"})
+	for i := 0; i < vec.Len(); i++ {
+		if !equals(vec.Nth(i), NewInt(int64(i))) {
+			t.Fatalf("element %d is %s", i, PrString(vec.Nth(i), true))
+		}
+	}
+	if PrString(replaced.Nth(1234), true) != `"x"` || PrString(appended.Nth(5000), true) != `"y"` {
+		t.Errorf("got %s and %s", PrString(replaced.Nth(1234), true), PrString(appended.Nth(5000), true))
+	}
+	if rest := vec.Rest(); rest.Len() != 4999 || !equals(rest.First(), NewInt(1)) {
+		t.Errorf("the rest has %d elements starting with %s", rest.Len(), PrString(rest.First(), true))
+	}
+}
+
+//copyingConj appends value to a copy of items, like conj did before vectors were persistent, kept to compare them
+func copyingConj(items []Type, value Type) []Type {
+	newItems := make([]Type, len(items), len(items)+1)
+	copy(newItems, items)
+	return append(newItems, value)
+}
+
+func BenchmarkVectorConj(b *testing.B) {
+	for _, n := range listSizes {
+		vec := vectorOf(n)
+		value := NewInt(1)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				vec.Conj(value)
+			}
+		})
+	}
+}
+
+func BenchmarkCopyingConj(b *testing.B) {
+	for _, n := range listSizes {
+		items := vectorOf(n).Slice()
+		value := NewInt(1)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				copyingConj(items, value)
+			}
+		})
+	}
+}
+
+func BenchmarkVectorNth(b *testing.B) {
+	for _, n := range listSizes {
+		vec := vectorOf(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vec.Nth(i % n)
+			}
+		})
+	}
+}
+
+func BenchmarkVectorAssoc(b *testing.B) {
+	for _, n := range listSizes {
+		vec := vectorOf(n)
+		value := NewInt(1)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				vec.Assoc(i%n, value)
+			}
+		})
+	}
+}
+
+//listSink keeps the compiler from optimizing the lists a benchmark creates away
+var listSink *List
+
+func BenchmarkListCons(b *testing.B) {
+	for _, n := range listSizes {
+		list := NewList(false)
+		for i := 0; i < n; i++ {
+			list = list.Cons(NewInt(int64(i)))
+		}
+		value := NewInt(1)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				listSink = list.Cons(value)
+			}
+		})
+	}
+}
+
+//BenchmarkVectorRest takes the rest of a vector, which is a view of its elements
+func BenchmarkVectorRest(b *testing.B) {
+	for _, n := range listSizes {
+		vec := vectorOf(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				listSink = vec.Rest()
+			}
+		})
+	}
+}
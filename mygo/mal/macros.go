@@ -0,0 +1,336 @@
+package mal
+
+// Hygiene and expansion of macros. Within a quasiquote, auto-gensyms like x# are replaced by a symbol generated
+// for the quasiquote, so that the code a macro builds can bind variables without capturing the ones of its caller,
+// and symbols are qualified with the namespace of the quasiquote, so that they refer to the variables of the
+// namespace the macro is defined in wherever it is used. macroexpand-1 and macroexpand-all expand a macro call once,
+// and all macro calls of a form
+
+import (
+	"fmt"
+	"strings"
+)
+
+//specialForms are the symbols the analyzer treats specially at the head of a list, see analyzeList
+var specialForms = map[string]bool{
+	"def!": true, "defmacro!": true, "let*": true, "loop": true, "recur": true, "do": true, "if": true, "fn*": true,
+	"quote": true, "quasiquote": true, "unquote": true, "splice-unquote": true, "macroexpand": true,
+	"macroexpand-1": true, "macroexpand-all": true, "lazy-seq": true, "try*": true, "catch*": true,
+	"finally": true,
+}
+
+//macroExpanders are the functions of the special forms that expand macro calls
+var macroExpanders = map[string]func(form Type, env *Env) (Type, error){
+	"macroexpand": macroExpand,
+	"macroexpand-1": func(form Type, env *Env) (Type, error) {
+		expanded, _, err := macroExpand1(form, env)
+		return expanded, err
+	},
+	"macroexpand-all": macroExpandAll,
+}
+
+//gensym returns a new symbol starting with prefix
+func (in *Interpreter) gensym(prefix string) *Symbol {
+	in.gensyms++
+	return &Symbol{Value: fmt.Sprintf("%s%d", prefix, in.gensyms)}
+}
+
+//syntaxQuoter expands a quasiquote
+type syntaxQuoter struct {
+	in *Interpreter
+	// the symbols the auto-gensyms of the quasiquote are replaced by
+	gensyms map[string]*Symbol
+}
+
+//quasiquote expands a quasiquote into the code that builds the quoted form
+func (in *Interpreter) quasiquote(ast Type) Type {
+	q := &syntaxQuoter{in: in, gensyms: make(map[string]*Symbol)}
+	return q.quasiquote(ast)
+}
+
+//symbol returns the symbol a quasiquote quotes for symbol: the same generated symbol for each auto-gensym, or the
+//symbol qualified with the current namespace. Symbols aren't qualified in the namespace user, whose macros are used
+//where they are defined, nor if they are special forms, qualified already or refer to core functions
+func (q *syntaxQuoter) symbol(symbol *Symbol) *Symbol {
+	name := symbol.Value
+	if len(name) > 1 && strings.HasSuffix(name, "#") {
+		generated, ok := q.gensyms[name]
+		if !ok {
+			generated = q.in.gensym(name[:len(name)-1] + "__")
+			generated.Value += "__auto__"
+			q.gensyms[name] = generated
+		}
+		return generated
+	}
+	ns, core := q.in.ns, q.in.core
+	if ns.name == userNamespace || specialForms[name] || name == "&" {
+		return symbol
+	}
+	if _, _, qualified := splitSymbol(name); qualified {
+		return symbol
+	}
+	if _, shadowed := ns.env.data[name]; !shadowed || ns == core {
+		if _, isCore := core.env.data[name]; isCore {
+			return symbol
+		}
+	}
+	return &Symbol{Value: ns.name + "/" + name, Pos: symbol.Pos}
+}
+
+//macroExpand1 expands ast once if it is a macro call, and tells whether it was
+func macroExpand1(ast Type, env *Env) (Type, bool, error) {
+	if !isMacroCall(ast, env) {
+		return ast, false, nil
+	}
+	astLst, _ := ast.(*List)
+	symbol, _ := astLst.Nth(0).(*Symbol)
+	fn, _ := env.Get(symbol).(*Function)
+	r, err := fn.Fn(astLst.Slice()[1:]...)
+	if err != nil {
+		return nil, false, err
+	}
+	return r, true, nil
+}
+
+//expander expands all macro calls of a form, see macroExpandAll
+type expander struct {
+	env *Env
+	// the number of forms the one being expanded is nested in, see MaxNesting
+	depth int
+}
+
+//macroExpandAll expands all macro calls in ast, and in the forms of the result, except in quoted forms
+func macroExpandAll(ast Type, env *Env) (Type, error) {
+	x := &expander{env: env}
+	return x.all(ast, nil)
+}
+
+//expand expands form until it is no longer a macro call. Calls of local variables, in locals, aren't macro
+//calls, even if they are called like a macro
+func (x *expander) expand(form Type, locals map[string]bool) (Type, error) {
+	for {
+		if list, ok := form.(*List); ok && !list.IsVector && list.Len() > 0 {
+			if symbol, ok := list.First().(*Symbol); ok && locals[symbol.Value] {
+				return form, nil
+			}
+		}
+		expanded, ok, err := macroExpand1(form, x.env)
+		if err != nil || !ok {
+			return form, err
+		}
+		form = expanded
+	}
+}
+
+//all expands all macro calls in form, which is in the scope of locals
+func (x *expander) all(form Type, locals map[string]bool) (Type, error) {
+	if x.depth >= MaxNesting {
+		return nil, nestingError()
+	}
+	x.depth++
+	defer func() { x.depth-- }()
+	form, err := x.expand(form, locals)
+	if err != nil {
+		return nil, err
+	}
+	switch v := form.(type) {
+	case *List:
+		if v.IsVector {
+			items, err := x.allOf(v.Slice(), locals)
+			if err != nil {
+				return nil, err
+			}
+			return NewList(true, items...), nil
+		}
+		return x.list(v, locals)
+	case *HashMap:
+		forms, err := x.allOf(v.forms(), locals)
+		if err != nil {
+			return nil, err
+		}
+		return mapLiteral(forms), nil
+	case *Set:
+		items, err := x.allOf(v.Slice(), locals)
+		if err != nil {
+			return nil, err
+		}
+		set := NewSet()
+		for _, item := range items {
+			set.Add(item)
+		}
+		return &set, nil
+	}
+	return form, nil
+}
+
+func (x *expander) allOf(forms []Type, locals map[string]bool) ([]Type, error) {
+	expanded := make([]Type, len(forms))
+	for i, form := range forms {
+		var err error
+		if expanded[i], err = x.all(form, locals); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+//list expands the macro calls in a list that isn't a macro call, leaving the parts of special forms that aren't
+//evaluated as they are
+func (x *expander) list(list *List, locals map[string]bool) (Type, error) {
+	forms := list.Slice()
+	symbol, _ := list.First().(*Symbol)
+	if symbol == nil || locals[symbol.Value] || len(forms) < 2 {
+		return x.rebuilt(list, forms, 0, locals)
+	}
+	switch symbol.Value {
+	case "quote", "macroexpand", "macroexpand-1", "macroexpand-all":
+		return list, nil
+	case "quasiquote":
+		return x.unquoted(list, locals)
+	case "def!", "defmacro!":
+		return x.rebuilt(list, forms, 2, locals)
+	case "fn*":
+		if !isOverloaded(forms[1:]) {
+			return x.overload(list, locals)
+		}
+		overloads := []Type{symbol}
+		for _, o := range forms[1:] {
+			overload, err := x.overload(o.(*List), locals)
+			if err != nil {
+				return nil, err
+			}
+			overloads = append(overloads, overload)
+		}
+		return withPos(NewList(false, overloads...), list), nil
+	case "let*", "loop":
+		bindings, ok := forms[1].(*List)
+		if !ok {
+			return list, nil
+		}
+		items := append([]Type{}, bindings.Slice()...)
+		inner := locals
+		for i := 0; i+1 < len(items); i += 2 {
+			value, err := x.all(items[i+1], inner)
+			if err != nil {
+				return nil, err
+			}
+			items[i+1] = value
+			inner = withLocals(inner, items[i])
+		}
+		body, err := x.allOf(forms[2:], inner)
+		if err != nil {
+			return nil, err
+		}
+		expanded := append([]Type{symbol, withPos(NewList(bindings.IsVector, items...), bindings)}, body...)
+		return withPos(NewList(false, expanded...), list), nil
+	case "try*":
+		expanded := []Type{symbol}
+		for _, form := range forms[1:] {
+			var err error
+			switch clauseOf(form) {
+			case "catch*":
+				form, err = x.catch(form.(*List), locals)
+			case "finally":
+				form, err = x.rebuilt(form.(*List), form.(*List).Slice(), 1, locals)
+			default:
+				form, err = x.all(form, locals)
+			}
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, form)
+		}
+		return withPos(NewList(false, expanded...), list), nil
+	}
+	return x.rebuilt(list, forms, 0, locals)
+}
+
+//rebuilt returns list with the macro calls of its forms from the one at index from expanded
+func (x *expander) rebuilt(list *List, forms []Type, from int, locals map[string]bool) (Type, error) {
+	rest, err := x.allOf(forms[from:], locals)
+	if err != nil {
+		return nil, err
+	}
+	expanded := append(append([]Type{}, forms[:from]...), rest...)
+	return withPos(NewList(false, expanded...), list), nil
+}
+
+//overload expands the body of a fn*, or of an overload (params body...) of one, in which its parameters are local
+func (x *expander) overload(list *List, locals map[string]bool) (Type, error) {
+	forms := list.Slice()
+	i := 0
+	if symbol, ok := forms[0].(*Symbol); ok && symbol.Value == "fn*" {
+		i = 1
+	}
+	if i >= len(forms) {
+		return list, nil
+	}
+	return x.rebuilt(list, forms, i+1, withLocals(locals, forms[i]))
+}
+
+//catch expands the handler of a catch* clause, (catch* kind? e handler...), in which e is local
+func (x *expander) catch(clause *List, locals map[string]bool) (Type, error) {
+	forms := clause.Slice()
+	i := 1
+	if len(forms) > 2 {
+		if _, ok := forms[i].(*Keyword); ok {
+			i++
+		}
+	}
+	if i >= len(forms) {
+		return clause, nil
+	}
+	return x.rebuilt(clause, forms, i+1, withLocals(locals, forms[i]))
+}
+
+//unquoted expands the macro calls in the unquoted parts of a quasiquoted form
+func (x *expander) unquoted(form Type, locals map[string]bool) (Type, error) {
+	list, ok := form.(*List)
+	if !ok || list.Len() == 0 {
+		return form, nil
+	}
+	if symbol, ok := list.First().(*Symbol); ok && !list.IsVector && list.Len() == 2 &&
+		(symbol.Value == "unquote" || symbol.Value == "splice-unquote") {
+		return x.rebuilt(list, list.Slice(), 1, locals)
+	}
+	items := make([]Type, list.Len())
+	for i, item := range list.Slice() {
+		var err error
+		if items[i], err = x.unquoted(item, locals); err != nil {
+			return nil, err
+		}
+	}
+	return withPos(NewList(list.IsVector, items...), list), nil
+}
+
+//withLocals returns locals along with the symbols bound by a pattern of parameters or bindings
+func withLocals(locals map[string]bool, pattern Type) map[string]bool {
+	with := make(map[string]bool, len(locals))
+	for name := range locals {
+		with[name] = true
+	}
+	var walk func(Type)
+	walk = func(form Type) {
+		switch v := form.(type) {
+		case *Symbol:
+			with[v.Value] = true
+		case *List:
+			for _, item := range v.Slice() {
+				walk(item)
+			}
+		case *HashMap:
+			for _, e := range v.Entries() {
+				walk(e.Key)
+				walk(e.Value)
+			}
+		}
+	}
+	walk(pattern)
+	return with
+}
+
+//withPos returns list with the position of the form it was built from
+func withPos(list *List, from *List) *List {
+	list.Pos = from.Pos
+	return list
+}
@@ -0,0 +1,232 @@
+package mal
+
+// Namespaces. Every namespace has an environment of its own for the variables defined in it, whose outer environment
+// is the one of mal.core, which holds the core functions. Forms are analyzed in the current namespace, *ns*, and the
+// global variables they refer to are looked up in it when they run, wherever they are called from. A qualified
+// symbol, e.g. q/x, refers to the variable x of the namespace aliased as q in the namespace, or called q.
+// Private variables can only be referred to from their own namespace
+
+import (
+	"fmt"
+	"strings"
+)
+
+//coreNamespace holds the core functions, which every namespace refers to
+const coreNamespace = "mal.core"
+
+//userNamespace is the namespace forms are evaluated in at first
+const userNamespace = "user"
+
+//nsSymbol is the variable holding the name of the current namespace
+var nsSymbol = &Symbol{Value: "*ns*"}
+
+//namespace holds the variables defined in a namespace and the aliases of other namespaces it uses
+type namespace struct {
+	name    string
+	env     *Env
+	aliases map[string]*namespace
+	private map[string]bool
+	// all namespaces of the interpreter, by name
+	all map[string]*namespace
+}
+
+//namespace returns the namespace called name, which it creates if there is none
+func (in *Interpreter) namespace(name string) *namespace {
+	if ns, ok := in.namespaces[name]; ok {
+		return ns
+	}
+	ns := &namespace{name: name, aliases: make(map[string]*namespace), private: make(map[string]bool), all: in.namespaces}
+	var outer *Env
+	if core, ok := in.namespaces[coreNamespace]; ok {
+		outer = core.env
+	}
+	ns.env = NewEnv(outer, nil, nil)
+	ns.env.ns = ns
+	in.namespaces[name] = ns
+	return ns
+}
+
+//setNamespace makes ns the current namespace
+func (in *Interpreter) setNamespace(ns *namespace) {
+	in.ns = ns
+	in.namespaces[coreNamespace].env.Set(nsSymbol, &Symbol{Value: ns.name})
+}
+
+//inNamespace runs f in ns, and then switches back to the current namespace
+func (in *Interpreter) inNamespace(ns *namespace, f func()) {
+	defer in.setNamespace(in.ns)
+	in.setNamespace(ns)
+	f()
+}
+
+//define binds a variable of the namespace
+func (ns *namespace) define(name *Symbol, value Type, private bool) {
+	ns.env.Set(name, value)
+	if private {
+		ns.private[name.Value] = true
+	} else {
+		delete(ns.private, name.Value)
+	}
+}
+
+//splitSymbol returns the namespace and the name of a qualified symbol
+func splitSymbol(symbol string) (string, string, bool) {
+	i := strings.IndexByte(symbol, '/')
+	if i <= 0 || i == len(symbol)-1 {
+		return "", "", false
+	}
+	return symbol[:i], symbol[i+1:], true
+}
+
+//lookup returns the namespace aliased as, or called, name
+func (ns *namespace) lookup(name string) *namespace {
+	if target, ok := ns.aliases[name]; ok {
+		return target
+	}
+	return ns.all[name]
+}
+
+//getQualified returns the value of a qualified symbol, or nil if it doesn't refer to a variable the namespace can see
+func (ns *namespace) getQualified(symbol string) Type {
+	qualifier, name, ok := splitSymbol(symbol)
+	if !ok {
+		return nil
+	}
+	target := ns.lookup(qualifier)
+	if target == nil || (target != ns && target.private[name]) {
+		return nil
+	}
+	return target.env.data[name]
+}
+
+//notFound is the error of referring to a global variable of ns that doesn't exist or is private
+func (ns *namespace) notFound(name *Symbol) error {
+	if qualifier, local, ok := splitSymbol(name.Value); ok {
+		if target := ns.lookup(qualifier); target != nil && target != ns && target.private[local] {
+			return WithPosition(fmt.Errorf("'%s' is private to %s", name.Value, target.name), name.Pos)
+		}
+	}
+	return WithPosition(fmt.Errorf("'%s' not found", name.Value), name.Pos)
+}
+
+//defName returns the name a def! defines, and whether it is marked private with ^:private or ^{:private true},
+//which the reader turns into (with-meta name meta)
+func defName(form Type) (*Symbol, bool, bool) {
+	if symbol, ok := form.(*Symbol); ok {
+		return symbol, false, true
+	}
+	list, ok := form.(*List)
+	if !ok || list.IsVector || list.Len() != 3 {
+		return nil, false, false
+	}
+	if withMeta, ok := list.First().(*Symbol); !ok || withMeta.Value != "with-meta" {
+		return nil, false, false
+	}
+	symbol, ok := list.Nth(1).(*Symbol)
+	if !ok {
+		return nil, false, false
+	}
+	switch meta := list.Nth(2).(type) {
+	case *Keyword:
+		return symbol, meta.Value == ":private", true
+	case *HashMap:
+		private, _ := meta.Get(&Keyword{Value: ":private"})
+		return symbol, private != nil && isTruthy(private), true
+	}
+	return symbol, false, true
+}
+
+//libPath returns the name of the library that defines the namespace called name, see lib.go: foo.bar-baz is
+//defined in foo/bar-baz.mal
+func libPath(name string) string {
+	return strings.Replace(name, ".", "/", -1) + ".mal"
+}
+
+//require makes a namespace available to the current one, loading it first if it doesn't exist yet. spec is
+//either the name of the namespace, or a vector of its name followed by options: [name :as alias]
+func (in *Interpreter) require(spec Type) error {
+	name, alias, err := requireSpec(spec)
+	if err != nil {
+		return err
+	}
+	target, ok := in.namespaces[name.Value]
+	if !ok {
+		if err := in.loadLib("require", libPath(name.Value)); err != nil {
+			return err
+		}
+		if target, ok = in.namespaces[name.Value]; !ok {
+			return Errorf("require: %s doesn't define the namespace %s", libPath(name.Value), name.Value)
+		}
+	}
+	if alias != nil {
+		in.ns.aliases[alias.Value] = target
+	}
+	return nil
+}
+
+//requireSpec returns the name of the namespace a spec of require refers to, and the alias it gives it, if any
+func requireSpec(spec Type) (*Symbol, *Symbol, error) {
+	if name, ok := spec.(*Symbol); ok {
+		return name, nil, nil
+	}
+	list, _ := spec.(*List)
+	if list == nil || !list.IsVector || list.Len() == 0 || list.Len()%2 == 0 {
+		return nil, nil, Errorf("require: invalid spec %s, expected a symbol or [name :as alias]", PrString(spec, true))
+	}
+	name, ok := list.First().(*Symbol)
+	if !ok {
+		return nil, nil, Errorf("require: invalid spec %s, the name of the namespace must be a symbol", PrString(spec, true))
+	}
+	var alias *Symbol
+	options := list.Slice()[1:]
+	for i := 0; i < len(options); i += 2 {
+		option, _ := options[i].(*Keyword)
+		if option == nil || option.Value != ":as" {
+			return nil, nil, Errorf("require: unknown option %s in %s", PrString(options[i], true), PrString(spec, true))
+		}
+		if alias, ok = options[i+1].(*Symbol); !ok {
+			return nil, nil, Errorf("require: the alias in %s must be a symbol", PrString(spec, true))
+		}
+	}
+	return name, alias, nil
+}
+
+//nsForm expands (ns name (:require specs...)...) into (do (in-ns 'name) (require 'specs...)... nil)
+func nsForm(args ...Type) (Type, error) {
+	name := args[0]
+	forms := []Type{&Symbol{Value: "do"}, NewList(false, &Symbol{Value: "in-ns"}, quoted(name))}
+	for _, clause := range args[1:] {
+		list := clause.(*List)
+		if kind, ok := list.First().(*Keyword); !ok || kind.Value != ":require" {
+			return nil, Errorf("ns: unknown clause %s in the namespace %s, expected (:require ...)", PrString(clause, true), PrString(name, true))
+		}
+		require := []Type{&Symbol{Value: "require"}}
+		for _, spec := range list.Slice()[1:] {
+			require = append(require, quoted(spec))
+		}
+		forms = append(forms, NewList(false, require...))
+	}
+	forms = append(forms, &Nil{})
+	return NewList(false, forms...), nil
+}
+
+//nsMap returns a map of the variables of the namespace called name, by their symbols: all of the ones symbols
+//can refer to in the namespace unqualified, or only its public ones
+func (in *Interpreter) nsMap(fn string, name *Symbol, publicOnly bool) (Type, error) {
+	ns, ok := in.namespaces[name.Value]
+	if !ok {
+		return nil, Errorf("%s: no namespace %s", fn, name.Value)
+	}
+	hmap := NewHashMap()
+	if !publicOnly && ns.env.outer != nil {
+		for k, v := range ns.env.outer.data {
+			hmap.Set(&Symbol{Value: k}, v)
+		}
+	}
+	for k, v := range ns.env.data {
+		if !publicOnly || !ns.private[k] {
+			hmap.Set(&Symbol{Value: k}, v)
+		}
+	}
+	return &hmap, nil
+}
@@ -0,0 +1,434 @@
+package mal
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+//NumberKind tells which representation a Number uses
+type NumberKind int
+
+//The numeric tower, from the least to the most general representation. Arithmetic on two numbers
+//is done in the more general representation of the two, and exact results are narrowed back down
+//as far as possible, e.g. a big integer that fits an int64 again becomes an IntNumber
+const (
+	IntNumber NumberKind = iota
+	BigIntNumber
+	RatioNumber
+	FloatNumber
+)
+
+var errDivideByZero = fmt.Errorf("Divide by zero")
+
+//checkFinite returns an error if f is NaN or infinite, which have no integer value
+func checkFinite(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("Cannot convert %s to an integer", NewFloat(f))
+	}
+	return nil
+}
+
+//NewInt creates an integer
+func NewInt(v int64) *Number {
+	return &Number{Kind: IntNumber, Int: v}
+}
+
+//NewFloat creates a floating point number
+func NewFloat(v float64) *Number {
+	return &Number{Kind: FloatNumber, Float: v}
+}
+
+//NewBigInt creates an integer from a big.Int, which is narrowed to an int64 if it fits
+func NewBigInt(v *big.Int) *Number {
+	if v.IsInt64() {
+		return NewInt(v.Int64())
+	}
+	return &Number{Kind: BigIntNumber, Big: v}
+}
+
+//NewRatio creates an exact ratio, which is narrowed to an integer if its denominator is 1
+func NewRatio(v *big.Rat) *Number {
+	if v.IsInt() {
+		return NewBigInt(new(big.Int).Set(v.Num()))
+	}
+	return &Number{Kind: RatioNumber, Rat: v}
+}
+
+//ParseNumber parses a number literal. Besides decimal integers and floats, hex integers (0x1f),
+//ratios (1/3) and arbitrary precision integers (123N) are supported
+func ParseNumber(s string) (*Number, error) {
+	invalid := fmt.Errorf("invalid number: %s", s)
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 || digits == "" {
+		return nil, invalid
+	}
+	negative := strings.HasPrefix(s, "-")
+
+	if strings.Contains(digits, "/") {
+		r, ok := new(big.Rat).SetString(s)
+		if !ok || strings.ContainsAny(digits, ".eE") {
+			if strings.HasSuffix(digits, "/0") {
+				return nil, errDivideByZero
+			}
+			return nil, invalid
+		}
+		return NewRatio(r), nil
+	}
+
+	base := 10
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		base = 16
+		digits = digits[2:]
+	} else if strings.ContainsAny(digits, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return NewFloat(f), nil
+	}
+	digits = strings.TrimSuffix(digits, "N")
+	if negative {
+		digits = "-" + digits
+	}
+
+	if i, err := strconv.ParseInt(digits, base, 64); err == nil {
+		return NewInt(i), nil
+	}
+	b, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, invalid
+	}
+	return NewBigInt(b), nil
+}
+
+func (n *Number) String() string {
+	switch n.Kind {
+	case BigIntNumber:
+		return n.Big.String()
+	case RatioNumber:
+		return n.Rat.String()
+	case FloatNumber:
+		if math.IsInf(n.Float, 0) || math.IsNaN(n.Float) {
+			return strconv.FormatFloat(n.Float, 'f', -1, 64)
+		}
+		// see https://golang.org/pkg/strconv/#FormatFloat
+		//  'f' (-ddd.dddd, no exponent), unless the exponent is large enough to make that unreadable
+		s := strconv.FormatFloat(n.Float, 'f', -1, 64)
+		if abs := math.Abs(n.Float); abs >= 1e21 || (abs != 0 && abs < 1e-7) {
+			s = strconv.FormatFloat(n.Float, 'e', -1, 64)
+		}
+		// keep floats distinguishable from integers
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return s
+	default:
+		return strconv.FormatInt(n.Int, 10)
+	}
+}
+
+//IsInteger reports whether n is an integer of any size
+func (n *Number) IsInteger() bool {
+	return n.Kind == IntNumber || n.Kind == BigIntNumber
+}
+
+//Float64 returns the value of n as a float64, possibly losing precision
+func (n *Number) Float64() float64 {
+	switch n.Kind {
+	case BigIntNumber:
+		f, _ := new(big.Float).SetInt(n.Big).Float64()
+		return f
+	case RatioNumber:
+		f, _ := n.Rat.Float64()
+		return f
+	case FloatNumber:
+		return n.Float
+	default:
+		return float64(n.Int)
+	}
+}
+
+//Int64 returns the value of n if it is an integer that fits into an int64
+func (n *Number) Int64() (int64, bool) {
+	if n.Kind != IntNumber {
+		return 0, false
+	}
+	return n.Int, true
+}
+
+//bitLen returns the number of bits of the absolute value of an integer, or the larger one of the numerator and
+//the denominator of a ratio, and 64 for a float
+func (n *Number) bitLen() int {
+	switch n.Kind {
+	case IntNumber:
+		abs := uint64(n.Int)
+		if n.Int < 0 {
+			abs = -abs
+		}
+		return bits.Len64(abs)
+	case BigIntNumber:
+		return n.Big.BitLen()
+	case RatioNumber:
+		num, denom := n.Rat.Num().BitLen(), n.Rat.Denom().BitLen()
+		if num > denom {
+			return num
+		}
+		return denom
+	}
+	return 64
+}
+
+func (n *Number) bigInt() *big.Int {
+	if n.Kind == BigIntNumber {
+		return n.Big
+	}
+	return big.NewInt(n.Int)
+}
+
+func (n *Number) rat() *big.Rat {
+	switch n.Kind {
+	case RatioNumber:
+		return n.Rat
+	case BigIntNumber:
+		return new(big.Rat).SetInt(n.Big)
+	default:
+		return new(big.Rat).SetInt64(n.Int)
+	}
+}
+
+//Sign returns -1, 0 or +1 depending on the sign of n
+func (n *Number) Sign() int {
+	switch n.Kind {
+	case BigIntNumber:
+		return n.Big.Sign()
+	case RatioNumber:
+		return n.Rat.Sign()
+	case FloatNumber:
+		if n.Float < 0 {
+			return -1
+		} else if n.Float > 0 {
+			return 1
+		}
+		return 0
+	default:
+		if n.Int < 0 {
+			return -1
+		} else if n.Int > 0 {
+			return 1
+		}
+		return 0
+	}
+}
+
+func maxKind(a *Number, b *Number) NumberKind {
+	if a.Kind > b.Kind {
+		return a.Kind
+	}
+	return b.Kind
+}
+
+//Add returns n + o
+func (n *Number) Add(o *Number) *Number {
+	switch maxKind(n, o) {
+	case IntNumber:
+		s := n.Int + o.Int
+		// overflow iff both operands have the same sign and the result's sign differs
+		if (s > n.Int) == (o.Int > 0) {
+			return NewInt(s)
+		}
+		return NewBigInt(new(big.Int).Add(n.bigInt(), o.bigInt()))
+	case BigIntNumber:
+		return NewBigInt(new(big.Int).Add(n.bigInt(), o.bigInt()))
+	case RatioNumber:
+		return NewRatio(new(big.Rat).Add(n.rat(), o.rat()))
+	default:
+		return NewFloat(n.Float64() + o.Float64())
+	}
+}
+
+//Neg returns -n
+func (n *Number) Neg() *Number {
+	switch n.Kind {
+	case IntNumber:
+		if n.Int != math.MinInt64 {
+			return NewInt(-n.Int)
+		}
+		return NewBigInt(new(big.Int).Neg(n.bigInt()))
+	case BigIntNumber:
+		return NewBigInt(new(big.Int).Neg(n.Big))
+	case RatioNumber:
+		return NewRatio(new(big.Rat).Neg(n.Rat))
+	default:
+		return NewFloat(-n.Float)
+	}
+}
+
+//Sub returns n - o
+func (n *Number) Sub(o *Number) *Number {
+	switch maxKind(n, o) {
+	case IntNumber:
+		d := n.Int - o.Int
+		if (d < n.Int) == (o.Int > 0) {
+			return NewInt(d)
+		}
+		return NewBigInt(new(big.Int).Sub(n.bigInt(), o.bigInt()))
+	case BigIntNumber:
+		return NewBigInt(new(big.Int).Sub(n.bigInt(), o.bigInt()))
+	case RatioNumber:
+		return NewRatio(new(big.Rat).Sub(n.rat(), o.rat()))
+	default:
+		return NewFloat(n.Float64() - o.Float64())
+	}
+}
+
+//Mul returns n * o
+func (n *Number) Mul(o *Number) *Number {
+	switch maxKind(n, o) {
+	case IntNumber:
+		if n.Int == 0 || o.Int == 0 {
+			return NewInt(0)
+		}
+		p := n.Int * o.Int
+		if p/o.Int == n.Int && !(n.Int == -1 && o.Int == math.MinInt64) && !(o.Int == -1 && n.Int == math.MinInt64) {
+			return NewInt(p)
+		}
+		return NewBigInt(new(big.Int).Mul(n.bigInt(), o.bigInt()))
+	case BigIntNumber:
+		return NewBigInt(new(big.Int).Mul(n.bigInt(), o.bigInt()))
+	case RatioNumber:
+		return NewRatio(new(big.Rat).Mul(n.rat(), o.rat()))
+	default:
+		return NewFloat(n.Float64() * o.Float64())
+	}
+}
+
+//Div returns n / o. Dividing exact numbers gives an exact result, i.e. an integer or a ratio
+func (n *Number) Div(o *Number) (*Number, error) {
+	if maxKind(n, o) == FloatNumber {
+		return NewFloat(n.Float64() / o.Float64()), nil
+	}
+	if o.Sign() == 0 {
+		return nil, errDivideByZero
+	}
+	if n.Kind == IntNumber && o.Kind == IntNumber && o.Int != -1 && n.Int%o.Int == 0 {
+		return NewInt(n.Int / o.Int), nil
+	}
+	return NewRatio(new(big.Rat).Quo(n.rat(), o.rat())), nil
+}
+
+//Quot returns n / o rounded towards zero
+func (n *Number) Quot(o *Number) (*Number, error) {
+	if maxKind(n, o) == FloatNumber {
+		if o.Float64() == 0 {
+			return nil, errDivideByZero
+		}
+		q := n.Float64() / o.Float64()
+		if err := checkFinite(q); err != nil {
+			return nil, err
+		}
+		return NewFloat(math.Trunc(q)), nil
+	}
+	if o.Sign() == 0 {
+		return nil, errDivideByZero
+	}
+	if n.Kind == IntNumber && o.Kind == IntNumber && o.Int != -1 {
+		return NewInt(n.Int / o.Int), nil
+	}
+	q := new(big.Rat).Quo(n.rat(), o.rat())
+	return NewBigInt(new(big.Int).Quo(q.Num(), q.Denom())), nil
+}
+
+//Rem returns the remainder of Quot, which has the sign of n
+func (n *Number) Rem(o *Number) (*Number, error) {
+	if maxKind(n, o) == FloatNumber {
+		if o.Float64() == 0 {
+			return nil, errDivideByZero
+		}
+		if err := checkFinite(n.Float64() / o.Float64()); err != nil {
+			return nil, err
+		}
+		return NewFloat(math.Mod(n.Float64(), o.Float64())), nil
+	}
+	q, err := n.Quot(o)
+	if err != nil {
+		return nil, err
+	}
+	return n.Sub(q.Mul(o)), nil
+}
+
+//Mod returns n modulo o, which has the sign of o
+func (n *Number) Mod(o *Number) (*Number, error) {
+	r, err := n.Rem(o)
+	if err != nil {
+		return nil, err
+	}
+	if r.Sign() != 0 && r.Sign() != o.Sign() {
+		return r.Add(o), nil
+	}
+	return r, nil
+}
+
+//Cmp compares n and o and returns -1, 0 or +1
+func (n *Number) Cmp(o *Number) int {
+	switch maxKind(n, o) {
+	case IntNumber:
+		if n.Int < o.Int {
+			return -1
+		} else if n.Int > o.Int {
+			return 1
+		}
+		return 0
+	case BigIntNumber:
+		return n.bigInt().Cmp(o.bigInt())
+	case RatioNumber:
+		return n.rat().Cmp(o.rat())
+	default:
+		a, b := n.Float64(), o.Float64()
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	}
+}
+
+//Truncate returns n rounded towards zero to an integer, or an error if n is NaN or infinite
+func (n *Number) Truncate() (*Number, error) {
+	switch n.Kind {
+	case RatioNumber:
+		return NewBigInt(new(big.Int).Quo(n.Rat.Num(), n.Rat.Denom())), nil
+	case FloatNumber:
+		if err := checkFinite(n.Float); err != nil {
+			return nil, err
+		}
+		t := math.Trunc(n.Float)
+		if t >= math.MinInt64 && t < math.MaxInt64 {
+			return NewInt(int64(t)), nil
+		}
+		b, _ := big.NewFloat(t).Int(nil)
+		return NewBigInt(b), nil
+	default:
+		return n, nil
+	}
+}
+
+//Numerator returns the numerator of an exact number
+func (n *Number) Numerator() *Number {
+	if n.Kind == RatioNumber {
+		return NewBigInt(new(big.Int).Set(n.Rat.Num()))
+	}
+	return n
+}
+
+//Denominator returns the denominator of an exact number
+func (n *Number) Denominator() *Number {
+	if n.Kind == RatioNumber {
+		return NewBigInt(new(big.Int).Set(n.Rat.Denom()))
+	}
+	return NewInt(1)
+}
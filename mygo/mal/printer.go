@@ -2,14 +2,26 @@ package mal
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 )
 
 //PrString takes a MalType and returns a string representation
 func PrString(ast Type, readably bool) string {
 	var sb strings.Builder
+	printForm(&sb, ast, readably, 0)
+	return sb.String()
+}
 
+//printForm writes the representation of ast, which is nested depth levels deep, to sb. Values nested deeper than
+//MaxNesting are printed as #, like clojure does beyond *print-level*
+func printForm(sb *strings.Builder, ast Type, readably bool, depth int) {
+	switch ast.(type) {
+	case *List, *LazySeq, *HashMap, *Set, *Atom, *ExInfo:
+		if depth >= MaxNesting {
+			sb.WriteString("#")
+			return
+		}
+	}
 	switch v := ast.(type) {
 	case *List:
 		if v.IsVector {
@@ -17,9 +29,10 @@ func PrString(ast Type, readably bool) string {
 		} else {
 			sb.WriteString("(")
 		}
-		for i, vel := range v.Value {
-			sb.WriteString(printAtom(vel, readably))
-			if i < len(v.Value)-1 {
+		items := v.Slice()
+		for i, vel := range items {
+			printForm(sb, vel, readably, depth+1)
+			if i < len(items)-1 {
 				sb.WriteString(" ")
 			}
 		}
@@ -28,28 +41,49 @@ func PrString(ast Type, readably bool) string {
 		} else {
 			sb.WriteString(")")
 		}
+	case *LazySeq:
+		sb.WriteString("(")
+		for i, vel := range lazyElements(v) {
+			if i > 0 {
+				sb.WriteString(" ")
+			}
+			printForm(sb, vel, readably, depth+1)
+		}
+		sb.WriteString(")")
 	case *HashMap:
 		sb.WriteString("{")
-		i := 0
-		for key, vel := range v.Value {
-			if strings.HasPrefix(key, ":") {
-				sb.WriteString(printAtom(&Keyword{Value: key}, readably))
-			} else {
-				sb.WriteString(printAtom(&String{Value: key}, readably))
-			}
+		for i, e := range v.Entries() {
+			printForm(sb, e.Key, readably, depth+1)
 			sb.WriteString(" ")
-			sb.WriteString(printAtom(vel, readably))
-			if i < len(v.Value)-1 {
+			printForm(sb, e.Value, readably, depth+1)
+			if i < v.Len()-1 {
+				sb.WriteString(" ")
+			}
+		}
+		sb.WriteString("}")
+	case *Set:
+		sb.WriteString("#{")
+		elements := v.Slice()
+		for i, vel := range elements {
+			printForm(sb, vel, readably, depth+1)
+			if i < len(elements)-1 {
 				sb.WriteString(" ")
 			}
-			i++
 		}
 		sb.WriteString("}")
+	case *Atom:
+		sb.WriteString("(atom ")
+		printForm(sb, v.Value, readably, depth+1)
+		sb.WriteString(")")
+	case *ExInfo:
+		sb.WriteString("#<ex-info ")
+		printForm(sb, &String{Value: v.Message}, readably, depth+1)
+		sb.WriteString(" ")
+		printForm(sb, v.Data, readably, depth+1)
+		sb.WriteString(">")
 	default:
 		sb.WriteString(printAtom(v, readably))
-
 	}
-	return sb.String()
 }
 
 func printAtom(atom Type, readably bool) string {
@@ -57,13 +91,13 @@ func printAtom(atom Type, readably bool) string {
 	case *Symbol:
 		return v.Value
 	case *Number:
-		// see https://golang.org/pkg/strconv/#FormatFloat
-		//  'f' (-ddd.dddd, no exponent)
-		return strconv.FormatFloat(v.Value, 'f', -1, 64)
-	case *List:
-		return PrString(v, readably)
-	case *HashMap:
-		return PrString(v, readably)
+		return v.String()
+	case *Regex:
+		s := v.Value.String()
+		if readably {
+			s = "#\"" + strings.Replace(s, "\"", "\\\"", -1) + "\""
+		}
+		return s
 	case *Boolean:
 		if v.Value {
 			return "true"
@@ -79,12 +113,6 @@ func printAtom(atom Type, readably bool) string {
 			s = WriteString(s)
 		}
 		return s
-	case *Atom:
-		var sb strings.Builder
-		sb.WriteString("(atom ")
-		sb.WriteString(printAtom(v.Value, readably))
-		sb.WriteString(")")
-		return sb.String()
 	case *Keyword:
 		return v.Value
 
@@ -2,6 +2,7 @@ package mal
 
 import (
 	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
@@ -9,195 +10,358 @@ import (
 
 //Reader reads lisp for tokenization and parsing
 type Reader struct {
-	toks []string
-	pos  int
+	lex    *lexer
+	tok    token
+	peeked bool
+
+	inAnonymousFn bool
+	// the number of forms being read that the one read next is nested in, see MaxNesting
+	depth int
 }
 
-func (reader *Reader) next() (val string, eof bool) {
-	cur := reader.pos
-	reader.pos++
-	if cur >= len(reader.toks) {
-		return "", true
-	}
-	return reader.toks[cur], false
+func (reader *Reader) next() (token, error) {
+	tok, err := reader.peek()
+	reader.peeked = false
+	return tok, err
 }
 
-func (reader *Reader) peek() (val string, eof bool) {
-	if reader.pos >= len(reader.toks) {
-		return "", true
+func (reader *Reader) peek() (token, error) {
+	for !reader.peeked {
+		tok, err := reader.lex.next()
+		if err != nil {
+			return token{}, err
+		}
+		if tok.kind == tokDiscard {
+			//#_ discards the form that follows it, wherever it appears
+			if _, err := readForm(reader); err != nil {
+				return token{}, err
+			}
+			continue
+		}
+		reader.tok = tok
+		reader.peeked = true
 	}
-	return reader.toks[reader.pos], false
+	return reader.tok, nil
 }
 
-//NewReader Creates a new Reader instance from a list of tokens
-func NewReader(toks []string) *Reader {
-	r := Reader{toks: toks, pos: 0}
+//NewReader Creates a new Reader instance that lazily reads forms from in. filename is recorded in
+//the source position of every list and symbol, and may be empty
+func NewReader(in io.Reader, filename string) *Reader {
+	r := Reader{lex: newLexer(in, filename)}
 	return &r
 }
 
-//ReadStr parses a given string into an AST
-func ReadStr(s string) (Type, error) {
-	toks := tokenize(s)
-	reader := NewReader(toks)
+//ReadForm reads the next form. io.EOF is returned once the input contains no more forms
+func (reader *Reader) ReadForm() (Type, error) {
+	tok, err := reader.peek()
+	if err != nil {
+		return nil, err
+	}
+	if tok.kind == tokEOF {
+		return nil, io.EOF
+	}
 	return readForm(reader)
 }
 
-func tokenize(s string) []string {
-	re := regexp.MustCompile(`[\s,]*(~@|[\[\]{}()'` + "`" +
-		`~^@]|"(?:\\.|[^\\"])*"?|;.*|[^\s\[\]{}('"` + "`" +
-		`,;)]*)`)
-	matches := re.FindAllStringSubmatch(s, -1)
-	if matches == nil {
-		return make([]string, 0)
-	}
-	res := make([]string, len(matches))
-	for i := range matches {
-		res[i] = matches[i][1] // 0 is the original string, we only want the submatch
+//ReadAll reads all remaining forms
+func (reader *Reader) ReadAll() ([]Type, error) {
+	forms := make([]Type, 0)
+	for {
+		form, err := reader.ReadForm()
+		if err == io.EOF {
+			return forms, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		forms = append(forms, form)
 	}
-	return res
 }
 
-func readForm(reader *Reader) (Type, error) {
-	p, eof := reader.peek()
+//ReadAll parses all forms in a given string
+func ReadAll(s string) ([]Type, error) {
+	return NewReader(strings.NewReader(s), "").ReadAll()
+}
+
+//ReadStr parses a given string into an AST
+func ReadStr(s string) (Type, error) {
+	return ReadStrFile(s, "")
+}
 
-	if eof {
+//ReadStrFile parses a given string into an AST, recording filename in the source position of every list and symbol
+func ReadStrFile(s string, filename string) (Type, error) {
+	reader := NewReader(strings.NewReader(s), filename)
+	v, err := reader.ReadForm()
+	if err == io.EOF {
 		return nil, nil
 	}
+	return v, err
+}
 
-	switch p {
-	case "(":
-		reader.next()
-		v, err := readList(reader)
-		if err != nil {
-			return nil, err
-		}
-		return v, nil
-	case "[":
-		reader.next()
-		v, err := readVector(reader)
-		if err != nil {
-			return nil, err
-		}
-		return v, nil
-	case "{":
-		reader.next()
-		v, err := readHashmap(reader)
-		if err != nil {
-			return nil, err
-		}
-		return v, nil
+func readForm(reader *Reader) (Type, error) {
+	tok, err := reader.next()
+	if err != nil {
+		return nil, err
+	}
+
+	pos := &tok.pos
+	if reader.depth >= MaxNesting {
+		return nil, WithPosition(nestingError(), pos)
+	}
+	reader.depth++
+	defer func() { reader.depth-- }()
+	switch tok.kind {
+	case tokEOF:
+		return nil, WithPosition(&IncompleteError{Msg: "expected a form, got EOF"}, pos)
+	case tokLParen:
+		return readList(reader, pos)
+	case tokLBracket:
+		return readVector(reader, pos)
+	case tokLBrace:
+		return readHashmap(reader, pos)
+	case tokSetStart:
+		return readSet(reader, pos)
+	case tokFnStart:
+		return readAnonymousFn(reader, pos)
+	case tokRParen, tokRBracket, tokRBrace:
+		return nil, WithPosition(fmt.Errorf("unexpected '%s'", tok.val), pos)
 	default:
-		v, err := readAtom(reader)
-		if err != nil {
-			return nil, err
-		}
-		return v, nil
+		return readAtom(reader, tok)
 	}
 }
 
-func readList(reader *Reader) (Type, error) {
-	list := NewList(false)
+func readList(reader *Reader, pos *Position) (Type, error) {
+	var items []Type
 	for {
-		peek, eof := reader.peek()
-		if peek != ")" && !eof {
+		peek, err := reader.peek()
+		if err != nil {
+			return nil, err
+		}
+		if peek.kind != tokRParen && peek.kind != tokEOF {
 			v, err := readForm(reader)
 			if err != nil {
 				return nil, err
 			}
-			list.Value = append(list.Value, v)
-		} else if eof {
-			return nil, fmt.Errorf("unbalanced parenthesis in list, expected ')'")
+			items = append(items, v)
+		} else if peek.kind == tokEOF {
+			return nil, WithPosition(&IncompleteError{Msg: "unbalanced parenthesis in list, expected ')'"}, pos)
 		} else {
 			reader.next()
-			return &list, nil
+			list := NewList(false, items...)
+			list.Pos = pos
+			return list, nil
 		}
 
 	}
 }
 
-func readVector(reader *Reader) (Type, error) {
-	vector := NewList(true)
+func readVector(reader *Reader, pos *Position) (Type, error) {
+	var items []Type
 	for {
-		peek, eof := reader.peek()
-		if peek != "]" && !eof {
+		peek, err := reader.peek()
+		if err != nil {
+			return nil, err
+		}
+		if peek.kind != tokRBracket && peek.kind != tokEOF {
 			v, err := readForm(reader)
 			if err != nil {
 				return nil, err
 			}
-			vector.Value = append(vector.Value, v)
-		} else if eof {
-			return nil, fmt.Errorf("unbalanced parenthesis in vector, expected ']'")
+			items = append(items, v)
+		} else if peek.kind == tokEOF {
+			return nil, WithPosition(&IncompleteError{Msg: "unbalanced parenthesis in vector, expected ']'"}, pos)
 		} else {
 			reader.next()
-			return &vector, nil
+			vector := NewList(true, items...)
+			vector.Pos = pos
+			return vector, nil
 		}
 
 	}
 }
 
-func readHashmap(reader *Reader) (Type, error) {
-	hmap := NewHashMap()
+//readHashmap reads a map literal. Its keys are only checked for duplicates if they are constants, since keys
+//that are evaluated, like (f), may have different values each time
+func readHashmap(reader *Reader, pos *Position) (Type, error) {
+	var forms []Type
 	for {
-		peek, eof := reader.peek()
-		if peek != "}" && !eof {
+		peek, err := reader.peek()
+		if err != nil {
+			return nil, err
+		}
+		if peek.kind != tokRBrace && peek.kind != tokEOF {
 			key, err := readForm(reader)
 			if err != nil {
 				return nil, err
 			}
+			if peek, err := reader.peek(); err == nil && peek.kind == tokRBrace {
+				return nil, WithPosition(fmt.Errorf("hash map literal must contain an even number of forms"), pos)
+			}
 			value, err := readForm(reader)
 			if err != nil {
 				return nil, err
 			}
-			strKey, err := TypeToHashKey(key)
+			if isConstantForm(key) {
+				for i := 0; i < len(forms); i += 2 {
+					if equals(forms[i], key) {
+						return nil, WithPosition(duplicateKeyError(key), pos)
+					}
+				}
+			}
+			forms = append(forms, key, value)
+
+		} else if peek.kind == tokEOF {
+			return nil, WithPosition(&IncompleteError{Msg: "unbalanced parenthesis in hash map, expected '}'"}, pos)
+		} else {
+			reader.next()
+			return mapLiteral(forms), nil
+		}
+
+	}
+}
+
+//isConstantForm tells whether form evaluates to itself: it contains neither symbols nor calls
+func isConstantForm(form Type) bool {
+	switch v := form.(type) {
+	case *Symbol:
+		return false
+	case *List:
+		if !v.IsVector && v.Len() > 0 {
+			return false
+		}
+		for _, item := range v.Slice() {
+			if !isConstantForm(item) {
+				return false
+			}
+		}
+	case *HashMap:
+		for _, item := range v.forms() {
+			if !isConstantForm(item) {
+				return false
+			}
+		}
+	case *Set:
+		for _, item := range v.Slice() {
+			if !isConstantForm(item) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func readSet(reader *Reader, pos *Position) (Type, error) {
+	set := NewSet()
+	for {
+		peek, err := reader.peek()
+		if err != nil {
+			return nil, err
+		}
+		if peek.kind != tokRBrace && peek.kind != tokEOF {
+			v, err := readForm(reader)
 			if err != nil {
 				return nil, err
 			}
-			hmap.Value[strKey] = value
-
-		} else if eof {
-			return nil, fmt.Errorf("unbalanced parenthesis in hash map, expected '}'")
+			if set.Contains(v) {
+				return nil, WithPosition(fmt.Errorf("duplicate element in set literal: %s", PrString(v, true)), pos)
+			}
+			set.Add(v)
+		} else if peek.kind == tokEOF {
+			return nil, WithPosition(&IncompleteError{Msg: "unbalanced parenthesis in set, expected '}'"}, pos)
 		} else {
 			reader.next()
-			return &hmap, nil
+			return &set, nil
 		}
 
 	}
 }
 
-func readAtom(reader *Reader) (Type, error) {
-	val, eof := reader.next()
-	if eof {
-		return nil, fmt.Errorf("Tried to read atom, but reached EOF")
+//readAnonymousFn reads #(...), e.g. #(+ % %2), and turns it into (fn* (%1 %2) (+ %1 %2)).
+//%& refers to the rest of the arguments
+func readAnonymousFn(reader *Reader, pos *Position) (Type, error) {
+	if reader.inAnonymousFn {
+		return nil, WithPosition(fmt.Errorf("nested #()s are not allowed"), pos)
 	}
+	reader.inAnonymousFn = true
+	body, err := readList(reader, pos)
+	reader.inAnonymousFn = false
+	if err != nil {
+		return nil, err
+	}
+
+	arity, variadic := 0, false
+	body = replaceFnArgs(body, &arity, &variadic)
 
-	if strings.HasPrefix(val, ";") { // comment: skip
-		return readForm(reader)
+	var params []Type
+	for i := 1; i <= arity; i++ {
+		params = append(params, &Symbol{Value: "%" + strconv.Itoa(i), Pos: pos})
+	}
+	if variadic {
+		params = append(params, &Symbol{Value: "&", Pos: pos}, &Symbol{Value: "%&", Pos: pos})
 	}
+	fn := NewList(false, &Symbol{Value: "fn*", Pos: pos}, NewList(false, params...), body)
+	fn.Pos = pos
+	return fn, nil
+}
 
-	if asNumber, err := strconv.ParseFloat(val, 64); err == nil {
-		return &Number{Value: asNumber}, nil
+//replaceFnArgs replaces % with %1 in the body of #(...), recording the highest argument number
+//used and whether %& is used
+func replaceFnArgs(ast Type, arity *int, variadic *bool) Type {
+	switch v := ast.(type) {
+	case *Symbol:
+		switch {
+		case v.Value == "%":
+			if *arity < 1 {
+				*arity = 1
+			}
+			return &Symbol{Value: "%1", Pos: v.Pos}
+		case v.Value == "%&":
+			*variadic = true
+		case strings.HasPrefix(v.Value, "%"):
+			if n, err := strconv.Atoi(v.Value[1:]); err == nil && n > *arity {
+				*arity = n
+			}
+		}
+		return v
+	case *List:
+		items := make([]Type, v.Len())
+		for i, el := range v.Slice() {
+			items[i] = replaceFnArgs(el, arity, variadic)
+		}
+		list := NewList(v.IsVector, items...)
+		list.Pos = v.Pos
+		return list
+	case *HashMap:
+		forms := v.forms()
+		replaced := make([]Type, len(forms))
+		for i, form := range forms {
+			replaced[i] = replaceFnArgs(form, arity, variadic)
+		}
+		return mapLiteral(replaced)
+	case *Set:
+		set := NewSet()
+		for _, el := range v.Slice() {
+			set.Add(replaceFnArgs(el, arity, variadic))
+		}
+		return &set
 	}
+	return ast
+}
 
-	switch val {
-	case "true":
-		return &Boolean{Value: true}, nil
-	case "false":
-		return &Boolean{Value: false}, nil
-	case "nil":
-		return &Nil{}, nil
-	case "@":
-		return readerMacroExpand(reader, "deref")
-	case "'":
-		return readerMacroExpand(reader, "quote")
-	case "~":
-		return readerMacroExpand(reader, "unquote")
-	case "~@":
-		return readerMacroExpand(reader, "splice-unquote")
-	case "`":
-		return readerMacroExpand(reader, "quasiquote")
-	case "^":
-		list := NewList(false)
-		list.Value = append(list.Value, &Symbol{Value: "with-meta"})
+func readAtom(reader *Reader, tok token) (Type, error) {
+	pos := &tok.pos
+	switch tok.kind {
+	case tokDeref:
+		return readerMacroExpand(reader, "deref", pos)
+	case tokQuote:
+		return readerMacroExpand(reader, "quote", pos)
+	case tokUnquote:
+		return readerMacroExpand(reader, "unquote", pos)
+	case tokSpliceUnquote:
+		return readerMacroExpand(reader, "splice-unquote", pos)
+	case tokQuasiquote:
+		return readerMacroExpand(reader, "quasiquote", pos)
+	case tokMeta:
 		v1, err := readForm(reader)
 		if err != nil {
 			return nil, err
@@ -207,33 +371,48 @@ func readAtom(reader *Reader) (Type, error) {
 			return nil, err
 		}
 		//append the next two forms in reverse order
-		list.Value = append(list.Value, v2)
-		list.Value = append(list.Value, v1)
-		return &list, nil
-	}
-
-	if strings.Contains(val, "\"") {
-		s, err := ReadString(val)
+		list := NewList(false, &Symbol{Value: "with-meta", Pos: pos}, v2, v1)
+		list.Pos = pos
+		return list, nil
+	case tokString:
+		s, err := ReadString(tok.val)
 		if err != nil {
-			return nil, err
+			return nil, WithPosition(err, pos)
 		}
 		return &String{Value: s}, nil
+	case tokRegex:
+		re, err := regexp.Compile(readRegex(tok.val))
+		if err != nil {
+			return nil, WithPosition(err, pos)
+		}
+		return &Regex{Value: re}, nil
+	case tokNumber:
+		n, err := ParseNumber(tok.val)
+		if err != nil {
+			return nil, WithPosition(err, pos)
+		}
+		return n, nil
+	case tokKeyword:
+		return &Keyword{Value: tok.val}, nil
 	}
 
-	if strings.HasPrefix(val, ":") {
-		return &Keyword{Value: val}, nil
+	switch tok.val {
+	case "true":
+		return &Boolean{Value: true}, nil
+	case "false":
+		return &Boolean{Value: false}, nil
+	case "nil":
+		return &Nil{}, nil
 	}
-
-	return &Symbol{Value: val}, nil
+	return &Symbol{Value: tok.val, Pos: pos}, nil
 }
 
-func readerMacroExpand(reader *Reader, symbolName string) (*List, error) {
-	list := NewList(false)
-	list.Value = append(list.Value, &Symbol{Value: symbolName})
+func readerMacroExpand(reader *Reader, symbolName string, pos *Position) (*List, error) {
 	v, err := readForm(reader)
 	if err != nil {
 		return nil, err
 	}
-	list.Value = append(list.Value, v)
-	return &list, nil
+	list := NewList(false, &Symbol{Value: symbolName, Pos: pos}, v)
+	list.Pos = pos
+	return list, nil
 }
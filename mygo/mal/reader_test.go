@@ -0,0 +1,60 @@
+package mal
+
+import (
+	"testing"
+)
+
+func TestReadPositions(t *testing.T) {
+	form, err := ReadStrFile("(a\n  (b c)\n  [d\n   e])", "test.mal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := form.(*List)
+	inner := list.Nth(1).(*List)
+	vector := list.Nth(2).(*List)
+	tests := []struct {
+		form Type
+		want string
+	}{
+		{list, "test.mal:1:1"},
+		{list.Nth(0), "test.mal:1:2"},
+		{inner, "test.mal:2:3"},
+		{inner.Nth(1), "test.mal:2:6"},
+		{vector, "test.mal:3:3"},
+		{vector.Nth(1), "test.mal:4:4"},
+	}
+	for _, test := range tests {
+		pos := PositionOf(test.form)
+		if pos == nil {
+			t.Errorf("%s has no position, want %s", PrString(test.form, true), test.want)
+		} else if pos.String() != test.want {
+			t.Errorf("%s is at %s, want %s", PrString(test.form, true), pos, test.want)
+		}
+	}
+}
+
+func TestReadErrorPositions(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"(a\n  (b c)\n  [d", "test.mal:3:3: unbalanced parenthesis in vector, expected ']'"},
+		{"{:a\n  (1 2", "test.mal:2:3: unbalanced parenthesis in list, expected ')'"},
+		{"(a\n  \"b", "test.mal:2:3: unbalanced \", expected '\"', got EOF"},
+		{"(a\n  (b\n    #", "test.mal:3:5: expected a dispatch character after #, got EOF"},
+	}
+	for _, test := range tests {
+		_, err := ReadStrFile(test.src, "test.mal")
+		if err == nil || err.Error() != test.want {
+			t.Errorf("reading %q: got error %v, want %s", test.src, err, test.want)
+		}
+	}
+}
+
+func TestReadWithoutFile(t *testing.T) {
+	// forms typed into the REPL have positions, but errors don't report them
+	_, err := ReadStr("(a\n  [b")
+	if err == nil || err.Error() != "unbalanced parenthesis in vector, expected ']'" {
+		t.Errorf("got error %v, want one without a position", err)
+	}
+}
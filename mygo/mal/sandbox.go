@@ -0,0 +1,198 @@
+package mal
+
+// Sandboxing of interpreters that run untrusted code. A profile determines which of the native functions that
+// reach outside of the interpreter are installed into its environment, and limits on the number of steps of an
+// evaluation and on the size of the collections and numbers the native functions return keep a form from running
+// forever or from using up the memory. They raise errors mal code can catch, like any other. Code in a sandbox
+// can't raise *max-eval-depth* either, which bounds lazy sequences computed from one another as well, and the
+// collections it builds can't be nested deeper than MaxNesting, like anywhere else
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+//Profile is a named set of capabilities, which determine the native functions an interpreter has
+type Profile string
+
+const (
+	//ProfileFull has all native functions
+	ProfileFull Profile = "full"
+	//ProfileReadOnlyFS can read the files in Options.Root, with slurp, load-file, load-lib and require, but not the
+	//standard input
+	ProfileReadOnlyFS Profile = "read-only-fs"
+	//ProfilePure can neither read files nor the standard input, but can load the standard library. It can still
+	//print to Options.Stdout
+	ProfilePure Profile = "pure"
+)
+
+//ParseProfile returns the profile called name
+func ParseProfile(name string) (Profile, error) {
+	switch p := Profile(name); p {
+	case ProfileFull, ProfileReadOnlyFS, ProfilePure:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown profile %q, expected %s, %s or %s", name, ProfileFull, ProfileReadOnlyFS, ProfilePure)
+}
+
+type capability int
+
+const (
+	capReadFiles capability = 1 << iota
+	capStdin
+)
+
+//nativeCapabilities holds the capabilities native functions need, the others need none. Without capReadFiles,
+//load-file, load-lib and require can still load the standard library, see lib.go
+var nativeCapabilities = map[string]capability{
+	"slurp":    capReadFiles,
+	"readline": capStdin,
+}
+
+func (p Profile) capabilities() capability {
+	switch p {
+	case ProfilePure:
+		return 0
+	case ProfileReadOnlyFS:
+		return capReadFiles
+	}
+	return capReadFiles | capStdin
+}
+
+//allows tells whether the native function called name may be installed into an interpreter with profile p
+func (p Profile) allows(name string) bool {
+	need := nativeCapabilities[name]
+	return p.capabilities()&need == need
+}
+
+//rootDir returns the directory a profile confines file access to, with symbolic links resolved, or "" for none
+func rootDir(p Profile, root string) string {
+	if p != ProfileReadOnlyFS {
+		return ""
+	}
+	if root == "" {
+		root = "."
+	}
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		root = real
+	}
+	return root
+}
+
+//filePath returns the path of the file called name that the native function called fn may read. If the
+//interpreter has a root directory, names are relative to it, and files outside of it can't be read
+func (in *Interpreter) filePath(fn string, name string) (string, error) {
+	if in.root == "" {
+		return name, nil
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(in.root, path)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", Errorf("%s: can't read %s: %v", fn, name, err)
+	}
+	rel, err := filepath.Rel(in.root, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", Errorf("%s: can't read %s, it is outside of %s", fn, name, in.root)
+	}
+	return real, nil
+}
+
+//depthLimit returns the maximum *max-eval-depth* of an interpreter with profile p whose initial one is maxDepth.
+//Code run with ProfileFull may raise it up to MaxEvalDepthLimit, code in a sandbox may only lower it
+func depthLimit(p Profile, maxDepth int) int {
+	if p == ProfileFull || maxDepth <= 0 || maxDepth > MaxEvalDepthLimit {
+		return MaxEvalDepthLimit
+	}
+	return maxDepth
+}
+
+//generators are the native functions that return lazy sequences that may be infinite
+var generators = map[string]bool{"range": true, "repeat": true, "cycle": true, "iterate": true}
+
+//limited returns fn, or, if the interpreter limits the size of collections or numbers, a function that fails
+//instead of returning a larger collection or number than that. The lazy sequences of generators fail once they
+//are realized that far
+func (in *Interpreter) limited(fn *Function) *Function {
+	if in.maxCollectionSize == 0 && in.maxNumberBits == 0 {
+		return fn
+	}
+	limited := CopyOfFunction(fn)
+	limited.Fn = func(args ...Type) (Type, error) {
+		res, err := fn.Fn(args...)
+		if err != nil {
+			return nil, err
+		}
+		size := 0
+		switch v := res.(type) {
+		case *List:
+			size = v.Len()
+		case *HashMap:
+			size = v.Len()
+		case *Set:
+			size = v.Len()
+		case *String:
+			size = len(v.Value)
+		case *Number:
+			if in.maxNumberBits > 0 && v.bitLen() > in.maxNumberBits {
+				return nil, Errorf("%s: the size of numbers is limited to %d bits", fn.Name, in.maxNumberBits)
+			}
+		case *LazySeq:
+			if generators[fn.Name] && in.maxCollectionSize > 0 {
+				return boundedLazy(fn.Name, v, in.maxCollectionSize, in.maxCollectionSize), nil
+			}
+		}
+		if in.maxCollectionSize > 0 && size > in.maxCollectionSize {
+			return nil, sizeError(fn.Name, in.maxCollectionSize)
+		}
+		return res, nil
+	}
+	return limited
+}
+
+//boundedLazy returns a lazy sequence of the elements of seq that fails if it has more than left elements
+func boundedLazy(name string, seq Type, max int, left int) *LazySeq {
+	return NewLazySeq(func() (Type, error) {
+		first, rest, ok, err := uncons(seq)
+		if err != nil || !ok {
+			return NewList(false), err
+		}
+		if left == 0 {
+			return nil, sizeError(name, max)
+		}
+		return lazyCons(first, boundedLazy(name, rest, max, left-1)), nil
+	})
+}
+
+func sizeError(name string, max int) error {
+	return Errorf("%s: the size of collections is limited to %d elements", name, max)
+}
+
+//maxSteps returns the maximum number of steps of Options.MaxSteps, which Interpreter.steps never reaches if
+//there is no limit
+func maxSteps(limit int) int {
+	if limit <= 0 {
+		return int(^uint(0) >> 1)
+	}
+	return limit
+}
+
+//maxNumberBits returns the maximum number of bits of numbers, which is limit, or if limit is 0, 64 bits per
+//element of the largest collection, so that numbers can't take up more memory than collections
+func maxNumberBits(limit int, maxCollectionSize int) int {
+	if limit == 0 {
+		return 64 * maxCollectionSize
+	}
+	return limit
+}
+
+//stepsError is the error of exceeding the maximum number of steps of an evaluation
+func stepsError(max int) error {
+	return &stopError{msg: fmt.Sprintf("The evaluation exceeded its limit of %d steps", max)}
+}
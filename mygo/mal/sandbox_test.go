@@ -0,0 +1,137 @@
+package mal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//sandboxCase is a form evaluated in a sandbox, and the printed value or the error message it should give
+type sandboxCase struct {
+	src  string
+	want string
+}
+
+func runSandbox(t *testing.T, opts Options, cases []sandboxCase) {
+	t.Helper()
+	for name, backend := range backends {
+		opts.VM = backend.VM
+		in := New(opts)
+		for _, c := range cases {
+			res, err := in.EvalString(context.Background(), c.src)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			} else {
+				got = PrString(res, true)
+			}
+			if got != c.want {
+				t.Errorf("%s: %s: got %s, want %s", name, c.src, got, c.want)
+			}
+		}
+	}
+}
+
+func TestProfilePure(t *testing.T) {
+	runSandbox(t, Options{Profile: ProfilePure}, []sandboxCase{
+		{`(slurp "go.mod")`, "'slurp' not found"},
+		{`(readline "> ")`, "'readline' not found"},
+		{`(try* (load-file "go.mod") (catch* e e))`, `"load-file: can't read go.mod, the pure profile can't read files"`},
+		{`(+ 1 2)`, "3"},
+	})
+}
+
+func TestProfileReadOnlyFS(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.mal"), []byte("(def! a 1)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "b.mal")
+	if err := os.WriteFile(outside, []byte("(def! b 2)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runSandbox(t, Options{Profile: ProfileReadOnlyFS, Root: root}, []sandboxCase{
+		{`(slurp "a.mal")`, `"(def! a 1)\n"`},
+		{`(do (load-file "a.mal") a)`, "1"},
+		{`(try* (slurp "` + outside + `") (catch* e e))`, `"slurp: can't read ` + outside + `, it is outside of ` + real + `"`},
+		{`(try* (load-file "../b.mal") (catch* e (string? e)))`, "true"},
+		{`(readline "> ")`, "'readline' not found"},
+	})
+}
+
+func TestMaxEvalDepthInSandbox(t *testing.T) {
+	deep := "(def! deep (fn* [n] (if (= n 0) 0 (+ 1 (deep (- n 1))))))"
+	// code in a sandbox can lower *max-eval-depth*, but not raise or remove the limit the host set
+	runSandbox(t, Options{Profile: ProfilePure, MaxEvalDepth: 50}, []sandboxCase{
+		{deep + " (deep 40)", "40"},
+		{"(deep 60)", "Maximum evaluation depth of 50 exceeded, see *max-eval-depth*"},
+		{"(def! *max-eval-depth* 0) (deep 60)", "Maximum evaluation depth of 50 exceeded, see *max-eval-depth*"},
+		{"(def! *max-eval-depth* nil) (deep 60)", "Maximum evaluation depth of 50 exceeded, see *max-eval-depth*"},
+		{"(def! *max-eval-depth* 1000) (deep 60)", "Maximum evaluation depth of 50 exceeded, see *max-eval-depth*"},
+		{"(def! *max-eval-depth* 10) (try* (deep 20) (catch* e e))", `"Maximum evaluation depth of 10 exceeded, see *max-eval-depth*"`},
+	})
+	runSandbox(t, Options{Profile: ProfileReadOnlyFS, MaxEvalDepth: 50}, []sandboxCase{
+		{deep + " (def! *max-eval-depth* 0) (deep 60)", "Maximum evaluation depth of 50 exceeded, see *max-eval-depth*"},
+	})
+	// without a sandbox, it can be raised up to MaxEvalDepthLimit
+	runSandbox(t, Options{MaxEvalDepth: 50}, []sandboxCase{
+		{deep + " (def! *max-eval-depth* 1000) (deep 60)", "60"},
+	})
+}
+
+func TestMaxSteps(t *testing.T) {
+	runSandbox(t, Options{Profile: ProfilePure, MaxSteps: 100}, []sandboxCase{
+		{"(loop [i 0] (if (< i 50) (recur (+ i 1)) i))", "50"},
+		{"(loop [i 0] (recur (+ i 1)))", "The evaluation exceeded its limit of 100 steps"},
+		{"(try* (count (range)) (catch* e e))", `"The evaluation exceeded its limit of 100 steps"`},
+		// every evaluation has steps of its own
+		{"(loop [i 0] (if (< i 50) (recur (+ i 1)) i))", "50"},
+	})
+}
+
+func TestMaxCollectionSize(t *testing.T) {
+	runSandbox(t, Options{Profile: ProfilePure, MaxCollectionSize: 100}, []sandboxCase{
+		{"(count (range 100))", "100"},
+		{"(try* (count (range 101)) (catch* e e))", `"range: the size of collections is limited to 100 elements"`},
+		{"(count (repeat 1000 1))", "repeat: the size of collections is limited to 100 elements"},
+		{"(try* (apply str (repeat 20 \"0123456789\")) (catch* e e))", `"str: the size of collections is limited to 100 elements"`},
+	})
+}
+
+func TestDeepNestingInSandbox(t *testing.T) {
+	nest := "(def! nest (fn* [f x n] (loop [x x i 0] (if (< i n) (recur (f x) (+ i 1)) x))))"
+	// every level stays below the limit on collection sizes, but the nesting can't exhaust the host's stack
+	runSandbox(t, Options{Profile: ProfilePure, MaxSteps: 10000000, MaxCollectionSize: 100, MaxEvalDepth: 1000}, []sandboxCase{
+		{nest + " (def! deep (nest vector [] 300000)) nil", "nil"},
+		{"(try* (pr-str deep) (catch* e e))", `"Maximum nesting depth of 200000 exceeded"`},
+		{"(try* (= deep (nest vector [] 300000)) (catch* e e))", `"Maximum nesting depth of 200000 exceeded"`},
+		{"(try* (eval deep) (catch* e e))", `"Maximum nesting depth of 200000 exceeded"`},
+		{"(try* (first (nest #(map - %) (range 3) 5000)) (catch* e e))", `"Maximum evaluation depth of 1000 exceeded, see *max-eval-depth*"`},
+		{"(first (rest (nest #(map - %) (range 3) 501)))", "-1"},
+	})
+}
+
+func TestMaxNumberBits(t *testing.T) {
+	square := "(loop [x 2 i 0] (if (< i 40) (recur (* x x) (+ i 1)) x))"
+	runSandbox(t, Options{Profile: ProfilePure, MaxNumberBits: 100}, []sandboxCase{
+		{"(* 4294967296 4294967296)", "18446744073709551616"},
+		{"(try* " + square + " (catch* e e))", `"*: the size of numbers is limited to 100 bits"`},
+		{"(try* (/ 1 (* 4294967296 4294967296 4294967296 4294967296)) (catch* e e))", `"*: the size of numbers is limited to 100 bits"`},
+		{"(/ 1 3)", "1/3"},
+	})
+	// without a limit of its own, numbers are limited to 64 bits per element of the largest collection
+	runSandbox(t, Options{Profile: ProfilePure, MaxSteps: 100, MaxCollectionSize: 1000}, []sandboxCase{
+		{"(try* " + square + " (catch* e e))", `"*: the size of numbers is limited to 64000 bits"`},
+	})
+	in := New(Options{})
+	res, err := in.EvalString(context.Background(), "(loop [x 2 i 0] (if (< i 12) (recur (* x x) (+ i 1)) x))")
+	if err != nil || len(strings.TrimLeft(PrString(res, true), "-")) < 1000 {
+		t.Errorf("without limits: got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package mal
+
+// Sets are immutable, like hash maps, and are stored as a map from their elements to themselves
+
+//Contains reports whether value is an element of the set
+func (set *Set) Contains(value Type) bool {
+	_, ok := set.elements.Get(value)
+	return ok
+}
+
+//Get returns the element of the set that is equal to value, and whether there is one
+func (set *Set) Get(value Type) (Type, bool) {
+	return set.elements.Get(value)
+}
+
+//Add adds value to the set, unless it already contains an equal value. This modifies the set, so it may only
+//be used while building a new set
+func (set *Set) Add(value Type) {
+	if !set.Contains(value) {
+		set.elements.Set(value, value)
+	}
+}
+
+//Conj returns a copy of the set with values added
+func (set *Set) Conj(values ...Type) *Set {
+	newSet := *set
+	for _, v := range values {
+		newSet.Add(v)
+	}
+	return &newSet
+}
+
+//Disj returns a copy of the set without values
+func (set *Set) Disj(values ...Type) *Set {
+	newSet := *set
+	for _, v := range values {
+		newSet.elements = *newSet.elements.Dissoc(v)
+	}
+	return &newSet
+}
+
+//Len returns the number of elements in the set
+func (set *Set) Len() int {
+	return set.elements.Len()
+}
+
+//Slice returns the elements of the set, in the order they were added for small sets and in no particular
+//order for bigger ones
+func (set *Set) Slice() []Type {
+	entries := set.elements.Entries()
+	elements := make([]Type, len(entries))
+	for i, e := range entries {
+		elements[i] = e.Key
+	}
+	return elements
+}
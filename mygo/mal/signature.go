@@ -0,0 +1,288 @@
+package mal
+
+// Signatures of the native functions. Every native function checks the number and the types of the arguments it is
+// called with against its signature before it runs, so that the functions themselves can assume they got what they
+// asked for, and calling them wrong raises an error mal code can catch, instead of crashing the interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+//argType is a set of types of mal values, the ones a native function accepts as one of its arguments
+type argType uint
+
+const (
+	tNumber argType = 1 << iota
+	tString
+	tSymbol
+	tKeyword
+	tBoolean
+	tNil
+	tList
+	tVector
+	tLazySeq
+	tMap
+	tSet
+	tFunction
+	tAtom
+	tRegex
+
+	//tAny accepts any value
+	tAny = ^argType(0)
+	//tSeq accepts the values the sequence functions like first and rest take
+	tSeq = tList | tVector | tLazySeq | tNil
+)
+
+var argTypeNames = []string{"number", "string", "symbol", "keyword", "boolean", "nil", "list", "vector",
+	"lazy sequence", "map", "set", "function", "atom", "regex"}
+
+func (t argType) String() string {
+	var names []string
+	for i, name := range argTypeNames {
+		if t&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+//typeOf returns the argType of a value, or 0 for values only tAny accepts
+func typeOf(value Type) argType {
+	switch v := value.(type) {
+	case *Number:
+		return tNumber
+	case *String:
+		return tString
+	case *Symbol:
+		return tSymbol
+	case *Keyword:
+		return tKeyword
+	case *Boolean:
+		return tBoolean
+	case *Nil:
+		return tNil
+	case *List:
+		if v.IsVector {
+			return tVector
+		}
+		return tList
+	case *LazySeq:
+		return tLazySeq
+	case *HashMap:
+		return tMap
+	case *Set:
+		return tSet
+	case *Function:
+		return tFunction
+	case *Atom:
+		return tAtom
+	case *Regex:
+		return tRegex
+	}
+	return 0
+}
+
+//typeName returns the name of the type of a value, for error messages
+func typeName(value Type) string {
+	if t := typeOf(value); t != 0 {
+		return t.String()
+	}
+	if _, ok := value.(*ExInfo); ok {
+		return "ex-info"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+//signature describes the arguments of a native function: the types of its parameters, of which the first
+//required ones are mandatory, and the type of the arguments that may follow them, or 0 if none may
+type signature struct {
+	params   []argType
+	required int
+	rest     argType
+}
+
+//sig returns the signature of a function with mandatory parameters of the given types
+func sig(params ...argType) signature {
+	return signature{params: params, required: len(params)}
+}
+
+//optional adds optional parameters of the given types to a signature
+func (s signature) optional(params ...argType) signature {
+	s.params = append(s.params[:len(s.params):len(s.params)], params...)
+	return s
+}
+
+//variadic lets a function take any number of arguments of type t after its parameters
+func (s signature) variadic(t argType) signature {
+	s.rest = t
+	return s
+}
+
+//check returns the error of calling the function called name with args, if they don't match the signature
+func (s signature) check(name string, args []Type) error {
+	if len(args) < s.required || (s.rest == 0 && len(args) > len(s.params)) {
+		return arityError(len(args), name)
+	}
+	for i, arg := range args {
+		t := s.rest
+		if i < len(s.params) {
+			t = s.params[i]
+		}
+		if t != tAny && typeOf(arg)&t == 0 {
+			return Errorf("%s: Argument %d must be of type %s, got %s", name, i+1, t, typeName(arg))
+		}
+	}
+	return nil
+}
+
+//coreSignatures holds the signatures of the functions of CoreNS and of the ones an Interpreter adds to them
+var coreSignatures = map[string]signature{
+	"+":           sig().variadic(tNumber),
+	"-":           sig(tNumber).variadic(tNumber),
+	"*":           sig().variadic(tNumber),
+	"/":           sig(tNumber).variadic(tNumber),
+	"quot":        sig(tNumber, tNumber),
+	"rem":         sig(tNumber, tNumber),
+	"mod":         sig(tNumber, tNumber),
+	"int":         sig(tNumber),
+	"double":      sig(tNumber),
+	"numerator":   sig(tNumber),
+	"denominator": sig(tNumber),
+	"<":           sig(tNumber).variadic(tNumber),
+	">":           sig(tNumber).variadic(tNumber),
+	"<=":          sig(tNumber).variadic(tNumber),
+	">=":          sig(tNumber).variadic(tNumber),
+	"=":           sig(tAny, tAny),
+
+	"list":    sig().variadic(tAny),
+	"list?":   sig(tAny),
+	"empty?":  sig(tAny),
+	"count":   sig(tAny),
+	"cons":    sig(tAny, tSeq),
+	"concat":  sig().variadic(tList | tVector | tLazySeq),
+	"first":   sig(tSeq),
+	"nth":     sig(tList|tVector|tLazySeq, tNumber),
+	"rest":    sig(tSeq),
+	"seq":     sig(tSeq | tString | tSet),
+	"conj":    sig(tList | tVector | tLazySeq | tSet).variadic(tAny),
+	"apply":   sig(tFunction, tAny).variadic(tAny),
+	"map":     sig(tFunction, tAny).variadic(tAny),
+	"vector":  sig().variadic(tAny),
+	"vector?": sig(tAny),
+
+	"range":      sig().optional(tNumber, tNumber, tNumber),
+	"iterate":    sig(tFunction, tAny),
+	"repeat":     sig(tAny).optional(tAny),
+	"cycle":      sig(tAny),
+	"take":       sig(tAny, tAny),
+	"drop":       sig(tAny, tAny),
+	"take-while": sig(tFunction, tAny),
+	"filter":     sig(tFunction, tAny),
+
+	"pr-str":          sig().variadic(tAny),
+	"str":             sig().variadic(tAny),
+	"prn":             sig().variadic(tAny),
+	"println":         sig().variadic(tAny),
+	"read-string":     sig(tString),
+	"read-all-string": sig(tString),
+	"slurp":           sig(tString),
+	"readline":        sig().optional(tString | tNil),
+	"time-ms":         sig(),
+	"eval":            sig(tAny),
+	"load-file":       sig(tString),
+	"load-lib":        sig(tString),
+	"load-file-once":  sig(tString),
+
+	"call-with-timeout": sig(tNumber, tFunction),
+
+	"ns":         sig(tSymbol).variadic(tList),
+	"in-ns":      sig(tSymbol),
+	"require":    sig().variadic(tSymbol | tVector),
+	"ns-publics": sig(tSymbol),
+	"ns-map":     sig(tSymbol),
+
+	"atom":   sig(tAny),
+	"atom?":  sig(tAny),
+	"deref":  sig(tAtom),
+	"reset!": sig(tAtom, tAny),
+	"swap!":  sig(tAtom, tFunction).variadic(tAny),
+	"throw":  sig(tAny),
+
+	"ex-info":    sig(tString, tMap).optional(tAny),
+	"ex-message": sig(tAny),
+	"ex-data":    sig(tAny),
+	"ex-cause":   sig(tAny),
+	"ex-stack":   sig(tAny),
+
+	"nil?":        sig(tAny),
+	"true?":       sig(tAny),
+	"false?":      sig(tAny),
+	"symbol?":     sig(tAny),
+	"symbol":      sig(tString),
+	"gensym":      sig().optional(tString),
+	"keyword":     sig(tKeyword | tString),
+	"keyword?":    sig(tAny),
+	"sequential?": sig(tAny),
+	"map?":        sig(tAny),
+	"fn?":         sig(tAny),
+	"macro?":      sig(tAny),
+	"string?":     sig(tAny),
+	"number?":     sig(tAny),
+	"regex?":      sig(tAny),
+	"set?":        sig(tAny),
+
+	"hash-map":  sig().variadic(tAny),
+	"assoc":     sig(tMap).variadic(tAny),
+	"dissoc":    sig(tMap).variadic(tAny),
+	"get":       sig(tAny, tAny),
+	"contains?": sig(tMap|tSet, tAny),
+	"keys":      sig(tMap),
+	"vals":      sig(tMap),
+
+	"hash-set":     sig().variadic(tAny),
+	"set":          sig(tAny),
+	"disj":         sig(tSet).variadic(tAny),
+	"union":        sig().variadic(tSet),
+	"intersection": sig(tSet).variadic(tSet),
+	"difference":   sig(tSet).variadic(tSet),
+
+	"re-pattern": sig(tRegex | tString),
+	"re-find":    sig(tRegex, tString),
+	"re-matches": sig(tRegex, tString),
+
+	"meta":      sig(tMap | tList | tVector | tSet | tAtom | tFunction),
+	"with-meta": sig(tMap|tList|tVector|tSet|tAtom|tFunction, tAny),
+}
+
+func init() {
+	for symbol, fn := range CoreNS {
+		CoreNS[symbol] = native(symbol.Value, fn.Fn)
+	}
+}
+
+//native creates the native function called name, which checks the arguments it is called with against its
+//signature in coreSignatures, if it has one
+func native(name string, fn func(args ...Type) (Type, error)) *Function {
+	s, ok := coreSignatures[name]
+	if !ok {
+		return &Function{Name: name, Fn: fn}
+	}
+	return &Function{Name: name, Fn: func(args ...Type) (Type, error) {
+		if err := s.check(name, args); err != nil {
+			return nil, err
+		}
+		return fn(args...)
+	}}
+}
+
+//arityError is the error of calling the function called name with argc arguments, which it doesn't take
+func arityError(argc int, name string) error {
+	if name == "" {
+		name = "an anonymous function"
+	}
+	return Errorf("wrong number of args (%d) passed to %s", argc, name)
+}
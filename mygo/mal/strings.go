@@ -2,7 +2,11 @@ package mal
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 //StringReader reads lisp for tokenization and parsing
@@ -33,74 +37,153 @@ func NewStringReader(str string) *StringReader {
 	return &r
 }
 
-//ReadString parses escape sequences in strings such as \n and returns a new string
+//ReadString parses escape sequences in strings such as \n and returns a new string.
+//Supported escapes are \n, \t, \r, \0, \", \\, \xHH for a single byte and \uXXXX for a unicode code point
 func ReadString(s string) (string, error) {
 	var sb strings.Builder
 	sr := NewStringReader(s)
-	writtenStringEnd := false
 
-	if !strings.HasPrefix(s, "\"") {
+	if v, eof := sr.next(); eof || v != '"' {
 		return "", fmt.Errorf("unbalanced \", while parsing: %s", sr.str)
 	}
 
 	for {
 		v, eof := sr.next()
 		if eof {
-			break
+			return "", fmt.Errorf("unbalanced \", while parsing: %s", sr.str)
 		}
 
-		if v == '\\' {
-			p, eof := sr.peek()
-			if eof {
-				return "", fmt.Errorf("expected escape sequence after \\, eof found instead. unbalanced string")
+		if v == '"' {
+			if _, eof := sr.peek(); !eof {
+				return "", fmt.Errorf("unexpected \" inside string, while parsing: %s", sr.str)
 			}
-			switch p {
-			case 'n':
-				sr.next()
-				sb.WriteByte('\n')
-			case '"':
-				sr.next()
-				sb.WriteByte('"')
-			case '\\':
-				sr.next()
-				sb.WriteByte('\\')
+			return sb.String(), nil
+		}
+		if v != '\\' {
+			sb.WriteByte(v)
+			continue
+		}
+
+		p, eof := sr.next()
+		if eof {
+			return "", fmt.Errorf("expected escape sequence after \\, eof found instead. unbalanced string")
+		}
+		switch p {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case '0':
+			sb.WriteByte(0)
+		case '"':
+			sb.WriteByte('"')
+		case '\\':
+			sb.WriteByte('\\')
+		case 'x':
+			b, err := readHexEscape(sr, 2)
+			if err != nil {
+				return "", err
 			}
-		} else {
-			if _, eof := sr.peek(); eof && v == '"' {
-				writtenStringEnd = true
+			sb.WriteByte(byte(b))
+		case 'u':
+			r, err := readHexEscape(sr, 4)
+			if err != nil {
+				return "", err
 			}
-			sb.WriteByte(v)
+			// code points outside the basic multilingual plane are written as a surrogate pair, a surrogate on its
+			// own isn't a character
+			if utf16.IsSurrogate(rune(r)) {
+				if !strings.HasPrefix(sr.str[sr.pos:], "\\u") {
+					return "", fmt.Errorf("unpaired surrogate \\u%04x in string", r)
+				}
+				sr.pos += 2
+				r2, err := readHexEscape(sr, 4)
+				if err != nil {
+					return "", err
+				}
+				if utf16.DecodeRune(rune(r), rune(r2)) == unicode.ReplacementChar {
+					return "", fmt.Errorf("unpaired surrogate \\u%04x in string", r)
+				}
+				r = uint64(utf16.DecodeRune(rune(r), rune(r2)))
+			}
+			sb.WriteRune(rune(r))
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c in string", p)
 		}
 	}
-	str := sb.String()
-	if len(str) < 2 || !writtenStringEnd {
-		return "", fmt.Errorf("unbalanced \", while parsing: %s", sr.str)
+}
+
+//readHexEscape reads exactly n hex digits following \x or \u
+func readHexEscape(sr *StringReader, n int) (uint64, error) {
+	if sr.pos+n > len(sr.str) {
+		return 0, fmt.Errorf("escape sequence in string must be followed by %d hex digits", n)
 	}
-	return str[1 : len(str)-1], nil
+	digits := sr.str[sr.pos : sr.pos+n]
+	v, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("escape sequence in string must be followed by %d hex digits, got '%s'", n, digits)
+	}
+	sr.pos += n
+	return v, nil
 }
 
-//WriteString parses special characters such as newlines and escapes them
-func WriteString(s string) string {
+//readRegex returns the pattern of a #"..." regex literal. Unlike strings, backslashes are kept as they are,
+//they belong to the regular expression. Only \" is unescaped
+func readRegex(s string) string {
 	var sb strings.Builder
-	sr := NewStringReader(s)
-
+	sr := NewStringReader(s[1 : len(s)-1])
 	for {
 		v, eof := sr.next()
 		if eof {
 			break
 		}
+		if p, _ := sr.peek(); v == '\\' && p == '"' {
+			continue
+		}
+		sb.WriteByte(v)
+	}
+	return sb.String()
+}
 
-		switch v {
+//WriteString escapes special characters such as newlines, as well as any non printable character,
+//so that reading the result with ReadString returns the original string
+func WriteString(s string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size <= 1 { // not valid UTF-8, keep the raw byte
+			fmt.Fprintf(&sb, "\\x%02x", s[i])
+			i++
+			continue
+		}
+		i += size
+
+		switch r {
 		case '\n':
 			sb.WriteString("\\n")
+		case '\t':
+			sb.WriteString("\\t")
+		case '\r':
+			sb.WriteString("\\r")
+		case 0:
+			sb.WriteString("\\0")
 		case '"':
 			sb.WriteString("\\\"")
 		case '\\':
 			sb.WriteString("\\\\")
 		default:
-			sb.WriteByte(v)
+			if unicode.IsPrint(r) {
+				sb.WriteRune(r)
+			} else if r <= 0xffff {
+				fmt.Fprintf(&sb, "\\u%04x", r)
+			} else {
+				r1, r2 := utf16.EncodeRune(r)
+				fmt.Fprintf(&sb, "\\u%04x\\u%04x", r1, r2)
+			}
 		}
-
 	}
 	return "\"" + sb.String() + "\""
 }
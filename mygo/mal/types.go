@@ -1,54 +1,112 @@
 package mal
 
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
 //Type is the 'parent' for all Mal data structures. E.g. List, Atom, etc
 type Type interface {
 }
 
-//List holds a list of MalTypes
+//List holds a list or vector of MalTypes, see list.go
 type List struct {
-	Value    []Type
 	IsVector bool
 	Meta     Type
-}
+	Pos      *Position
+	stack    *caughtStack // set for a list caught by catch*, see caught
 
-//NewList creates a list or vector
-func NewList(isVector bool) List {
-	var list List
-	list.IsVector = isVector
-	return list
+	items  []Type
+	first  Type
+	next   *List // set for cons cells, which hold first followed by the elements of next
+	count  int
+	vec    *vector
+	offset int
 }
 
-//HashMap holds mappings from string -> MalType
+//HashMap holds mappings from MalType -> MalType. Keys are compared by value, like = does, see hashmap.go
 type HashMap struct {
-	Value map[string]Type
-	Meta  Type
+	entries []MapEntry // for small maps
+	root    *hamtNode  // for all others
+	count   int
+	Meta    Type
+	// the keys and values of a map literal, as they were read, see mapLiteral
+	literal []Type
+	stack   *caughtStack // set for a map caught by catch*, see caught
 }
 
 //NewHashMap creates a new HashMap
 func NewHashMap() HashMap {
 	var m HashMap
-	m.Value = make(map[string]Type, 16) //bucket size 16 by default, arbitrary
 	return m
 }
 
+//Set holds a collection of unique mal values, see set.go
+type Set struct {
+	elements HashMap
+	Meta     Type
+	stack    *caughtStack // set for a set caught by catch*, see caught
+}
+
+//NewSet creates a new, empty Set
+func NewSet() Set {
+	var set Set
+	return set
+}
+
+//LazySeq holds a sequence whose elements are only computed when they are needed, see lazy.go
+type LazySeq struct {
+	fn       func() (Type, error)
+	realized bool
+	empty    bool
+	first    Type
+	rest     Type // a *List or *LazySeq
+	err      error
+	Meta     Type
+	// the interpreter whose evaluation realizing the sequence is a step of, if any, see realize
+	in *Interpreter
+}
+
+//Regex holds a compiled regular expression
+type Regex struct {
+	Value *regexp.Regexp
+}
+
 //Symbol holds a symbol
 type Symbol struct {
 	Value string
+	Pos   *Position
+	stack *caughtStack // set for a symbol caught by catch*, see caught
 }
 
-//Number holds an number, represented as a float64
+//Number holds a number. Kind tells which one of the other fields holds its value, see numbers.go
 type Number struct {
-	Value float64
+	Kind  NumberKind
+	Int   int64
+	Big   *big.Int
+	Rat   *big.Rat
+	Float float64
+	stack *caughtStack // set for a number caught by catch*, see caught
 }
 
-//Function holds a function
+//Function holds a function. Ast, Params and Env are used by the evaluators of the steps, functions defined
+//in mal code run by an Interpreter are compiled instead
 type Function struct {
+	Name    string // the name of a native function, or the one a function is defined with by def!
 	Ast     Type
 	Params  []Type
 	Env     *Env
 	IsMacro bool
 	Fn      func(args ...Type) (Type, error)
 	Meta    Type
+
+	// set for functions compiled by compile.go
+	lambda *lambda
+	frame  *frame
+	// set for functions compiled by bytecode.go
+	proto    *proto
+	upvalues []Type
 }
 
 //Boolean holds a boolean
@@ -63,11 +121,13 @@ type Nil struct {
 //String holds, perhaps unexpectedly a string
 type String struct {
 	Value string
+	stack *caughtStack // set for a string caught by catch*, see caught
 }
 
 //Keyword holds, a keyword
 type Keyword struct {
 	Value string
+	stack *caughtStack // set for a keyword caught by catch*, see caught
 }
 
 //Atom holds a reference to a mal value
@@ -76,11 +136,126 @@ type Atom struct {
 	Meta  Type
 }
 
-//Error holds an Error
+//Error holds an Error, i.e. a mal value thrown as an exception
 type Error struct {
 	Value Type
+	// set if the value was thrown by throw, rather than being the message of an error of the interpreter
+	thrown bool
+}
+
+//Errorf creates an Error whose value is the formatted message, like the errors raised by the native functions
+func Errorf(format string, a ...interface{}) *Error {
+	return &Error{Value: &String{Value: fmt.Sprintf(format, a...)}}
 }
 
+//Error returns the message of an Error whose value is a string or an ExInfo, or the printed value otherwise
 func (err *Error) Error() string {
-	return "Error: " + PrString(err.Value, true)
+	return errorMessage(err.Value)
+}
+
+//ExInfo is an exception created by ex-info: a message along with a map of data, and the value thrown before
+//that caused it, if any
+type ExInfo struct {
+	Message string
+	Data    Type
+	Cause   Type         // nil without a cause
+	stack   *caughtStack // set once it is caught by catch*, see caught
+}
+
+//Position is the location of a form in the source it was read from
+type Position struct {
+	File string
+	Line int
+	Col  int
+}
+
+func (pos *Position) String() string {
+	return fmt.Sprintf("%s:%d:%d", pos.File, pos.Line, pos.Col)
+}
+
+//PosError annotates an error with the source position of the form that caused it
+type PosError struct {
+	Pos Position
+	Err error
+}
+
+func (err *PosError) Error() string {
+	return err.Pos.String() + ": " + err.Err.Error()
+}
+
+//Unwrap returns the annotated error, so errors.As can see through a PosError
+func (err *PosError) Unwrap() error {
+	return err.Err
+}
+
+//StackFrame is a call of a function that an error propagated out of
+type StackFrame struct {
+	Name string    //the name of the function, or "" if it is anonymous
+	Pos  *Position //the position of the call, or nil if it is unknown, e.g. for calls made by native functions
+}
+
+func (frame StackFrame) String() string {
+	name := frame.Name
+	if name == "" {
+		name = "an anonymous function"
+	}
+	if frame.Pos == nil {
+		return name
+	}
+	return name + " (" + frame.Pos.String() + ")"
+}
+
+//TracedError annotates an error with the stack of the calls it propagated out of. It holds the calls
+//between the one that raised the error and the one that handled it, i.e. the catch* or the top level
+type TracedError struct {
+	Err   error
+	calls *call // the outermost call
+}
+
+//call is a StackFrame in the list of the calls of a TracedError. The calls are shared by the TracedErrors an error
+//becomes while it propagates, and by the ones of an error returned more than once, e.g. by a lazy sequence, so that
+//adding a call doesn't copy the others, which matters for errors like the one of exceeding the maximum depth
+type call struct {
+	frame StackFrame
+	inner *call
+}
+
+//Stack returns the calls of err, innermost first
+func (err *TracedError) Stack() []StackFrame {
+	var stack []StackFrame
+	for c := err.calls; c != nil; c = c.inner {
+		stack = append(stack, c.frame)
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+func (err *TracedError) Error() string {
+	return err.Err.Error()
+}
+
+//Unwrap returns the annotated error, so errors.As can see through a TracedError
+func (err *TracedError) Unwrap() error {
+	return err.Err
+}
+
+//IncompleteError is returned by the reader when the input ends in the middle of a form, e.g. inside an
+//unclosed list or string, as opposed to input that can never be read. Use errors.Is(err, ErrIncomplete) to test for it
+type IncompleteError struct {
+	Msg string
+}
+
+//ErrIncomplete matches any IncompleteError in errors.Is
+var ErrIncomplete = &IncompleteError{Msg: "incomplete input"}
+
+func (err *IncompleteError) Error() string {
+	return err.Msg
+}
+
+//Is reports whether target is an IncompleteError
+func (err *IncompleteError) Is(target error) bool {
+	_, ok := target.(*IncompleteError)
+	return ok
 }
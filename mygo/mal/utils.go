@@ -1,39 +1,101 @@
 package mal
 
-import (
-	"fmt"
-	"strings"
-)
-
 // Stuff that doesn't fit anywhere else
 
-//TypeToHashKey Takes a mal type, and if it's a string or keyword, returns the raw string for use in a hash map
-func TypeToHashKey(key Type) (string, error) {
-	if strKey, ok := key.(*String); ok {
-		return strKey.Value, nil
-	}
-	if strKey, ok := key.(*Keyword); ok {
-		return strKey.Value, nil
-	}
-	return "", fmt.Errorf("Map keys must be of type String, got '%T' instead", key)
-
-}
-
-//NativeStringToMalHashKey takes a string and returns either a malString or malKeyword
-func NativeStringToMalHashKey(str string) Type {
-	if strings.HasPrefix(str, ":") {
-		return &Keyword{Value: str}
-	}
-	return &String{Value: str}
-}
-
 //CopyOfFunction creates and returns a copy of a mal function
 func CopyOfFunction(fn *Function) *Function {
 	newFn := Function{}
+	newFn.Name = fn.Name
 	newFn.Ast = fn.Ast
 	newFn.Params = fn.Params
 	newFn.Env = fn.Env
 	newFn.IsMacro = fn.IsMacro
 	newFn.Fn = fn.Fn
+	newFn.lambda = fn.lambda
+	newFn.frame = fn.frame
+	newFn.proto = fn.proto
+	newFn.upvalues = fn.upvalues
 	return &newFn
 }
+
+//PositionOf returns the source position of a list or symbol read by the reader, or nil if it has none
+func PositionOf(ast Type) *Position {
+	switch v := ast.(type) {
+	case *List:
+		return v.Pos
+	case *Symbol:
+		return v.Pos
+	}
+	return nil
+}
+
+//WithPosition annotates err with pos. Errors that already carry a position keep the innermost one,
+//and positions without a file name (e.g. forms typed into the REPL) are not reported
+func WithPosition(err error, pos *Position) error {
+	if err == nil || pos == nil || pos.File == "" {
+		return err
+	}
+	switch e := err.(type) {
+	case *PosError:
+		return err
+	case *TracedError:
+		if _, ok := e.Err.(*PosError); ok {
+			return err
+		}
+		return &TracedError{Err: &PosError{Pos: *pos, Err: e.Err}, calls: e.calls}
+	}
+	return &PosError{Pos: *pos, Err: err}
+}
+
+//StripPosition returns the error annotated by a PosError and its stack, or err itself if it carries neither
+func StripPosition(err error) error {
+	if traced, ok := err.(*TracedError); ok {
+		err = traced.Err
+	}
+	if posErr, ok := err.(*PosError); ok {
+		return posErr.Err
+	}
+	return err
+}
+
+//StackOf returns the stack of the calls err propagated out of, innermost first
+func StackOf(err error) []StackFrame {
+	if traced, ok := err.(*TracedError); ok {
+		return traced.Stack()
+	}
+	return nil
+}
+
+//withCall adds a call of the function called name, at a position to be set by calledAt, to the stack of err
+func withCall(err error, name string) error {
+	if err == nil {
+		return nil
+	}
+	c := &call{frame: StackFrame{Name: name}}
+	if traced, ok := err.(*TracedError); ok {
+		c.inner = traced.calls
+		return &TracedError{Err: traced.Err, calls: c}
+	}
+	return &TracedError{Err: err, calls: c}
+}
+
+//calledAt sets the position of the outermost call in the stack of err to pos, unless it has one
+func calledAt(err error, pos *Position) error {
+	traced, ok := err.(*TracedError)
+	if !ok || pos == nil || pos.File == "" || traced.calls.frame.Pos != nil {
+		return err
+	}
+	c := &call{frame: StackFrame{Name: traced.calls.frame.Name, Pos: pos}, inner: traced.calls.inner}
+	return &TracedError{Err: traced.Err, calls: c}
+}
+
+//isTruthy reports whether a value counts as true in a condition, i.e. whether it is neither nil nor false
+func isTruthy(value Type) bool {
+	switch v := value.(type) {
+	case *Nil:
+		return false
+	case *Boolean:
+		return v.Value
+	}
+	return true
+}
@@ -0,0 +1,421 @@
+package mal
+
+// The virtual machine running the code produced by bytecode.go. All functions run on a single stack of values, on
+// which each call has a frame: the function called, followed by its slots, followed by the values its instructions
+// work on. Calls of functions defined in mal don't recurse in Go, so the depth of the Go stack only grows when the
+// machine is re-entered from a native function, e.g. by map calling a function defined in mal
+
+import (
+	"fmt"
+)
+
+type machine struct {
+	in       *Interpreter
+	stack    []Type
+	frames   []*vmFrame
+	handlers []handler
+}
+
+type vmFrame struct {
+	fn    *Function
+	proto *proto
+	pc    int // the next instruction
+	opPC  int // the instruction being executed, e.g. the call while the function called runs
+	base  int // the index of the first slot on the stack, the function is right below it
+}
+
+//handler is an opTry that hasn't ended yet
+type handler struct {
+	frame   int
+	sp      int
+	catches *catchTable
+}
+
+//pendingError is an error an opTry pushes for the finally of a try* to throw again, see opRethrow
+type pendingError struct {
+	err error
+}
+
+//cell holds a boxed variable, see bytecode.go
+type cell struct {
+	value Type
+}
+
+func newMachine(in *Interpreter) *machine {
+	return &machine{in: in}
+}
+
+func (m *machine) push(value Type) {
+	m.stack = append(m.stack, value)
+}
+
+func (m *machine) pop() Type {
+	value := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return value
+}
+
+func (m *machine) top() Type {
+	return m.stack[len(m.stack)-1]
+}
+
+//enter pushes a frame for running the entry e of fn with the argc arguments on the stack from base on
+func (m *machine) enter(fn *Function, e *entry, base int, argc int) *vmFrame {
+	p := fn.proto
+	fixed := e.params
+	if e.variadic {
+		fixed--
+		rest := NewList(false)
+		if argc > fixed {
+			items := make([]Type, argc-fixed)
+			copy(items, m.stack[base+fixed:])
+			rest = NewList(false, items...)
+			m.stack = m.stack[:base+fixed]
+		}
+		for len(m.stack) < base+fixed {
+			m.push(&Nil{})
+		}
+		m.push(rest)
+	} else if argc > fixed {
+		m.stack = m.stack[:base+fixed]
+	} else {
+		for len(m.stack) < base+fixed {
+			m.push(&Nil{})
+		}
+	}
+	for len(m.stack) < base+p.slots {
+		m.push(nil)
+	}
+
+	// frames are reused once they are popped
+	if len(m.frames) == cap(m.frames) {
+		m.frames = append(m.frames, nil)
+	} else {
+		m.frames = m.frames[:len(m.frames)+1]
+	}
+	fr := m.frames[len(m.frames)-1]
+	if fr == nil {
+		fr = &vmFrame{}
+		m.frames[len(m.frames)-1] = fr
+	}
+	*fr = vmFrame{fn: fn, proto: p, pc: e.pc, base: base}
+	return fr
+}
+
+//closure creates a function of p, taking its upvalues from the frame fr
+func (m *machine) closure(p *proto, fr *vmFrame) *Function {
+	upvalues := make([]Type, len(p.upvalues))
+	for i, u := range p.upvalues {
+		if u.fromLocal {
+			upvalues[i] = m.stack[fr.base+u.index]
+		} else {
+			upvalues[i] = fr.fn.upvalues[u.index]
+		}
+	}
+	fn := &Function{Name: p.name, proto: p, upvalues: upvalues}
+	fn.Fn = func(args ...Type) (Type, error) {
+		return m.run(fn, args)
+	}
+	return fn
+}
+
+//unwind handles err, which occurred at the instruction opPC of the top frame. If a frame started by the run
+//that began with frame entry has a handler for it, the frame continues there and nil is returned. Otherwise
+//all those frames are popped, with the calls of their functions added to the stack of err, and err is returned
+func (m *machine) unwind(err error, entry int) error {
+	for {
+		top := len(m.frames) - 1
+		fr := m.frames[top]
+		pos := fr.proto.positionAt(fr.opPC)
+		err = WithPosition(calledAt(err, pos), pos)
+		for n := len(m.handlers); n > 0 && m.handlers[n-1].frame == top; n-- {
+			h := m.handlers[n-1]
+			m.handlers = m.handlers[:n-1]
+			if pc, values, ok := h.catches.handle(m.in, err); ok {
+				m.stack = append(m.stack[:h.sp], values...)
+				fr.pc = pc
+				return nil
+			}
+		}
+		m.stack = m.stack[:fr.base-1]
+		m.frames = m.frames[:top]
+		if !fr.proto.inline {
+			err = withCall(err, fr.fn.Name)
+		}
+		if top == entry {
+			return err
+		}
+	}
+}
+
+//handle returns where the code continues on err and the values it pushes, unless the handler doesn't handle err
+func (t *catchTable) handle(in *Interpreter, err error) (int, []Type, bool) {
+	if i := catching(t.catches, err); i >= 0 {
+		return t.pcs[i], []Type{caught(err)}, true
+	}
+	if t.finally >= 0 {
+		return t.finally, []Type{&pendingError{err: err}}, true
+	}
+	return 0, nil, false
+}
+
+//abandon drops the frames, handlers and values a run that began with frame entry and stack size sp left behind.
+//It only finds any if a native function panicked, which would otherwise leave the machine in a broken state
+func (m *machine) abandon(entry int, sp int) {
+	if len(m.frames) <= entry {
+		return
+	}
+	m.frames = m.frames[:entry]
+	m.stack = m.stack[:sp]
+	for len(m.handlers) > 0 && m.handlers[len(m.handlers)-1].frame >= entry {
+		m.handlers = m.handlers[:len(m.handlers)-1]
+	}
+}
+
+//tooDeep tells whether entering another frame would exceed the maximum depth, see *max-eval-depth*. The frame
+//of the top level form doesn't count, the levels counted by Interpreter.nest do
+func (m *machine) tooDeep() bool {
+	return len(m.frames)+m.in.depth > m.in.maxDepth
+}
+
+//run calls fn, a function with a proto, with args
+func (m *machine) run(fn *Function, args []Type) (Type, error) {
+	e := fn.proto.entryFor(len(args))
+	if e == nil {
+		return nil, withCall(arityError(len(args), fn.Name), fn.Name)
+	}
+	if m.tooDeep() {
+		return nil, withCall(depthError(m.in.maxDepth), fn.Name)
+	}
+	if err := m.in.step(); err != nil {
+		return nil, withCall(err, fn.Name)
+	}
+	entry := len(m.frames)
+	defer m.abandon(entry, len(m.stack))
+	m.push(fn)
+	m.stack = append(m.stack, args...)
+	fr := m.enter(fn, e, len(m.stack)-len(args), len(args))
+	// the state of the top frame, which is only stored in it when another frame is entered
+	p, code, pc, base := fr.proto, fr.proto.code, fr.pc, fr.base
+
+	for {
+		start := pc
+		op := opcode(code[start])
+		var arg int
+		if operandCount[op] > 0 {
+			arg = int(code[start+1])<<8 | int(code[start+2])
+		}
+		pc = start + 1 + 2*operandCount[op]
+
+		var err error
+		switch op {
+		case opConst:
+			m.push(p.consts[arg])
+		case opNil:
+			m.push(&Nil{})
+		case opLocal:
+			m.push(m.stack[base+arg])
+		case opLocalBox:
+			err = m.pushBoxed(m.stack[base+arg].(*cell), p.consts[operand(code, start, 1)])
+		case opUpvalue:
+			m.push(fr.fn.upvalues[arg])
+		case opUpvalueBox:
+			err = m.pushBoxed(fr.fn.upvalues[arg].(*cell), p.consts[operand(code, start, 1)])
+		case opGlobal, opGlobalFn:
+			global := p.consts[arg].(*globalExpr)
+			val := global.ns.env.Get(global.name)
+			if val == nil {
+				err = global.ns.notFound(global.name)
+				break
+			}
+			m.push(val)
+			if op == opGlobalFn {
+				if macro, ok := val.(*Function); ok && macro.IsMacro {
+					fr.pc = pc
+					if fr, err = m.expand(fr, p.sites[operand(code, start, 1)], macro, start); err == nil {
+						p, code, pc, base = fr.proto, fr.proto.code, fr.pc, fr.base
+					}
+				}
+			}
+		case opSetLocal:
+			m.stack[base+arg] = m.pop()
+		case opSetLocalBox:
+			m.stack[base+arg].(*cell).value = m.pop()
+		case opBox:
+			m.stack[base+arg] = &cell{}
+		case opDef:
+			def := p.consts[arg].(*defExpr)
+			def.ns.define(def.name, m.top(), def.private)
+		case opDefMacro:
+			fn, ok := m.top().(*Function)
+			if !ok {
+				err = fmt.Errorf("Argument 2 to defmacro! must be a function")
+				break
+			}
+			macro := CopyOfFunction(fn)
+			macro.IsMacro = true
+			m.stack[len(m.stack)-1] = macro
+			def := p.consts[arg].(*defExpr)
+			def.ns.define(def.name, macro, def.private)
+		case opPop:
+			m.pop()
+		case opJump:
+			// jumping back is the next iteration of a loop
+			if arg <= start {
+				err = m.in.step()
+			}
+			pc = arg
+		case opJumpIfFalse:
+			if !isTruthy(m.pop()) {
+				pc = arg
+			}
+		case opClosure:
+			m.push(m.closure(p.protos[arg], fr))
+		case opLazySeq:
+			fn := m.closure(p.protos[arg], fr)
+			m.push(&LazySeq{fn: func() (Type, error) {
+				return fn.Fn()
+			}, in: m.in})
+		case opMacroCheck:
+			if macro, ok := m.top().(*Function); ok && macro.IsMacro {
+				fr.pc = pc
+				if fr, err = m.expand(fr, p.sites[arg], macro, start); err == nil {
+					p, code, pc, base = fr.proto, fr.proto.code, fr.pc, fr.base
+				}
+			}
+		case opCall, opTailCall:
+			fr.opPC = start
+			fr.pc = pc
+			calleeIndex := len(m.stack) - arg - 1
+			callee, ok := m.stack[calleeIndex].(*Function)
+			if !ok {
+				err = fmt.Errorf("Expected function, got %s", typeName(m.stack[calleeIndex]))
+				break
+			}
+			if callee.proto == nil {
+				args := make([]Type, arg)
+				copy(args, m.stack[calleeIndex+1:])
+				m.stack = m.stack[:calleeIndex]
+				var res Type
+				if res, err = callee.Fn(args...); err == nil {
+					m.push(res)
+				}
+				err = withCall(err, callee.Name)
+				break
+			}
+			e := callee.proto.entryFor(arg)
+			if e == nil {
+				err = withCall(arityError(arg, callee.Name), callee.Name)
+				break
+			}
+			if op == opCall && m.tooDeep() {
+				err = withCall(depthError(m.in.maxDepth), callee.Name)
+				break
+			}
+			if err = m.in.step(); err != nil {
+				err = withCall(err, callee.Name)
+				break
+			}
+			if op == opTailCall {
+				// replace the current frame
+				dst := fr.base - 1
+				copy(m.stack[dst:], m.stack[calleeIndex:])
+				m.stack = m.stack[:dst+arg+1]
+				m.frames = m.frames[:len(m.frames)-1]
+				calleeIndex = dst
+			}
+			fr = m.enter(callee, e, calleeIndex+1, arg)
+			p, code, pc, base = fr.proto, fr.proto.code, fr.pc, fr.base
+		case opReturn:
+			res := m.pop()
+			top := len(m.frames) - 1
+			m.stack = m.stack[:fr.base-1]
+			m.frames = m.frames[:top]
+			if top == entry {
+				return res, nil
+			}
+			m.push(res)
+			fr = m.frames[top-1]
+			p, code, pc, base = fr.proto, fr.proto.code, fr.pc, fr.base
+		case opVector:
+			items := make([]Type, arg)
+			copy(items, m.stack[len(m.stack)-arg:])
+			m.stack = m.stack[:len(m.stack)-arg]
+			m.push(NewList(true, items...))
+		case opSet:
+			set := NewSet()
+			for _, item := range m.stack[len(m.stack)-arg:] {
+				set.Add(item)
+			}
+			m.stack = m.stack[:len(m.stack)-arg]
+			m.push(&set)
+		case opMap:
+			entries := m.stack[len(m.stack)-2*arg:]
+			hmap := NewHashMap()
+			for i := 0; i < len(entries) && err == nil; i += 2 {
+				if hmap.Set(entries[i], entries[i+1]); hmap.Len() == i/2 {
+					err = duplicateKeyError(entries[i])
+				}
+			}
+			if err != nil {
+				break
+			}
+			m.stack = m.stack[:len(m.stack)-2*arg]
+			m.push(&hmap)
+		case opMacroexpand:
+			var res Type
+			e := p.consts[arg].(*macroexpandExpr)
+			if res, err = e.expand(e.form, e.ns.env); err == nil {
+				m.push(res)
+			}
+		case opTry:
+			m.handlers = append(m.handlers, handler{frame: len(m.frames) - 1, sp: len(m.stack), catches: p.consts[arg].(*catchTable)})
+		case opEndTry:
+			m.handlers = m.handlers[:len(m.handlers)-1]
+		case opError:
+			err = p.errors[arg]
+		case opRethrow:
+			err = m.pop().(*pendingError).err
+		default:
+			panic(fmt.Sprintf("vm: unknown opcode %d", op))
+		}
+
+		if err != nil {
+			fr.opPC = start
+			fr.pc = pc
+			if err = m.unwind(err, entry); err != nil {
+				return nil, err
+			}
+			fr = m.frames[len(m.frames)-1]
+			p, code, pc, base = fr.proto, fr.proto.code, fr.pc, fr.base
+		}
+	}
+}
+
+//expand replaces the macro on top of the stack, which was called at site by the instruction at pc in the frame
+//fr, by a function of the expansion of the call. It calls that function, to continue after the call once it returns
+func (m *machine) expand(fr *vmFrame, site *callSite, macro *Function, pc int) (*vmFrame, error) {
+	expansion, err := m.in.expand(site, macro)
+	if err != nil {
+		return fr, err
+	}
+	m.stack[len(m.stack)-1] = m.closure(expansion, fr)
+	fr.opPC = pc
+	fr.pc = site.end
+	fn := m.top().(*Function)
+	return m.enter(fn, &fn.proto.entries[0], len(m.stack), 0), nil
+}
+
+//operand returns operand i of the instruction at pc
+func operand(code []byte, pc int, i int) int {
+	return int(code[pc+1+2*i])<<8 | int(code[pc+2+2*i])
+}
+
+func (m *machine) pushBoxed(c *cell, name Type) error {
+	if c.value == nil {
+		symbol := name.(*Symbol)
+		return WithPosition(fmt.Errorf("'%s' not found", symbol.Value), symbol.Pos)
+	}
+	m.push(c.value)
+	return nil
+}
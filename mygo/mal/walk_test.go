@@ -0,0 +1,158 @@
+package mal
+
+import "fmt"
+
+// The AST walker the analyzer and the compilers replaced, as it is still used by the step packages up to step9_try,
+// trimmed down to the special forms the perf tests and the libraries they load use. BenchmarkPerf runs it as the
+// baseline of the evaluators
+
+//walk evaluates ast in env by walking it, expanding macro calls each time they are evaluated
+func walk(ast Type, env *Env) (Type, error) {
+	for {
+		list, ok := ast.(*List)
+		if !ok || list.IsVector || list.Len() == 0 {
+			return walkAst(ast, env)
+		}
+		expanded, err := macroExpand(ast, env)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok = expanded.(*List); !ok || list.IsVector || list.Len() == 0 {
+			return walkAst(expanded, env)
+		}
+		args := list.Slice()[1:]
+		if symb, ok := list.Nth(0).(*Symbol); ok {
+			switch symb.Value {
+			case "def!":
+				value, err := walk(args[1], env)
+				if err != nil {
+					return nil, err
+				}
+				env.Set(args[0].(*Symbol), value)
+				return value, nil
+			case "let*":
+				env = NewEnv(env, nil, nil)
+				bindings := args[0].(*List).Slice()
+				for i := 0; i+1 < len(bindings); i += 2 {
+					value, err := walk(bindings[i+1], env)
+					if err != nil {
+						return nil, err
+					}
+					env.Set(bindings[i].(*Symbol), value)
+				}
+				ast = args[1]
+				continue
+			case "do":
+				for _, form := range args[:len(args)-1] {
+					if _, err := walk(form, env); err != nil {
+						return nil, err
+					}
+				}
+				ast = args[len(args)-1]
+				continue
+			case "if":
+				cond, err := walk(args[0], env)
+				if err != nil {
+					return nil, err
+				}
+				if isTruthy(cond) {
+					ast = args[1]
+				} else if len(args) > 2 {
+					ast = args[2]
+				} else {
+					return &Nil{}, nil
+				}
+				continue
+			case "defmacro!":
+				value, err := walk(args[1], env)
+				if err != nil {
+					return nil, err
+				}
+				macro := CopyOfFunction(value.(*Function))
+				macro.IsMacro = true
+				env.Set(args[0].(*Symbol), macro)
+				return macro, nil
+			case "fn*":
+				params, body, closure := args[0].(*List).Slice(), args[1], env
+				return &Function{Ast: body, Params: params, Env: closure, Fn: func(args ...Type) (Type, error) {
+					fnEnv, err := NewFnEnv(closure, params, args)
+					if err != nil {
+						return nil, err
+					}
+					return walk(body, fnEnv)
+				}}, nil
+			case "quote":
+				return args[0], nil
+			case "quasiquote":
+				ast = walkQuasiquote(args[0])
+				continue
+			}
+		}
+		evaluated, err := walkAst(list, env)
+		if err != nil {
+			return nil, err
+		}
+		call := evaluated.(*List).Slice()
+		fn, ok := call[0].(*Function)
+		if !ok {
+			return nil, fmt.Errorf("Expected function, got %s", typeName(call[0]))
+		}
+		if fn.Ast == nil {
+			return fn.Fn(call[1:]...)
+		}
+		// a tail call
+		if env, err = NewFnEnv(fn.Env, fn.Params, call[1:]); err != nil {
+			return nil, err
+		}
+		ast = fn.Ast
+	}
+}
+
+//walkAst looks up symbols, evaluates the elements of lists, vectors and maps, and returns other values as they are
+func walkAst(ast Type, env *Env) (Type, error) {
+	switch v := ast.(type) {
+	case *Symbol:
+		value := env.Get(v)
+		if value == nil {
+			return nil, fmt.Errorf("'%s' not found", v.Value)
+		}
+		return value, nil
+	case *List:
+		items := make([]Type, v.Len())
+		for i, item := range v.Slice() {
+			var err error
+			if items[i], err = walk(item, env); err != nil {
+				return nil, err
+			}
+		}
+		return NewList(v.IsVector, items...), nil
+	case *HashMap:
+		hmap := NewHashMap()
+		for _, e := range v.Entries() {
+			value, err := walk(e.Value, env)
+			if err != nil {
+				return nil, err
+			}
+			hmap.Set(e.Key, value)
+		}
+		return &hmap, nil
+	}
+	return ast, nil
+}
+
+//walkQuasiquote expands a quasiquote into the code that builds the quoted form
+func walkQuasiquote(ast Type) Type {
+	list, ok := ast.(*List)
+	if !ok || list.Len() == 0 {
+		return NewList(false, &Symbol{Value: "quote"}, ast)
+	}
+	if symbol, ok := list.Nth(0).(*Symbol); ok && symbol.Value == "unquote" {
+		return list.Nth(1)
+	}
+	if first, ok := list.Nth(0).(*List); ok && first.Len() > 0 {
+		if symbol, ok := first.Nth(0).(*Symbol); ok && symbol.Value == "splice-unquote" {
+			return NewList(false, &Symbol{Value: "concat"}, first.Nth(1), walkQuasiquote(list.Rest()))
+		}
+	}
+	return NewList(false, &Symbol{Value: "cons"}, walkQuasiquote(list.First()), walkQuasiquote(list.Rest()))
+}
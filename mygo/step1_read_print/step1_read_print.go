@@ -25,6 +25,9 @@ func rep(s string) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
+	if ast == nil { //nothing but whitespace and comments
+		return
+	}
 	fmt.Println(mal.PrString(ast, true))
 }
 
@@ -18,7 +18,7 @@ func read(s string) (mal.Type, error) {
 func eval(ast mal.Type, replEnv map[string]func(args ...mal.Type) (mal.Type, error)) (mal.Type, error) {
 	switch v := ast.(type) {
 	case *mal.List:
-		if len(v.Value) == 0 {
+		if v.Len() == 0 {
 			return ast, nil
 		}
 		if v.IsVector { //we want to handle vectors the same as the default case
@@ -29,8 +29,8 @@ func eval(ast mal.Type, replEnv map[string]func(args ...mal.Type) (mal.Type, err
 			return nil, err
 		}
 		lst, _ := ev.(*mal.List)
-		fn, _ := lst.Value[0].(*mal.Function)
-		return fn.Fn(lst.Value[1:]...)
+		fn, _ := lst.Nth(0).(*mal.Function)
+		return fn.Fn(lst.Slice()[1:]...)
 
 	default:
 		return evalAst(v, replEnv)
@@ -47,23 +47,23 @@ func evalAst(ast mal.Type, replEnv map[string]func(args ...mal.Type) (mal.Type,
 		}
 		return &mal.Function{Fn: fn}, nil
 	case *mal.List:
-		list := mal.NewList(v.IsVector)
-		for _, val := range v.Value {
+		items := make([]mal.Type, v.Len())
+		for i, val := range v.Slice() {
 			evaled, err := eval(val, replEnv)
 			if err != nil {
 				return nil, err
 			}
-			list.Value = append(list.Value, evaled)
+			items[i] = evaled
 		}
-		return &list, nil
+		return mal.NewList(v.IsVector, items...), nil
 	case *mal.HashMap:
 		hmap := mal.NewHashMap()
-		for key, val := range v.Value {
-			evaled, err := eval(val, replEnv)
+		for _, e := range v.Entries() {
+			evaled, err := eval(e.Value, replEnv)
 			if err != nil {
 				return nil, err
 			}
-			hmap.Value[key] = evaled
+			hmap.Set(e.Key, evaled)
 		}
 		return &hmap, nil
 	default:
@@ -81,22 +81,22 @@ func rep(s string) {
 		"+": func(args ...mal.Type) (mal.Type, error) {
 			a, _ := args[0].(*mal.Number)
 			b, _ := args[1].(*mal.Number)
-			return &mal.Number{Value: a.Value + b.Value}, nil
+			return a.Add(b), nil
 		},
 		"-": func(args ...mal.Type) (mal.Type, error) {
 			a, _ := args[0].(*mal.Number)
 			b, _ := args[1].(*mal.Number)
-			return &mal.Number{Value: a.Value - b.Value}, nil
+			return a.Sub(b), nil
 		},
 		"*": func(args ...mal.Type) (mal.Type, error) {
 			a, _ := args[0].(*mal.Number)
 			b, _ := args[1].(*mal.Number)
-			return &mal.Number{Value: a.Value * b.Value}, nil
+			return a.Mul(b), nil
 		},
 		"/": func(args ...mal.Type) (mal.Type, error) {
 			a, _ := args[0].(*mal.Number)
 			b, _ := args[1].(*mal.Number)
-			return &mal.Number{Value: a.Value / b.Value}, nil
+			return a.Div(b)
 		},
 	}
 
@@ -105,6 +105,9 @@ func rep(s string) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
+	if ast == nil { //nothing but whitespace and comments
+		return
+	}
 	expr, err := eval(ast, replEnv)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -36,7 +36,7 @@ func setBindingInEnv(env *mal.Env, binding []mal.Type) (mal.Type, error) {
 func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 	switch v := ast.(type) {
 	case *mal.List:
-		if len(v.Value) == 0 {
+		if v.Len() == 0 {
 			return ast, nil
 		}
 		if v.IsVector { //we want to handle vectors the same as the default case
@@ -44,26 +44,26 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 		}
 
 		// if the first element of the list is a symbol, check for special handling, such as "def!"
-		if symb, ok := v.Value[0].(*mal.Symbol); ok {
+		if symb, ok := v.Nth(0).(*mal.Symbol); ok {
 			switch symb.Value {
 			case "def!":
 				//check argument length
-				if len(v.Value) != 3 {
+				if v.Len() != 3 {
 					return nil, fmt.Errorf("'def!' expects exactly 2 paramters")
 				}
-				return setBindingInEnv(env, v.Value[1:])
+				return setBindingInEnv(env, v.Slice()[1:])
 			case "let*":
 				newEnv := mal.NewEnv(env, nil, nil)
-				if len(v.Value) < 3 {
+				if v.Len() < 3 {
 					return nil, fmt.Errorf("'let*' expects at least 2 paramters")
 				}
-				if bindings, ok := v.Value[1].(*mal.List); ok {
-					for i := 0; i < len(bindings.Value)/2; i++ {
+				if bindings, ok := v.Nth(1).(*mal.List); ok {
+					for i := 0; i < bindings.Len()/2; i++ {
 						idx := (i * 2)
-						setBindingInEnv(newEnv, bindings.Value[idx:idx+2])
+						setBindingInEnv(newEnv, bindings.Slice()[idx:idx+2])
 					}
 
-					return eval(v.Value[2], newEnv)
+					return eval(v.Nth(2), newEnv)
 				}
 				return nil, fmt.Errorf("'let!': invalid arguments")
 			}
@@ -74,8 +74,8 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 			return nil, err
 		}
 		lst, _ := ev.(*mal.List)
-		fn, _ := lst.Value[0].(*mal.Function)
-		return fn.Fn(lst.Value[1:]...)
+		fn, _ := lst.Nth(0).(*mal.Function)
+		return fn.Fn(lst.Slice()[1:]...)
 
 	default:
 		return evalAst(v, env)
@@ -91,15 +91,15 @@ func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
 		}
 		return val, nil
 	case *mal.List:
-		list := mal.NewList(v.IsVector)
-		for _, val := range v.Value {
+		items := make([]mal.Type, v.Len())
+		for i, val := range v.Slice() {
 			evaled, err := eval(val, env)
 			if err != nil {
 				return nil, err
 			}
-			list.Value = append(list.Value, evaled)
+			items[i] = evaled
 		}
-		return &list, nil
+		return mal.NewList(v.IsVector, items...), nil
 	default:
 		return ast, nil
 	}
@@ -114,22 +114,22 @@ func createREPLEnv() *mal.Env {
 	replEnv.Set(&mal.Symbol{Value: "+"}, &mal.Function{Fn: func(args ...mal.Type) (mal.Type, error) {
 		a, _ := args[0].(*mal.Number)
 		b, _ := args[1].(*mal.Number)
-		return &mal.Number{Value: a.Value + b.Value}, nil
+		return a.Add(b), nil
 	}})
 	replEnv.Set(&mal.Symbol{Value: "-"}, &mal.Function{Fn: func(args ...mal.Type) (mal.Type, error) {
 		a, _ := args[0].(*mal.Number)
 		b, _ := args[1].(*mal.Number)
-		return &mal.Number{Value: a.Value - b.Value}, nil
+		return a.Sub(b), nil
 	}})
 	replEnv.Set(&mal.Symbol{Value: "*"}, &mal.Function{Fn: func(args ...mal.Type) (mal.Type, error) {
 		a, _ := args[0].(*mal.Number)
 		b, _ := args[1].(*mal.Number)
-		return &mal.Number{Value: a.Value * b.Value}, nil
+		return a.Mul(b), nil
 	}})
 	replEnv.Set(&mal.Symbol{Value: "/"}, &mal.Function{Fn: func(args ...mal.Type) (mal.Type, error) {
 		a, _ := args[0].(*mal.Number)
 		b, _ := args[1].(*mal.Number)
-		return &mal.Number{Value: a.Value / b.Value}, nil
+		return a.Div(b)
 	}})
 	return replEnv
 }
@@ -141,6 +141,9 @@ func rep(s string, env *mal.Env) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
+	if ast == nil { //nothing but whitespace and comments
+		return
+	}
 	expr, err := eval(ast, env)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -36,7 +36,7 @@ func setBindingInEnv(env *mal.Env, binding []mal.Type) (mal.Type, error) {
 func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 	switch v := ast.(type) {
 	case *mal.List:
-		if len(v.Value) == 0 {
+		if v.Len() == 0 {
 			return ast, nil
 		}
 		if v.IsVector { //we want to handle vectors the same as the default case
@@ -44,31 +44,31 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 		}
 
 		// if the first element of the list is a symbol, check for special handling, such as "def!"
-		if symb, ok := v.Value[0].(*mal.Symbol); ok {
+		if symb, ok := v.Nth(0).(*mal.Symbol); ok {
 			switch symb.Value {
 			case "def!":
 				//check argument length
-				if len(v.Value) != 3 {
+				if v.Len() != 3 {
 					return nil, fmt.Errorf("'def!' expects exactly 2 paramters")
 				}
-				return setBindingInEnv(env, v.Value[1:])
+				return setBindingInEnv(env, v.Slice()[1:])
 			case "let*":
 				newEnv := mal.NewEnv(env, nil, nil)
-				if len(v.Value) < 3 {
+				if v.Len() < 3 {
 					return nil, fmt.Errorf("'let*' expects at least 2 paramters")
 				}
-				if bindings, ok := v.Value[1].(*mal.List); ok {
-					for i := 0; i < len(bindings.Value)/2; i++ {
+				if bindings, ok := v.Nth(1).(*mal.List); ok {
+					for i := 0; i < bindings.Len()/2; i++ {
 						idx := (i * 2)
-						setBindingInEnv(newEnv, bindings.Value[idx:idx+2])
+						setBindingInEnv(newEnv, bindings.Slice()[idx:idx+2])
 					}
 
-					return eval(v.Value[2], newEnv)
+					return eval(v.Nth(2), newEnv)
 				}
 				return nil, fmt.Errorf("'let!': invalid arguments")
 			case "do":
 				var lastResult mal.Type
-				for _, val := range v.Value[1:] {
+				for _, val := range v.Slice()[1:] {
 					var err error
 					lastResult, err = eval(val, env)
 					if err != nil {
@@ -77,7 +77,7 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 				}
 				return lastResult, nil
 			case "if":
-				r, err := eval(v.Value[1], env)
+				r, err := eval(v.Nth(1), env)
 				if err != nil {
 					return nil, err
 				}
@@ -89,29 +89,35 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 					evaluatedTo = false
 				}
 				if evaluatedTo == true {
-					r, err := eval(v.Value[2], env)
+					r, err := eval(v.Nth(2), env)
 					if err != nil {
 						return nil, err
 					}
 					return r, nil
 				}
 				//condition evaluated to false, check if we have a branch for false, and execute it, if so
-				if len(v.Value) < 4 {
+				if v.Len() < 4 {
 					return &mal.Nil{}, nil
 				}
-				r, err = eval(v.Value[3], env)
+				r, err = eval(v.Nth(3), env)
 				if err != nil {
 					return nil, err
 				}
 				return r, nil
 			case "fn*":
+				bindings, ok := v.Nth(1).(*mal.List)
+				if !ok {
+					return nil, fmt.Errorf("Invalid bindings to fn*")
+				}
+				if err := mal.CheckParams(bindings.Slice()); err != nil {
+					return nil, err
+				}
 				return &mal.Function{Fn: func(args ...mal.Type) (mal.Type, error) {
-					bindings, ok := v.Value[1].(*mal.List)
-					if !ok {
-						return nil, fmt.Errorf("Invalid bindings to fn*")
+					fnEnv, err := mal.NewFnEnv(env, bindings.Slice(), args)
+					if err != nil {
+						return nil, err
 					}
-					fnEnv := mal.NewEnv(env, bindings.Value, args)
-					return eval(v.Value[2], fnEnv)
+					return eval(v.Nth(2), fnEnv)
 				}}, nil
 			}
 		}
@@ -121,8 +127,8 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 			return nil, err
 		}
 		lst, _ := ev.(*mal.List)
-		fn, _ := lst.Value[0].(*mal.Function)
-		return fn.Fn(lst.Value[1:]...)
+		fn, _ := lst.Nth(0).(*mal.Function)
+		return fn.Fn(lst.Slice()[1:]...)
 
 	default:
 		return evalAst(v, env)
@@ -138,15 +144,15 @@ func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
 		}
 		return val, nil
 	case *mal.List:
-		list := mal.NewList(v.IsVector)
-		for _, val := range v.Value {
+		items := make([]mal.Type, v.Len())
+		for i, val := range v.Slice() {
 			evaled, err := eval(val, env)
 			if err != nil {
 				return nil, err
 			}
-			list.Value = append(list.Value, evaled)
+			items[i] = evaled
 		}
-		return &list, nil
+		return mal.NewList(v.IsVector, items...), nil
 	default:
 		return ast, nil
 	}
@@ -171,6 +177,9 @@ func rep(s string, env *mal.Env, doPrint bool) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
+	if ast == nil { //nothing but whitespace and comments
+		return
+	}
 	expr, err := eval(ast, env)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -37,7 +37,7 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 tailcalloptimized:
 	switch v := ast.(type) {
 	case *mal.List:
-		if len(v.Value) == 0 {
+		if v.Len() == 0 {
 			return ast, nil
 		}
 		if v.IsVector { //we want to handle vectors the same as the default case
@@ -45,42 +45,42 @@ tailcalloptimized:
 		}
 
 		// if the first element of the list is a symbol, check for special handling, such as "def!"
-		if symb, ok := v.Value[0].(*mal.Symbol); ok {
+		if symb, ok := v.Nth(0).(*mal.Symbol); ok {
 			switch symb.Value {
 			case "def!":
 				//check argument length
-				if len(v.Value) != 3 {
+				if v.Len() != 3 {
 					return nil, fmt.Errorf("'def!' expects exactly 2 paramters")
 				}
-				return setBindingInEnv(env, v.Value[1:])
+				return setBindingInEnv(env, v.Slice()[1:])
 			case "let*":
 				newEnv := mal.NewEnv(env, nil, nil)
-				if len(v.Value) < 3 {
+				if v.Len() < 3 {
 					return nil, fmt.Errorf("'let*' expects at least 2 paramters")
 				}
-				if bindings, ok := v.Value[1].(*mal.List); ok {
-					for i := 0; i < len(bindings.Value)/2; i++ {
+				if bindings, ok := v.Nth(1).(*mal.List); ok {
+					for i := 0; i < bindings.Len()/2; i++ {
 						idx := (i * 2)
-						setBindingInEnv(newEnv, bindings.Value[idx:idx+2])
+						setBindingInEnv(newEnv, bindings.Slice()[idx:idx+2])
 					}
 
 					env = newEnv
-					ast = v.Value[2]
+					ast = v.Nth(2)
 					goto tailcalloptimized
 				}
 				return nil, fmt.Errorf("'let!': invalid arguments")
 			case "do":
-				for _, val := range v.Value[1 : len(v.Value)-1] {
+				for _, val := range v.Slice()[1 : v.Len()-1] {
 					var err error
 					_, err = eval(val, env)
 					if err != nil {
 						return nil, err
 					}
 				}
-				ast = v.Value[len(v.Value)-1]
+				ast = v.Nth(v.Len() - 1)
 				goto tailcalloptimized
 			case "if":
-				r, err := eval(v.Value[1], env)
+				r, err := eval(v.Nth(1), env)
 				if err != nil {
 					return nil, err
 				}
@@ -92,36 +92,42 @@ tailcalloptimized:
 					evaluatedTo = false
 				}
 				if evaluatedTo == true {
-					ast = v.Value[2]
+					ast = v.Nth(2)
 					goto tailcalloptimized
 				}
 				//condition evaluated to false, check if we have a branch for false, and execute it, if so
-				if len(v.Value) < 4 {
+				if v.Len() < 4 {
 					return &mal.Nil{}, nil
 				}
-				ast = v.Value[3]
+				ast = v.Nth(3)
 				goto tailcalloptimized
 			case "fn*":
 				var bindings []mal.Type
-				listBindings, ok := v.Value[1].(*mal.List)
+				listBindings, ok := v.Nth(1).(*mal.List)
 				if ok {
-					bindings = listBindings.Value
+					bindings = listBindings.Slice()
 				} else {
 					return nil, fmt.Errorf("Invalid bindings to fn*")
 				}
+				if err := mal.CheckParams(listBindings.Slice()); err != nil {
+					return nil, err
+				}
 
 				return &mal.Function{
-					Ast:    v.Value[2],
+					Ast:    v.Nth(2),
 					Params: bindings,
 					Env:    env,
 					Fn: func(args ...mal.Type) (mal.Type, error) {
-						fnEnv := mal.NewEnv(env, listBindings.Value, args)
-						return eval(v.Value[2], fnEnv)
+						fnEnv, err := mal.NewFnEnv(env, listBindings.Slice(), args)
+						if err != nil {
+							return nil, err
+						}
+						return eval(v.Nth(2), fnEnv)
 					}}, nil
 			case "quote":
-				return v.Value[1], nil
+				return v.Nth(1), nil
 			case "quasiquote":
-				ast = quasiquote(v.Value[1])
+				ast = quasiquote(v.Nth(1))
 				goto tailcalloptimized
 			}
 		}
@@ -131,16 +137,19 @@ tailcalloptimized:
 			return nil, err
 		}
 		lst, _ := ev.(*mal.List)
-		fn, _ := lst.Value[0].(*mal.Function)
+		fn, _ := lst.Nth(0).(*mal.Function)
 		//if we have an AST (and params/env), we can TCO this function!
 		if fn.Ast != nil {
 			ast = fn.Ast
 			//update the env for the function
-			env = mal.NewEnv(fn.Env, fn.Params, lst.Value[1:])
+			env, err = mal.NewFnEnv(fn.Env, fn.Params, lst.Slice()[1:])
+			if err != nil {
+				return nil, err
+			}
 			goto tailcalloptimized
 		}
 		//cannot TCO this (e.g. call to native function)
-		return fn.Fn(lst.Value[1:]...)
+		return fn.Fn(lst.Slice()[1:]...)
 
 	default:
 		return evalAst(v, env)
@@ -157,23 +166,23 @@ func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
 		}
 		return val, nil
 	case *mal.List:
-		list := mal.NewList(v.IsVector)
-		for _, val := range v.Value {
+		items := make([]mal.Type, v.Len())
+		for i, val := range v.Slice() {
 			evaled, err := eval(val, env)
 			if err != nil {
 				return nil, err
 			}
-			list.Value = append(list.Value, evaled)
+			items[i] = evaled
 		}
-		return &list, nil
+		return mal.NewList(v.IsVector, items...), nil
 	case *mal.HashMap:
 		hmap := mal.NewHashMap()
-		for key, val := range v.Value {
-			evaled, err := eval(val, env)
+		for _, e := range v.Entries() {
+			evaled, err := eval(e.Value, env)
 			if err != nil {
 				return nil, err
 			}
-			hmap.Value[key] = evaled
+			hmap.Set(e.Key, evaled)
 		}
 		return &hmap, nil
 	default:
@@ -185,42 +194,27 @@ func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
 //I haven't tried understanding this function in detail yet
 func quasiquote(ast mal.Type) mal.Type {
 	if !isPair(ast) {
-		newLst := mal.NewList(false)
-		newLst.Value = append(newLst.Value, &mal.Symbol{Value: "quote"})
-		newLst.Value = append(newLst.Value, ast)
-		return &newLst
+		return mal.NewList(false, &mal.Symbol{Value: "quote"}, ast)
 	}
 	astLst, _ := ast.(*mal.List)
-	if symbol, ok := astLst.Value[0].(*mal.Symbol); ok && symbol.Value == "unquote" {
-		return astLst.Value[1]
+	if symbol, ok := astLst.Nth(0).(*mal.Symbol); ok && symbol.Value == "unquote" {
+		return astLst.Nth(1)
 	}
 
-	if isPair(astLst.Value[0]) {
-		if l2, ok := astLst.Value[0].(*mal.List); ok && isPair(l2) {
-			if symb, ok := l2.Value[0].(*mal.Symbol); ok && symb.Value == "splice-unquote" {
-				newLst := mal.NewList(false)
-				newLst.Value = append(newLst.Value, &mal.Symbol{Value: "concat"})
-				newLst.Value = append(newLst.Value, l2.Value[1])
-				tmp := mal.NewList(false)
-				tmp.Value = append(tmp.Value, astLst.Value[1:]...)
-				newLst.Value = append(newLst.Value, quasiquote(&tmp))
-				return &newLst
+	if isPair(astLst.Nth(0)) {
+		if l2, ok := astLst.Nth(0).(*mal.List); ok && isPair(l2) {
+			if symb, ok := l2.Nth(0).(*mal.Symbol); ok && symb.Value == "splice-unquote" {
+				return mal.NewList(false, &mal.Symbol{Value: "concat"}, l2.Nth(1), quasiquote(astLst.Rest()))
 			}
 		}
 	}
 
-	newLst := mal.NewList(false)
-	newLst.Value = append(newLst.Value, &mal.Symbol{Value: "cons"})
-	newLst.Value = append(newLst.Value, quasiquote(astLst.Value[0]))
-	tmp := mal.NewList(false)
-	tmp.Value = append(tmp.Value, astLst.Value[1:]...)
-	newLst.Value = append(newLst.Value, quasiquote(&tmp))
-	return &newLst
+	return mal.NewList(false, &mal.Symbol{Value: "cons"}, quasiquote(astLst.First()), quasiquote(astLst.Rest()))
 }
 
 func isPair(ast mal.Type) bool {
 	if lst, ok := ast.(*mal.List); ok {
-		if len(lst.Value) != 0 {
+		if lst.Len() != 0 {
 			return true
 		}
 	}
@@ -254,6 +248,9 @@ func rep(s string, env *mal.Env, doPrint bool) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
+	if ast == nil { //nothing but whitespace and comments
+		return
+	}
 	expr, err := eval(ast, env)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
@@ -273,15 +270,15 @@ func main() {
 	env := createREPLEnv()
 
 	if len(args) > 0 {
-		argList := mal.NewList(false)
+		var argList []mal.Type
 		for _, val := range args[1:] {
-			argList.Value = append(argList.Value, &mal.String{Value: val})
+			argList = append(argList, &mal.String{Value: val})
 		}
-		env.Set(&mal.Symbol{Value: "*ARGV*"}, &argList)
+		env.Set(&mal.Symbol{Value: "*ARGV*"}, mal.NewList(false, argList...))
 		rep(`(load-file "`+args[0]+`" )`, env, false)
 		return
 	}
-	env.Set(&mal.Symbol{Value: "*ARGV*"}, &mal.List{})
+	env.Set(&mal.Symbol{Value: "*ARGV*"}, mal.NewList(false))
 
 	if *usePlainStdin {
 		stdinREPL(env)
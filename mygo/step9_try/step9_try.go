@@ -35,10 +35,10 @@ func setBindingInEnv(env *mal.Env, binding []mal.Type) (mal.Type, error) {
 
 func isMacroCall(ast mal.Type, env *mal.Env) bool {
 	astLst, isList := ast.(*mal.List)
-	if !isList || len(astLst.Value) == 0 {
+	if !isList || astLst.Len() == 0 {
 		return false
 	}
-	symbol, hasSymbolFirst := astLst.Value[0].(*mal.Symbol)
+	symbol, hasSymbolFirst := astLst.Nth(0).(*mal.Symbol)
 	if !hasSymbolFirst {
 		return false
 	}
@@ -51,9 +51,9 @@ func isMacroCall(ast mal.Type, env *mal.Env) bool {
 func macroExpand(ast mal.Type, env *mal.Env) (mal.Type, error) {
 	for isMacroCall(ast, env) {
 		astLst, _ := ast.(*mal.List)
-		symbol, _ := astLst.Value[0].(*mal.Symbol)
+		symbol, _ := astLst.Nth(0).(*mal.Symbol)
 		fn, _ := env.Get(symbol).(*mal.Function)
-		r, err := fn.Fn(astLst.Value[1:]...)
+		r, err := fn.Fn(astLst.Slice()[1:]...)
 		ast = r
 		if err != nil {
 			return nil, err
@@ -71,7 +71,7 @@ func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
 tailcalloptimized:
 	switch astList := ast.(type) {
 	case *mal.List:
-		if len(astList.Value) == 0 {
+		if astList.Len() == 0 {
 			return ast, nil
 		}
 		if astList.IsVector { //we want to handle vectors the same as the default case
@@ -89,54 +89,54 @@ tailcalloptimized:
 		}
 
 		// if the first element of the list is a symbol, check for special handling, such as "def!"
-		if symb, ok := astList.Value[0].(*mal.Symbol); ok {
+		if symb, ok := astList.Nth(0).(*mal.Symbol); ok {
 			switch symb.Value {
 			case "def!":
 				//check argument length
-				if len(astList.Value) != 3 {
+				if astList.Len() != 3 {
 					return nil, fmt.Errorf("'def!' expects exactly 2 paramters")
 				}
-				return setBindingInEnv(env, astList.Value[1:])
+				return setBindingInEnv(env, astList.Slice()[1:])
 			case "defmacro!":
-				evaledFunction, err := eval(astList.Value[2], env)
+				evaledFunction, err := eval(astList.Nth(2), env)
 				if err != nil {
 					return nil, err
 				}
 				if fn, ok := evaledFunction.(*mal.Function); ok {
 					fn.IsMacro = true
-					symb, _ := astList.Value[1].(*mal.Symbol)
+					symb, _ := astList.Nth(1).(*mal.Symbol)
 					env.Set(symb, fn)
 					return fn, nil
 				}
 				return nil, fmt.Errorf("Argument 2 to defmacro! must be a function")
 			case "let*":
 				newEnv := mal.NewEnv(env, nil, nil)
-				if len(astList.Value) < 3 {
+				if astList.Len() < 3 {
 					return nil, fmt.Errorf("'let*' expects at least 2 paramters")
 				}
-				if bindings, ok := astList.Value[1].(*mal.List); ok {
-					for i := 0; i < len(bindings.Value)/2; i++ {
+				if bindings, ok := astList.Nth(1).(*mal.List); ok {
+					for i := 0; i < bindings.Len()/2; i++ {
 						idx := (i * 2)
-						setBindingInEnv(newEnv, bindings.Value[idx:idx+2])
+						setBindingInEnv(newEnv, bindings.Slice()[idx:idx+2])
 					}
 
 					env = newEnv
-					ast = astList.Value[2]
+					ast = astList.Nth(2)
 					goto tailcalloptimized
 				}
 				return nil, fmt.Errorf("'let!': invalid arguments")
 			case "do":
-				for _, val := range astList.Value[1 : len(astList.Value)-1] {
+				for _, val := range astList.Slice()[1 : astList.Len()-1] {
 					var err error
 					_, err = eval(val, env)
 					if err != nil {
 						return nil, err
 					}
 				}
-				ast = astList.Value[len(astList.Value)-1]
+				ast = astList.Nth(astList.Len() - 1)
 				goto tailcalloptimized
 			case "if":
-				r, err := eval(astList.Value[1], env)
+				r, err := eval(astList.Nth(1), env)
 				if err != nil {
 					return nil, err
 				}
@@ -148,56 +148,62 @@ tailcalloptimized:
 					evaluatedTo = false
 				}
 				if evaluatedTo == true {
-					ast = astList.Value[2]
+					ast = astList.Nth(2)
 					goto tailcalloptimized
 				}
 				//condition evaluated to false, check if we have a branch for false, and execute it, if so
-				if len(astList.Value) < 4 {
+				if astList.Len() < 4 {
 					return &mal.Nil{}, nil
 				}
-				ast = astList.Value[3]
+				ast = astList.Nth(3)
 				goto tailcalloptimized
 			case "fn*":
 				var bindings []mal.Type
-				listBindings, ok := astList.Value[1].(*mal.List)
+				listBindings, ok := astList.Nth(1).(*mal.List)
 				if ok {
-					bindings = listBindings.Value
+					bindings = listBindings.Slice()
 				} else {
 					return nil, fmt.Errorf("Invalid bindings to fn*")
 				}
+				if err := mal.CheckParams(listBindings.Slice()); err != nil {
+					return nil, err
+				}
 
 				return &mal.Function{
-					Ast:    astList.Value[2],
+					Ast:    astList.Nth(2),
 					Params: bindings,
 					Env:    env,
 					Fn: func(args ...mal.Type) (mal.Type, error) {
-						fnEnv := mal.NewEnv(env, listBindings.Value, args)
-						r, err := eval(astList.Value[2], fnEnv)
+						fnEnv, err := mal.NewFnEnv(env, listBindings.Slice(), args)
+						if err != nil {
+							return nil, err
+						}
+						r, err := eval(astList.Nth(2), fnEnv)
 						return r, err
 					}}, nil
 			case "quote":
-				return astList.Value[1], nil
+				return astList.Nth(1), nil
 			case "quasiquote":
-				ast = quasiquote(astList.Value[1])
+				ast = quasiquote(astList.Nth(1))
 				goto tailcalloptimized
 			case "macroexpand":
-				return macroExpand(astList.Value[1], env)
+				return macroExpand(astList.Nth(1), env)
 			case "try*":
-				r, err := eval(astList.Value[1], env)
-				if err != nil && len(astList.Value) >= 3 {
-					catchBlock, ok := astList.Value[2].(*mal.List)
+				r, err := eval(astList.Nth(1), env)
+				if err != nil && astList.Len() >= 3 {
+					catchBlock, ok := astList.Nth(2).(*mal.List)
 					if !ok {
 						return r, err
 					}
-					if symb, ok := catchBlock.Value[0].(*mal.Symbol); ok && symb.Value == "catch*" {
-						bind, _ := catchBlock.Value[1].(*mal.Symbol)
+					if symb, ok := catchBlock.Nth(0).(*mal.Symbol); ok && symb.Value == "catch*" {
+						bind, _ := catchBlock.Nth(1).(*mal.Symbol)
 						exEnv := mal.NewEnv(env, nil, nil)
 						if malErr, ok := err.(*mal.Error); ok {
 							exEnv.Set(bind, malErr.Value)
 						} else {
 							exEnv.Set(bind, &mal.String{Value: err.Error()})
 						}
-						ast = catchBlock.Value[2]
+						ast = catchBlock.Nth(2)
 						env = exEnv
 						goto tailcalloptimized
 					}
@@ -210,19 +216,22 @@ tailcalloptimized:
 			return nil, err
 		}
 		lst, _ := ev.(*mal.List)
-		fn, isFN := lst.Value[0].(*mal.Function)
+		fn, isFN := lst.Nth(0).(*mal.Function)
 		if !isFN {
-			return nil, fmt.Errorf("Expected function, got %T", lst.Value[0])
+			return nil, fmt.Errorf("Expected function, got %T", lst.Nth(0))
 		}
 		//if we have an AST (and params/env), we can TCO this function!
 		if fn.Ast != nil {
 			ast = fn.Ast
 			//update the env for the function
-			env = mal.NewEnv(fn.Env, fn.Params, lst.Value[1:])
+			env, err = mal.NewFnEnv(fn.Env, fn.Params, lst.Slice()[1:])
+			if err != nil {
+				return nil, err
+			}
 			goto tailcalloptimized
 		}
 		//cannot TCO this (e.g. call to native function)
-		return fn.Fn(lst.Value[1:]...)
+		return fn.Fn(lst.Slice()[1:]...)
 
 	default:
 		return evalAst(astList, env)
@@ -239,23 +248,23 @@ func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
 		}
 		return val, nil
 	case *mal.List:
-		list := mal.NewList(v.IsVector)
-		for _, val := range v.Value {
+		items := make([]mal.Type, v.Len())
+		for i, val := range v.Slice() {
 			evaled, err := eval(val, env)
 			if err != nil {
 				return nil, err
 			}
-			list.Value = append(list.Value, evaled)
+			items[i] = evaled
 		}
-		return &list, nil
+		return mal.NewList(v.IsVector, items...), nil
 	case *mal.HashMap:
 		hmap := mal.NewHashMap()
-		for key, val := range v.Value {
-			evaled, err := eval(val, env)
+		for _, e := range v.Entries() {
+			evaled, err := eval(e.Value, env)
 			if err != nil {
 				return nil, err
 			}
-			hmap.Value[key] = evaled
+			hmap.Set(e.Key, evaled)
 		}
 		return &hmap, nil
 	default:
@@ -267,42 +276,27 @@ func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
 //I haven't tried understanding this function in detail yet
 func quasiquote(ast mal.Type) mal.Type {
 	if !isPair(ast) {
-		newLst := mal.NewList(false)
-		newLst.Value = append(newLst.Value, &mal.Symbol{Value: "quote"})
-		newLst.Value = append(newLst.Value, ast)
-		return &newLst
+		return mal.NewList(false, &mal.Symbol{Value: "quote"}, ast)
 	}
 	astLst, _ := ast.(*mal.List)
-	if symbol, ok := astLst.Value[0].(*mal.Symbol); ok && symbol.Value == "unquote" {
-		return astLst.Value[1]
+	if symbol, ok := astLst.Nth(0).(*mal.Symbol); ok && symbol.Value == "unquote" {
+		return astLst.Nth(1)
 	}
 
-	if isPair(astLst.Value[0]) {
-		if l2, ok := astLst.Value[0].(*mal.List); ok && isPair(l2) {
-			if symb, ok := l2.Value[0].(*mal.Symbol); ok && symb.Value == "splice-unquote" {
-				newLst := mal.NewList(false)
-				newLst.Value = append(newLst.Value, &mal.Symbol{Value: "concat"})
-				newLst.Value = append(newLst.Value, l2.Value[1])
-				tmp := mal.NewList(false)
-				tmp.Value = append(tmp.Value, astLst.Value[1:]...)
-				newLst.Value = append(newLst.Value, quasiquote(&tmp))
-				return &newLst
+	if isPair(astLst.Nth(0)) {
+		if l2, ok := astLst.Nth(0).(*mal.List); ok && isPair(l2) {
+			if symb, ok := l2.Nth(0).(*mal.Symbol); ok && symb.Value == "splice-unquote" {
+				return mal.NewList(false, &mal.Symbol{Value: "concat"}, l2.Nth(1), quasiquote(astLst.Rest()))
 			}
 		}
 	}
 
-	newLst := mal.NewList(false)
-	newLst.Value = append(newLst.Value, &mal.Symbol{Value: "cons"})
-	newLst.Value = append(newLst.Value, quasiquote(astLst.Value[0]))
-	tmp := mal.NewList(false)
-	tmp.Value = append(tmp.Value, astLst.Value[1:]...)
-	newLst.Value = append(newLst.Value, quasiquote(&tmp))
-	return &newLst
+	return mal.NewList(false, &mal.Symbol{Value: "cons"}, quasiquote(astLst.First()), quasiquote(astLst.Rest()))
 }
 
 func isPair(ast mal.Type) bool {
 	if lst, ok := ast.(*mal.List); ok {
-		if len(lst.Value) != 0 {
+		if lst.Len() != 0 {
 			return true
 		}
 	}
@@ -337,6 +331,9 @@ func rep(s string, env *mal.Env, doPrint bool) {
 		fmt.Fprintln(os.Stderr, err.Error())
 		return
 	}
+	if ast == nil { //nothing but whitespace and comments
+		return
+	}
 	expr, err := eval(ast, env)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
@@ -356,15 +353,15 @@ func main() {
 	env := createREPLEnv()
 
 	if len(args) > 0 {
-		argList := mal.NewList(false)
+		var argList []mal.Type
 		for _, val := range args[1:] {
-			argList.Value = append(argList.Value, &mal.String{Value: val})
+			argList = append(argList, &mal.String{Value: val})
 		}
-		env.Set(&mal.Symbol{Value: "*ARGV*"}, &argList)
+		env.Set(&mal.Symbol{Value: "*ARGV*"}, mal.NewList(false, argList...))
 		rep(`(load-file "`+args[0]+`" )`, env, false)
 		return
 	}
-	env.Set(&mal.Symbol{Value: "*ARGV*"}, &mal.List{})
+	env.Set(&mal.Symbol{Value: "*ARGV*"}, mal.NewList(false))
 
 	if *usePlainStdin {
 		stdinREPL(env)
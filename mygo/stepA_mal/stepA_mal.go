@@ -2,388 +2,155 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"mygomal/mal"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
 
 	"github.com/chzyer/readline"
 )
 
-func read(s string) (mal.Type, error) {
-	ast, err := mal.ReadStr(s)
-	if err != nil {
-		return nil, err
-	}
-	return ast, nil
+func print(in *mal.Interpreter, ast mal.Type) {
+	fmt.Fprintln(in.Stdout(), mal.PrString(ast, true))
 }
 
-func setBindingInEnv(env *mal.Env, binding []mal.Type) (mal.Type, error) {
-	//1 argument to def! must be a symbol
-	symbolName, ok := binding[0].(*mal.Symbol)
-	if !ok {
-		return nil, fmt.Errorf("first paramter must be of type Symbol, got %T", binding[0])
+func ep(ast mal.Type, in *mal.Interpreter) {
+	ctx, stop := interruptible()
+	defer stop()
+	expr, err := in.EvalForm(ctx, ast)
+	if err == nil {
+		err = in.Realize(ctx, expr)
 	}
-	ev, err := eval(binding[1], env)
 	if err != nil {
-		return nil, err
-	}
-	//update the environment and set the unevaluated symbol to the evaluated argument
-	env.Set(symbolName, ev)
-	return ev, nil
-}
-
-func isMacroCall(ast mal.Type, env *mal.Env) bool {
-	astLst, isList := ast.(*mal.List)
-	if !isList || len(astLst.Value) == 0 {
-		return false
-	}
-	symbol, hasSymbolFirst := astLst.Value[0].(*mal.Symbol)
-	if !hasSymbolFirst {
-		return false
-	}
-	if fn, ok := env.Get(symbol).(*mal.Function); ok {
-		return fn.IsMacro
-	}
-	return false
-}
-
-func macroExpand(ast mal.Type, env *mal.Env) (mal.Type, error) {
-	for isMacroCall(ast, env) {
-		astLst, _ := ast.(*mal.List)
-		symbol, _ := astLst.Value[0].(*mal.Symbol)
-		fn, _ := env.Get(symbol).(*mal.Function)
-		r, err := fn.Fn(astLst.Value[1:]...)
-		ast = r
-		if err != nil {
-			return nil, err
-		}
-	}
-	return ast, nil
-}
-
-func eval(ast mal.Type, env *mal.Env) (mal.Type, error) {
-tailcalloptimized:
-	switch astList := ast.(type) {
-	case *mal.List:
-		if len(astList.Value) == 0 {
-			return ast, nil
-		}
-		if astList.IsVector { //we want to handle vectors the same as the default case
-			return evalAst(astList, env)
-		}
-
-		r, err := macroExpand(ast, env)
-		if err != nil {
-			return nil, err
-		}
-		astList, isList := r.(*mal.List)
-
-		if !isList {
-			return evalAst(r, env)
-		}
-
-		// if the first element of the list is a symbol, check for special handling, such as "def!"
-		if symb, ok := astList.Value[0].(*mal.Symbol); ok {
-			switch symb.Value {
-			case "def!":
-				//check argument length
-				if len(astList.Value) != 3 {
-					return nil, fmt.Errorf("'def!' expects exactly 2 paramters")
-				}
-				return setBindingInEnv(env, astList.Value[1:])
-			case "defmacro!":
-				evaledFunction, err := eval(astList.Value[2], env)
-				if err != nil {
-					return nil, err
-				}
-				if fn, ok := evaledFunction.(*mal.Function); ok {
-					newFn := mal.CopyOfFunction(fn)
-					newFn.IsMacro = true
-					symb, _ := astList.Value[1].(*mal.Symbol)
-					env.Set(symb, newFn)
-					return newFn, nil
-				}
-				return nil, fmt.Errorf("Argument 2 to defmacro! must be a function")
-			case "let*":
-				newEnv := mal.NewEnv(env, nil, nil)
-				if len(astList.Value) < 3 {
-					return nil, fmt.Errorf("'let*' expects at least 2 paramters")
-				}
-				if bindings, ok := astList.Value[1].(*mal.List); ok {
-					for i := 0; i < len(bindings.Value)/2; i++ {
-						idx := (i * 2)
-						_, err := setBindingInEnv(newEnv, bindings.Value[idx:idx+2])
-						if err != nil {
-							return nil, err
-						}
-					}
-
-					env = newEnv
-					ast = astList.Value[2]
-					goto tailcalloptimized
-				}
-				return nil, fmt.Errorf("'let!': invalid arguments")
-			case "do":
-				for _, val := range astList.Value[1 : len(astList.Value)-1] {
-					var err error
-					_, err = eval(val, env)
-					if err != nil {
-						return nil, err
-					}
-				}
-				ast = astList.Value[len(astList.Value)-1]
-				goto tailcalloptimized
-			case "if":
-				r, err := eval(astList.Value[1], env)
-				if err != nil {
-					return nil, err
-				}
-				evaluatedTo := true
-				if b, ok := r.(*mal.Boolean); ok {
-					evaluatedTo = b.Value
-				}
-				if _, ok := r.(*mal.Nil); ok {
-					evaluatedTo = false
-				}
-				if evaluatedTo == true {
-					ast = astList.Value[2]
-					goto tailcalloptimized
-				}
-				//condition evaluated to false, check if we have a branch for false, and execute it, if so
-				if len(astList.Value) < 4 {
-					return &mal.Nil{}, nil
-				}
-				ast = astList.Value[3]
-				goto tailcalloptimized
-			case "fn*":
-				var bindings []mal.Type
-				listBindings, ok := astList.Value[1].(*mal.List)
-				if ok {
-					bindings = listBindings.Value
-				} else {
-					return nil, fmt.Errorf("Invalid bindings to fn*")
-				}
-
-				return &mal.Function{
-					Ast:    astList.Value[2],
-					Params: bindings,
-					Env:    env,
-					Fn: func(args ...mal.Type) (mal.Type, error) {
-						fnEnv := mal.NewEnv(env, listBindings.Value, args)
-						r, err := eval(astList.Value[2], fnEnv)
-						return r, err
-					}}, nil
-			case "quote":
-				return astList.Value[1], nil
-			case "quasiquote":
-				ast = quasiquote(astList.Value[1])
-				goto tailcalloptimized
-			case "macroexpand":
-				return macroExpand(astList.Value[1], env)
-			case "try*":
-				r, err := eval(astList.Value[1], env)
-				if err != nil && len(astList.Value) >= 3 {
-					catchBlock, ok := astList.Value[2].(*mal.List)
-					if !ok {
-						return r, err
-					}
-					if symb, ok := catchBlock.Value[0].(*mal.Symbol); ok && symb.Value == "catch*" {
-						bind, _ := catchBlock.Value[1].(*mal.Symbol)
-						exEnv := mal.NewEnv(env, nil, nil)
-						if malErr, ok := err.(*mal.Error); ok {
-							exEnv.Set(bind, malErr.Value)
-						} else {
-							exEnv.Set(bind, &mal.String{Value: err.Error()})
-						}
-						ast = catchBlock.Value[2]
-						env = exEnv
-						goto tailcalloptimized
-					}
-				}
-				return r, err
-			}
-		}
-		ev, err := evalAst(astList, env)
-		if err != nil {
-			return nil, err
-		}
-		lst, _ := ev.(*mal.List)
-		fn, isFN := lst.Value[0].(*mal.Function)
-		if !isFN {
-			return nil, fmt.Errorf("Expected function, got %T", lst.Value[0])
-		}
-		//if we have an AST (and params/env), we can TCO this function!
-		if fn.Ast != nil {
-			ast = fn.Ast
-			//update the env for the function
-			env = mal.NewEnv(fn.Env, fn.Params, lst.Value[1:])
-			goto tailcalloptimized
-		}
-		//cannot TCO this (e.g. call to native function)
-		return fn.Fn(lst.Value[1:]...)
-
-	default:
-		return evalAst(astList, env)
+		printError(in, err)
+		return
 	}
-
+	print(in, expr)
 }
 
-func evalAst(ast mal.Type, env *mal.Env) (mal.Type, error) {
-	switch v := ast.(type) {
-	case *mal.Symbol:
-		val := env.Get(v)
-		if val == nil {
-			return nil, fmt.Errorf("'%s' not found", v.Value)
-		}
-		return val, nil
-	case *mal.List:
-		list := mal.NewList(v.IsVector)
-		for _, val := range v.Value {
-			evaled, err := eval(val, env)
-			if err != nil {
-				return nil, err
-			}
-			list.Value = append(list.Value, evaled)
-		}
-		return &list, nil
-	case *mal.HashMap:
-		hmap := mal.NewHashMap()
-		for key, val := range v.Value {
-			evaled, err := eval(val, env)
-			if err != nil {
-				return nil, err
-			}
-			hmap.Value[key] = evaled
-		}
-		return &hmap, nil
-	default:
-		return ast, nil
+//interruptible returns a context that is cancelled when the process is interrupted (Ctrl-C) before stop is
+//called, so that an interrupt aborts the evaluation of a form rather than the REPL
+func interruptible() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interrupts)
+		cancel()
 	}
 }
 
-//Uh yeah... I just implemented https://github.com/kanaka/mal/blob/master/process/guide.md#step7
-//I haven't tried understanding this function in detail yet
-func quasiquote(ast mal.Type) mal.Type {
-	if !isPair(ast) {
-		newLst := mal.NewList(false)
-		newLst.Value = append(newLst.Value, &mal.Symbol{Value: "quote"})
-		newLst.Value = append(newLst.Value, ast)
-		return &newLst
-	}
-	astLst, _ := ast.(*mal.List)
-	if symbol, ok := astLst.Value[0].(*mal.Symbol); ok && symbol.Value == "unquote" {
-		return astLst.Value[1]
-	}
-
-	if isPair(astLst.Value[0]) {
-		if l2, ok := astLst.Value[0].(*mal.List); ok && isPair(l2) {
-			if symb, ok := l2.Value[0].(*mal.Symbol); ok && symb.Value == "splice-unquote" {
-				newLst := mal.NewList(false)
-				newLst.Value = append(newLst.Value, &mal.Symbol{Value: "concat"})
-				newLst.Value = append(newLst.Value, l2.Value[1])
-				tmp := mal.NewList(false)
-				tmp.Value = append(tmp.Value, astLst.Value[1:]...)
-				newLst.Value = append(newLst.Value, quasiquote(&tmp))
-				return &newLst
-			}
-		}
-	}
-
-	newLst := mal.NewList(false)
-	newLst.Value = append(newLst.Value, &mal.Symbol{Value: "cons"})
-	newLst.Value = append(newLst.Value, quasiquote(astLst.Value[0]))
-	tmp := mal.NewList(false)
-	tmp.Value = append(tmp.Value, astLst.Value[1:]...)
-	newLst.Value = append(newLst.Value, quasiquote(&tmp))
-	return &newLst
-}
+//maxStackFrames is the number of calls printError prints at most, e.g. of a runaway recursion
+const maxStackFrames = 20
 
-func isPair(ast mal.Type) bool {
-	if lst, ok := ast.(*mal.List); ok {
-		if len(lst.Value) != 0 {
-			return true
+//printError prints an uncaught error, followed by the innermost calls it propagated out of
+func printError(in *mal.Interpreter, err error) {
+	fmt.Fprintln(in.Stderr(), "Error: "+err.Error())
+	stack := mal.StackOf(err)
+	for i, frame := range stack {
+		if i == maxStackFrames {
+			fmt.Fprintf(in.Stderr(), "  ... %d more\n", len(stack)-i)
+			break
 		}
-	}
-	return false
-}
-
-func print(ast mal.Type) {
-	fmt.Println(mal.PrString(ast, true))
-}
-
-func createREPLEnv() *mal.Env {
-	replEnv := mal.NewEnv(nil, nil, nil)
-	for k, v := range mal.CoreNS {
-		replEnv.Set(k, v)
-	}
-
-	// add some stuff that's not in coreNS, according to guide (?)
-	replEnv.Set(&mal.Symbol{Value: "eval"}, &mal.Function{Fn: func(args ...mal.Type) (mal.Type, error) {
-		return eval(args[0], replEnv)
-	}})
-	rep("(def! not (fn* (a) (if a false true)))", replEnv, false)
-	rep(`(def! load-file (fn* (f) (eval (read-string (str "(do " (slurp f) "\nnil)")))))`, replEnv, false)
-	rep(`(defmacro! cond (fn* (& xs) (if (> (count xs) 0) (list 'if (first xs) (if (> (count xs) 1) (nth xs 1) (throw "odd number of forms to cond")) (cons 'cond (rest (rest xs)))))))`, replEnv, false)
-
-	return replEnv
-}
-
-func rep(s string, env *mal.Env, doPrint bool) {
-
-	ast, err := read(s)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		return
-	}
-	expr, err := eval(ast, env)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error: "+err.Error())
-		return
-	}
-	if doPrint {
-		print(expr)
+		fmt.Fprintln(in.Stderr(), "  at "+frame.String())
 	}
 }
 
 func main() {
 	usePlainStdin := flag.Bool("stdin", false, "don't use nice readline based repl. only for tests, as the nice repl breaks them")
+	useVM := flag.Bool("vm", false, "experimental: compile to bytecode and run it on a virtual machine instead of compiling to closures, which is no faster")
+	maxEvalDepth := flag.Int("max-eval-depth", mal.DefaultMaxEvalDepth, fmt.Sprintf("maximum number of nested calls of mal functions, the initial value of *max-eval-depth*, at most %d", mal.MaxEvalDepthLimit))
+	profileName := flag.String("profile", string(mal.ProfileFull), "native functions available to mal code: full, read-only-fs (no readline, files only in -root) or pure (neither)")
+	root := flag.String("root", "", "directory the read-only-fs profile can read files in, the current directory by default")
+	maxSteps := flag.Int("max-steps", 0, "maximum number of calls and loop iterations of each form evaluated, 0 for no limit")
+	maxCollectionSize := flag.Int("max-collection-size", 0, "maximum number of elements of collections and of characters of strings native functions return, 0 for no limit")
+	maxNumberBits := flag.Int("max-number-bits", 0, "maximum number of bits of integers and of the numerators and denominators of ratios native functions return, 0 for 64 per element of -max-collection-size")
 	flag.Parse()
 
 	args := flag.Args()
 
-	env := createREPLEnv()
-	env.Set(&mal.Symbol{Value: "*host-language*"}, &mal.String{Value: "Go"})
+	profile, err := mal.ParseProfile(*profileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	in := mal.New(mal.Options{
+		VM:                *useVM,
+		MaxEvalDepth:      *maxEvalDepth,
+		Profile:           profile,
+		Root:              *root,
+		MaxSteps:          *maxSteps,
+		MaxCollectionSize: *maxCollectionSize,
+		MaxNumberBits:     *maxNumberBits,
+		// the directories libraries are looked up in, separated like the ones of PATH
+		LibPath: filepath.SplitList(os.Getenv("MALPATH")),
+	})
 
 	if len(args) > 0 {
-		argList := mal.NewList(false)
+		var argList []mal.Type
 		for _, val := range args[1:] {
-			argList.Value = append(argList.Value, &mal.String{Value: val})
+			argList = append(argList, &mal.String{Value: val})
+		}
+		in.Define("*ARGV*", mal.NewList(false, argList...))
+		if _, err := in.LoadFile(context.Background(), args[0]); err != nil {
+			printError(in, err)
 		}
-		env.Set(&mal.Symbol{Value: "*ARGV*"}, &argList)
-		rep(`(load-file "`+args[0]+`" )`, env, false)
 		return
 	}
-	env.Set(&mal.Symbol{Value: "*ARGV*"}, &mal.List{})
-	rep(`(println (str "Mal [" *host-language* "]"))`, env, false)
+	in.Define("*ARGV*", mal.NewList(false))
+	if _, err := in.EvalString(context.Background(), `(println (str "Mal [" *host-language* "]"))`); err != nil {
+		fmt.Fprintln(in.Stderr(), "Error: "+err.Error())
+	}
 
 	if *usePlainStdin {
-		stdinREPL(env)
+		stdinREPL(in)
 		return
 	}
-	niceRepl(env)
+	niceRepl(in)
 }
 
-func stdinREPL(env *mal.Env) {
+//stdinREPL is the REPL of -stdin. Like niceRepl, it keeps reading lines until the input is a complete set of
+//forms, then evaluates all of them, and it ends at the end of the input
+func stdinREPL(in *mal.Interpreter) {
 	stdin := bufio.NewReader(os.Stdin)
+	prompt := "user> "
+	var input strings.Builder
 	for {
-		fmt.Print("user> ")
-		s, _ := stdin.ReadString('\n')
-		rep(s, env, true)
+		fmt.Print(prompt)
+		s, readErr := stdin.ReadString('\n')
+		input.WriteString(s)
+		forms, err := mal.ReadAll(input.String())
+		if errors.Is(err, mal.ErrIncomplete) && readErr == nil {
+			prompt = "  ... "
+			continue
+		}
+		prompt = "user> "
+		input.Reset()
+		if err != nil {
+			fmt.Fprintln(in.Stderr(), err.Error())
+		}
+		for _, ast := range forms {
+			ep(ast, in)
+		}
+		if readErr != nil { // io.EOF
+			return
+		}
 	}
 }
 
-func niceRepl(env *mal.Env) {
+func niceRepl(in *mal.Interpreter) {
 
 	l, err := readline.NewEx(&readline.Config{
 		Prompt:       "user> ",
@@ -397,11 +164,34 @@ func niceRepl(env *mal.Env) {
 	}
 	defer l.Close()
 
+	//keep reading lines until the input is a complete set of forms, then evaluate all of them
+	var input strings.Builder
 	for {
 		s, err := l.Readline()
+		if err == readline.ErrInterrupt {
+			//Ctrl-C discards the input instead of ending the REPL
+			l.SetPrompt("user> ")
+			input.Reset()
+			continue
+		}
 		if err != nil { // io.EOF
 			break
 		}
-		rep(s, env, true)
+		input.WriteString(s)
+		input.WriteString("\n")
+		forms, err := mal.ReadAll(input.String())
+		if errors.Is(err, mal.ErrIncomplete) {
+			l.SetPrompt("  ... ")
+			continue
+		}
+		l.SetPrompt("user> ")
+		input.Reset()
+		if err != nil {
+			fmt.Fprintln(in.Stderr(), err.Error())
+			continue
+		}
+		for _, ast := range forms {
+			ep(ast, in)
+		}
 	}
 }